@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/bundle"
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
+)
+
+type bundleOptions struct {
+	output    string
+	specFile  string
+	auxImages bool
+	spec      spec.Cluster
+	images    images.Set
+}
+
+func (bo bundleOptions) WantsNFS() bool {
+	return bo.auxImages || bo.spec.WantsNFS()
+}
+
+func (bo bundleOptions) WantsCeph() bool {
+	return bo.auxImages || bo.spec.WantsCeph()
+}
+
+func (bo bundleOptions) WantsFluentd() bool {
+	return bo.auxImages || bo.spec.WantsFluentd()
+}
+
+func (bo bundleOptions) AuxImages() images.Set {
+	return bo.images
+}
+
+// NewBundleCommand returns command to move the cluster images to hosts without registry access
+func NewBundleCommand() *cobra.Command {
+	flags := &bundleOptions{}
+
+	bndl := &cobra.Command{
+		Use:   "bundle",
+		Short: "bundle moves the images a cluster needs to hosts without registry access",
+		Long: `bundle moves the images a cluster needs to hosts without registry access
+
+A bundle is a tarball holding the provider image, the auxiliary images and a manifest
+with their digests. Create the bundle on a host with registry access, copy it to the
+air-gapped host and load it there: run will use the loaded images when it can't pull them.
+Image archives can't store the references pinned by digest, so the loaded images get a
+tag made of their digest, e.g. docker.io/ceph/daemon:sha256-939b..., and load sets the
+auxiliary ones in the image configuration (see 'pack8s images --help').
+
+The podman socket must belong to the local host, because the image archives are read and
+written directly.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprint(cmd.OutOrStderr(), cmd.UsageString())
+		},
+	}
+
+	create := &cobra.Command{
+		Use:   "create [PROVIDER] --output bundle.tar",
+		Short: "create pulls the images a cluster needs and stores them in a bundle",
+		Long: `create pulls the images a cluster needs and stores them in a bundle
+
+The docker registry image is always stored. The other auxiliary images are stored if
+--aux-images is given, or if the cluster spec file given with --file needs them.
+The provider image can be omitted from the command line if it is set in the spec file.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return createBundle(cmd, flags, args)
+		},
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	create.Flags().StringVarP(&flags.output, "output", "o", "", "write the bundle to this path")
+	create.Flags().StringVarP(&flags.specFile, "file", "f", "", "store the images needed by the cluster spec in the given YAML or JSON file")
+	create.Flags().BoolVarP(&flags.auxImages, "aux-images", "a", false, "store all the cluster auxiliary images")
+	create.MarkFlagRequired("output")
+
+	load := &cobra.Command{
+		Use:   "load BUNDLE",
+		Short: "load verifies the images stored in the bundle and loads them in the local storage",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return loadBundle(cmd, args[0])
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	bndl.AddCommand(create, load)
+	return bndl
+}
+
+func createBundle(cmd *cobra.Command, bndlOpts *bundleOptions, args []string) (err error) {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	if bndlOpts.specFile != "" {
+		bndlOpts.spec, err = spec.Load(bndlOpts.specFile)
+		if err != nil {
+			return err
+		}
+	}
+	provider := bndlOpts.spec.Provider
+	if len(args) == 1 {
+		provider = args[0]
+	}
+	if provider == "" {
+		return fmt.Errorf("missing provider image")
+	}
+
+	auxImages, err := images.Load()
+	if err != nil {
+		return err
+	}
+	bndlOpts.images = auxImages.Merge(bndlOpts.spec.Images)
+
+	hnd, log, err := cOpts.GetHandle()
+	if err != nil {
+		return err
+	}
+
+	pullConfig, err := images.LoadPullConfig()
+	if err != nil {
+		return err
+	}
+	hnd.SetPullConfig(pullConfig)
+
+	log.Noticef("bundle: downloading all the images needed for %s (from %s)", provider, cOpts.Registry)
+	err = hnd.PullClusterImages(bndlOpts, cOpts.Registry, provider)
+	if err != nil {
+		return err
+	}
+
+	manifest := bundle.Manifest{
+		Version:  bundle.ManifestVersion,
+		Provider: cOpts.Registry + "/" + provider,
+		Created:  time.Now(),
+		Images: []bundle.Image{
+			bundle.Image{
+				Role:      bundle.RoleProvider,
+				Reference: cOpts.Registry + "/" + provider,
+			},
+		},
+	}
+	for _, role := range images.WantedRoles(bndlOpts) {
+		manifest.Images = append(manifest.Images, bundle.Image{
+			Role:      role,
+			Reference: bndlOpts.images.Get(role),
+		})
+	}
+
+	tmpDir, err := ioutil.TempDir("", "pack8s-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the digests go in the manifest, which comes first: export all the images before writing the bundle
+	for idx := range manifest.Images {
+		img := &manifest.Images[idx]
+		img.Tag = images.LocalTag(img.Reference)
+		img.File = bundle.FileName(img.Role)
+		log.Noticef("bundle: exporting image %s", img.Reference)
+		img.ID, err = hnd.ExportImage(img.Reference, filepath.Join(tmpDir, img.File), []string{img.Tag})
+		if err != nil {
+			return err
+		}
+		img.Digest, img.Size, err = bundle.Digest(filepath.Join(tmpDir, img.File))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(bndlOpts.output), 0755); err != nil {
+		return err
+	}
+	// write and rename, so a failed create doesn't leave a truncated bundle around
+	tmpOutput := bndlOpts.output + ".tmp"
+	out, err := os.OpenFile(tmpOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(tmpOutput)
+		}
+	}()
+
+	bw, err := bundle.NewWriter(out, manifest)
+	if err != nil {
+		return err
+	}
+	for _, img := range manifest.Images {
+		if err := bw.AddImage(img, filepath.Join(tmpDir, img.File)); err != nil {
+			return err
+		}
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpOutput, bndlOpts.output); err != nil {
+		return err
+	}
+
+	log.Infof("bundle for %s saved in %s (images=%d)", manifest.Provider, bndlOpts.output, len(manifest.Images))
+	return nil
+}
+
+func loadBundle(cmd *cobra.Command, bundlePath string) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetHandle()
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	br, err := bundle.NewReader(src)
+	if err != nil {
+		return err
+	}
+	log.Noticef("bundle: loading the images of %s, created %s", br.Manifest.Provider, br.Manifest.Created.Format(time.RFC3339))
+
+	tmpDir, err := ioutil.TempDir("", "pack8s-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// verify all the digests before touching the local storage
+	err = br.Extract(func(img bundle.Image) (string, error) {
+		return filepath.Join(tmpDir, img.File), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// references pinned by digest can't be stored in image archives: the auxiliary ones are replaced by their local tag
+	localImages := []images.Image{}
+	for _, img := range br.Manifest.Images {
+		log.Noticef("bundle: loading image %s", img.Tag)
+		if _, err := hnd.LoadImage(filepath.Join(tmpDir, img.File)); err != nil {
+			return err
+		}
+		loaded, err := hnd.GetImage(img.Tag)
+		if err != nil {
+			return err
+		}
+		if loaded.Id != img.ID {
+			return fmt.Errorf("image %s loaded with ID %s, expected %s", img.Tag, loaded.Id, img.ID)
+		}
+		if img.Tag == img.Reference {
+			continue
+		}
+		if img.Role == bundle.RoleProvider {
+			log.Warningf("image %s is available as %s: use it as provider", img.Reference, img.Tag)
+		} else {
+			localImages = append(localImages, images.Image{Role: img.Role, Reference: img.Tag, Source: images.SourceConfig})
+		}
+	}
+
+	if len(localImages) > 0 {
+		confPath, err := images.ConfigPath()
+		if err != nil {
+			return err
+		}
+		if err := images.SetConfigImages(confPath, images.ToSet(localImages)); err != nil {
+			return err
+		}
+		for _, img := range localImages {
+			log.Infof("image %s set as the %s image in %s", img.Reference, img.Role, confPath)
+		}
+	}
+
+	log.Infof("bundle %s loaded (images=%d)", bundlePath, len(br.Manifest.Images))
+	return nil
+}
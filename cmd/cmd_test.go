@@ -0,0 +1,543 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logger "github.com/apsdehal/go-logger"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/cmd"
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
+	"github.com/fromanirh/pack8s/internal/pkg/fakeruntime"
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	prefix   = "pack8s-test"
+	provider = "k8s-1.17"
+)
+
+// pack8s runs the given pack8s command line, and returns what it wrote on its output
+func pack8s(args ...string) (string, error) {
+	var out bytes.Buffer
+	root := cmd.NewRootCommand()
+	root.SetArgs(append([]string{"--prefix", prefix}, args...))
+	root.SetOut(&out)
+	root.SetErr(GinkgoWriter)
+	err := root.Execute()
+	return out.String(), err
+}
+
+// freePort returns a host port nobody is listening on, as pack8s checks the ports it publishes are free
+func freePort() int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func containerNames(rt *fakeruntime.Runtime) []string {
+	names := []string{}
+	for _, cont := range rt.Containers() {
+		names = append(names, cont.Names)
+	}
+	return names
+}
+
+func registeredCluster(path string) (clusters.Cluster, bool) {
+	reg, err := clusters.Load(path)
+	Expect(err).To(BeNil())
+	return reg.Find(prefix, podman.DefaultSocket)
+}
+
+var _ = Describe("commands", func() {
+	var rt *fakeruntime.Runtime
+	var tmpDir string
+	var registryPath string
+	var newRuntime = cmdutil.NewRuntime
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-cmd")
+		Expect(err).To(BeNil())
+		registryPath = filepath.Join(tmpDir, "clusters.json")
+		os.Setenv(clusters.EnvRegistryPath, registryPath)
+		os.Setenv(images.EnvConfigPath, filepath.Join(tmpDir, "images.yaml"))
+		os.Setenv("PACK8S_VERBOSE", "1")
+
+		rt = fakeruntime.New()
+		cmdutil.NewRuntime = func(ctx context.Context, name, socket string, log *logger.Logger) (podman.Runtime, error) {
+			return rt.Clone(ctx), nil
+		}
+	})
+
+	AfterEach(func() {
+		cmdutil.NewRuntime = newRuntime
+		os.Unsetenv(clusters.EnvRegistryPath)
+		os.Unsetenv(images.EnvConfigPath)
+		os.Unsetenv("PACK8S_VERBOSE")
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("run", func() {
+		It("Should bring the cluster up", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			Expect(rt.Pulls()).To(ContainElement("docker.io/" + provider))
+			Expect(containerNames(rt)).To(ConsistOf(prefix+"-dnsmasq", prefix+"-registry", prefix+"-node01"))
+			Expect(rt.Volumes()[0].Name).To(Equal(prefix + "-node01"))
+			for _, cont := range rt.Containers() {
+				Expect(cont.Containerrunning).To(BeTrue())
+			}
+
+			execs := []string{}
+			for _, call := range rt.Execs() {
+				Expect(call.Container).To(Equal(prefix + "-node01"))
+				execs = append(execs, call.Args[len(call.Args)-1])
+			}
+			Expect(execs).To(ContainElement("test -f /ssh_ready"))
+			Expect(execs).To(ContainElement("ssh.sh sudo /bin/bash < /scripts/node01.sh"))
+
+			cluster, found := registeredCluster(registryPath)
+			Expect(found).To(BeTrue())
+			Expect(cluster.Provider).To(Equal(provider))
+		})
+
+		It("Should use the generic provisioning script if the node has none", func() {
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				if strings.HasPrefix(call.Args[len(call.Args)-1], "test -f /scripts/") {
+					return &podman.ExitError{Code: 1}
+				}
+				return nil
+			})
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			last := rt.Execs()[len(rt.Execs())-1]
+			Expect(last.Args[len(last.Args)-1]).To(Equal("ssh.sh sudo /bin/bash < /scripts/nodes.sh"))
+		})
+
+		It("Should roll everything back if provisioning fails", func() {
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				if strings.HasPrefix(call.Args[len(call.Args)-1], "ssh.sh") {
+					return &podman.ExitError{Code: 2}
+				}
+				return nil
+			})
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).NotTo(BeNil())
+
+			Expect(rt.Containers()).To(BeEmpty())
+			Expect(rt.Volumes()).To(BeEmpty())
+			_, found := registeredCluster(registryPath)
+			Expect(found).To(BeFalse())
+		})
+
+		It("Should roll everything back if a container can't be created", func() {
+			rt.Fail("CreateContainer", prefix+"-registry", fmt.Errorf("simulated failure"))
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).NotTo(BeNil())
+
+			Expect(rt.Containers()).To(BeEmpty())
+			_, found := registeredCluster(registryPath)
+			Expect(found).To(BeFalse())
+		})
+
+		It("Should clean up once the nodes exit", func() {
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				if strings.HasPrefix(call.Args[len(call.Args)-1], "ssh.sh") {
+					return rt.Exit(call.Container, 0)
+				}
+				return nil
+			})
+			_, err := pack8s("run", provider)
+			Expect(err).To(BeNil())
+
+			Expect(rt.Containers()).To(BeEmpty())
+			Expect(rt.Volumes()).To(BeEmpty())
+			_, found := registeredCluster(registryPath)
+			Expect(found).To(BeFalse())
+		})
+
+		It("Should refuse to reuse the prefix of an existing cluster", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+			containers := rt.Containers()
+
+			_, err = pack8s("run", "--background", provider)
+			Expect(err).NotTo(BeNil())
+			Expect(rt.Containers()).To(Equal(containers))
+		})
+
+		It("Should check the host ports only if the containers run locally", func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			defer ln.Close()
+			busyPort := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+			_, err = pack8s("run", "--background", "--ssh-port", busyPort, "--random-ports=false", provider)
+			Expect(err).NotTo(BeNil())
+			Expect(rt.Containers()).To(BeEmpty())
+
+			_, err = pack8s("--podman-socket", "ssh://core@example.com/run/podman/io.podman",
+				"run", "--background", "--ssh-port", busyPort, "--random-ports=false", provider)
+			Expect(err).To(BeNil())
+			Expect(containerNames(rt)).To(ConsistOf(prefix+"-dnsmasq", prefix+"-registry", prefix+"-node01"))
+		})
+
+		It("Should point the services to the dnsmasq address on the cluster network", func() {
+			_, err := pack8s("run", "--background", "--enable-ceph", provider)
+			Expect(err).To(BeNil())
+			Expect(containerNames(rt)).To(ContainElement(prefix + "-ceph"))
+
+			for _, cont := range rt.Containers() {
+				switch cont.Names {
+				case prefix + "-dnsmasq":
+					Expect(*cont.Conf.AddHost).To(ContainElement("ceph:192.168.66.2"))
+				case prefix + "-ceph":
+					Expect(*cont.Conf.Env).To(ContainElement("MON_IP=192.168.66.2"))
+				}
+			}
+		})
+
+		It("Should only pull the images with --download-only", func() {
+			_, err := pack8s("run", "--download-only", provider)
+			Expect(err).To(BeNil())
+
+			Expect(rt.Pulls()).To(ContainElement("docker.io/" + provider))
+			Expect(rt.Containers()).To(BeEmpty())
+		})
+	})
+
+	Context("run okd", func() {
+		writeSpec := func(content string) string {
+			path := filepath.Join(tmpDir, "okd.yaml")
+			Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+			return path
+		}
+
+		It("Should dump the effective spec, with the flags taking precedence over the file", func() {
+			path := writeSpec(`
+apiVersion: pack8s/v1alpha1
+provider: okd-4.1
+nodes:
+  count: 3
+  memory: 8G
+  cpu: 4
+  overrides:
+  - role: control-plane
+    memory: 16G
+ports:
+  random: false
+  ssh: 2201
+  ocpConsole: 8443
+`)
+			out, err := pack8s("run", "okd", "-f", path, "--workers-cpu", "6", "--ocp-console-port", "9443", "--dump-spec")
+			Expect(err).To(BeNil())
+			Expect(rt.Containers()).To(BeEmpty())
+
+			cs, err := spec.Read(strings.NewReader(out))
+			Expect(err).To(BeNil())
+			Expect(cs.Provider).To(Equal("okd-4.1"))
+			Expect(cs.Nodes.Count).To(Equal(uint(3)))
+			Expect(cs.Nodes.Resources(1).Memory).To(Equal("16G"))
+			Expect(cs.Nodes.Resources(2).Memory).To(Equal("8G"))
+			Expect(cs.Nodes.Resources(2).CPU).To(Equal(uint(6)))
+			Expect(cs.Ports.Random).To(BeFalse())
+			Expect(cs.Ports.SSH).To(Equal(uint(2201)))
+			Expect(cs.Ports.OCPConsole).To(Equal(uint(9443)))
+			Expect(cs.Ports.OCP).To(BeZero())
+		})
+
+		It("Should run the cluster described by the spec file", func() {
+			path := writeSpec(`
+apiVersion: pack8s/v1alpha1
+provider: okd-4.1
+nodes:
+  count: 2
+  memory: 8G
+  overrides:
+  - role: control-plane
+    memory: 16G
+    cpu: 6
+`)
+			_, err := pack8s("run", "okd", "--background", "-f", path)
+			Expect(err).To(BeNil())
+
+			inspect, err := rt.InspectContainer(prefix + "-cluster")
+			Expect(err).To(BeNil())
+			Expect(inspect.ImageName).To(Equal("okd-4.1"))
+			Expect(inspect.Config.Env).To(ContainElement("WORKERS=1"))
+			Expect(inspect.Config.Env).To(ContainElement("MASTER_MEMORY=16384"))
+			Expect(inspect.Config.Env).To(ContainElement("MASTER_CPU=6"))
+			Expect(inspect.Config.Env).To(ContainElement("WORKERS_MEMORY=8192"))
+		})
+
+		It("Should refuse the settings OKD clusters don't support", func() {
+			path := writeSpec(`
+apiVersion: pack8s/v1alpha1
+provider: okd-4.1
+services:
+  ceph:
+    enabled: true
+ports:
+  ocp: 8443
+`)
+			_, err := pack8s("run", "okd", "-f", path, "--dump-spec")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("services.ceph"))
+			Expect(err.Error()).To(ContainSubstring("ports.ocp"))
+		})
+	})
+
+	Context("rm", func() {
+		It("Should remove the cluster", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			_, err = pack8s("rm")
+			Expect(err).To(BeNil())
+
+			Expect(rt.Containers()).To(BeEmpty())
+			Expect(rt.Volumes()).To(BeEmpty())
+			_, found := registeredCluster(registryPath)
+			Expect(found).To(BeFalse())
+		})
+
+		It("Should remove pod based clusters", func() {
+			_, err := pack8s("run", "--background", "--pod", provider)
+			Expect(err).To(BeNil())
+			Expect(len(rt.Pods())).To(Equal(1))
+
+			_, err = pack8s("rm")
+			Expect(err).To(BeNil())
+
+			Expect(rt.Pods()).To(BeEmpty())
+			Expect(rt.Containers()).To(BeEmpty())
+			Expect(rt.Volumes()).To(BeEmpty())
+		})
+
+		It("Should leave the other clusters alone", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+			name := "other-dnsmasq"
+			_, err = rt.CreateContainer(iopodman.Create{
+				Args: []string{provider},
+				Name: &name,
+			})
+			Expect(err).To(BeNil())
+
+			_, err = pack8s("rm")
+			Expect(err).To(BeNil())
+
+			Expect(containerNames(rt)).To(Equal([]string{name}))
+		})
+
+		It("Should leave alone the clusters whose prefix starts alike", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+			others := []string{"pack8s-test-ipv6-node01", "pack8s-test2-node01"}
+			for _, name := range others {
+				name := name
+				_, err = rt.CreateNamedVolume(name)
+				Expect(err).To(BeNil())
+				_, err = rt.CreateContainer(iopodman.Create{
+					Args: []string{provider},
+					Name: &name,
+				})
+				Expect(err).To(BeNil())
+			}
+
+			_, err = pack8s("rm")
+			Expect(err).To(BeNil())
+
+			Expect(containerNames(rt)).To(ConsistOf(others))
+			Expect(len(rt.Volumes())).To(Equal(len(others)))
+		})
+
+		It("Should remove the snapshot images along with the last cluster using them", func() {
+			image := "localhost/pack8s-snapshot/warm-node01:1579013400"
+			for _, name := range []string{prefix + "-node01", "other-node01"} {
+				name := name
+				_, err := rt.CreateContainer(iopodman.Create{
+					Args: []string{image},
+					Name: &name,
+				})
+				Expect(err).To(BeNil())
+			}
+
+			_, err := pack8s("rm")
+			Expect(err).To(BeNil())
+			Expect(rt.RemovedImages()).To(BeEmpty())
+
+			_, err = pack8s("rm", "--prefix", "other")
+			Expect(err).To(BeNil())
+			Expect(rt.Containers()).To(BeEmpty())
+			Expect(rt.RemovedImages()).To(Equal([]string{image}))
+		})
+	})
+
+	Context("show", func() {
+		It("Should report the cluster resources", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			out, err := pack8s("show", "-o", "json")
+			Expect(err).To(BeNil())
+
+			var info struct {
+				Prefix     string `json:"prefix"`
+				Containers []struct {
+					Name    string `json:"name"`
+					Running bool   `json:"running"`
+					Mounts  []struct {
+						Source      string `json:"source"`
+						Destination string `json:"destination"`
+						Type        string `json:"type"`
+						ReadOnly    bool   `json:"readOnly"`
+					} `json:"mounts"`
+				} `json:"containers"`
+				Volumes []struct {
+					Name string `json:"name"`
+				} `json:"volumes"`
+			}
+			Expect(json.Unmarshal([]byte(out), &info)).To(BeNil())
+			Expect(info.Prefix).To(Equal(prefix))
+			Expect(len(info.Containers)).To(Equal(3))
+			Expect(info.Containers[0].Name).To(Equal(prefix + "-dnsmasq"))
+			Expect(info.Containers[0].Running).To(BeTrue())
+			Expect(info.Containers[0].Mounts).To(BeEmpty())
+			node := info.Containers[2]
+			Expect(node.Name).To(Equal(prefix + "-node01"))
+			Expect(len(node.Mounts)).To(Equal(1))
+			Expect(node.Mounts[0].Source).To(Equal(prefix + "-node01"))
+			Expect(node.Mounts[0].Destination).To(Equal("/var/run/disk"))
+			Expect(node.Mounts[0].Type).To(Equal("volume"))
+			Expect(node.Mounts[0].ReadOnly).To(BeFalse())
+			Expect(len(info.Volumes)).To(Equal(1))
+			Expect(info.Volumes[0].Name).To(Equal(prefix + "-node01"))
+		})
+
+		It("Should report only the container ids with --ids", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			out, err := pack8s("show", "--ids", "-o", "json")
+			Expect(err).To(BeNil())
+
+			var ids []string
+			Expect(json.Unmarshal([]byte(out), &ids)).To(BeNil())
+			Expect(ids).To(Equal([]string{rt.Containers()[0].Id, rt.Containers()[1].Id, rt.Containers()[2].Id}))
+		})
+	})
+
+	Context("ports", func() {
+		It("Should report the ports published by dnsmasq", func() {
+			sshPort := freePort()
+			_, err := pack8s("run", "--background", "--ssh-port", strconv.Itoa(sshPort), "--random-ports=false", provider)
+			Expect(err).To(BeNil())
+
+			out, err := pack8s("ports", "-o", "json")
+			Expect(err).To(BeNil())
+
+			var res map[string]int
+			Expect(json.Unmarshal([]byte(out), &res)).To(BeNil())
+			Expect(res).To(Equal(map[string]int{"ssh": sshPort}))
+		})
+
+		It("Should report the random ports", func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+
+			out, err := pack8s("ports", "ssh", "-o", "json")
+			Expect(err).To(BeNil())
+
+			var res map[string]int
+			Expect(json.Unmarshal([]byte(out), &res)).To(BeNil())
+			Expect(res["ssh"]).To(BeNumerically(">=", fakeruntime.FirstRandomPort))
+		})
+
+		It("Should report the ports published by the cluster pod", func() {
+			sshPort := freePort()
+			_, err := pack8s("run", "--background", "--pod", "--ssh-port", strconv.Itoa(sshPort), "--random-ports=false", provider)
+			Expect(err).To(BeNil())
+
+			out, err := pack8s("ports", "ssh", "-o", "json")
+			Expect(err).To(BeNil())
+
+			var res map[string]int
+			Expect(json.Unmarshal([]byte(out), &res)).To(BeNil())
+			Expect(res).To(Equal(map[string]int{"ssh": sshPort}))
+		})
+	})
+
+	Context("logs", func() {
+		BeforeEach(func() {
+			_, err := pack8s("run", "--background", provider)
+			Expect(err).To(BeNil())
+		})
+
+		It("Should show the logs of a container", func() {
+			Expect(rt.Log(prefix+"-dnsmasq", iopodman.LogLine{Msg: "dnsmasq started"})).To(Succeed())
+			Expect(rt.Log(prefix+"-registry", iopodman.LogLine{Msg: "registry started"})).To(Succeed())
+
+			out, err := pack8s("logs", "dnsmasq")
+			Expect(err).To(BeNil())
+			Expect(out).To(Equal("dnsmasq started\n"))
+		})
+
+		It("Should interleave the logs of all the containers in time order", func() {
+			Expect(rt.Log(prefix+"-registry", iopodman.LogLine{Msg: "registry started", Time: "2020-01-14T14:50:02Z"})).To(Succeed())
+			Expect(rt.Log(prefix+"-dnsmasq", iopodman.LogLine{Msg: "dnsmasq started", Time: "2020-01-14T14:50:01Z"})).To(Succeed())
+			Expect(rt.Log(prefix+"-node01", iopodman.LogLine{Msg: "node ", Time: "2020-01-14T14:50:03Z", ParseLogType: "P"})).To(Succeed())
+			Expect(rt.Log(prefix+"-dnsmasq", iopodman.LogLine{Msg: "dnsmasq ready", Time: "2020-01-14T14:50:04Z"})).To(Succeed())
+			Expect(rt.Log(prefix+"-node01", iopodman.LogLine{Msg: "booted", Time: "2020-01-14T14:50:05Z"})).To(Succeed())
+
+			out, err := pack8s("logs")
+			Expect(err).To(BeNil())
+			Expect(out).To(Equal(strings.Join([]string{
+				"[dnsmasq] dnsmasq started",
+				"[registry] registry started",
+				"[dnsmasq] dnsmasq ready",
+				"[node01] node booted",
+				"",
+			}, "\n")))
+		})
+
+		It("Should show only the recent lines with --since", func() {
+			Expect(rt.Log(prefix+"-dnsmasq", iopodman.LogLine{Msg: "dnsmasq started", Time: "2020-01-14T14:50:01Z"})).To(Succeed())
+			Expect(rt.Log(prefix+"-dnsmasq", iopodman.LogLine{Msg: "dnsmasq ready"})).To(Succeed())
+
+			out, err := pack8s("logs", "dnsmasq", "--since", "10m")
+			Expect(err).To(BeNil())
+			Expect(out).To(Equal("dnsmasq ready\n"))
+
+			_, err = pack8s("logs", "dnsmasq", "--since", "yesterday")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should tell the known roles", func() {
+			_, err := pack8s("logs", "nfs")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("dnsmasq, node01, registry"))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+package cmdutil
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+// IsClusterResource tells if the container or volume with the given name belongs to the cluster with the given prefix.
+// Cluster resources are named <prefix>-<role> (e.g. kubevirt-dnsmasq, kubevirt-node01), and no role has dashes:
+// this keeps apart the clusters whose prefixes start alike, e.g. kubevirt and kubevirt-ipv6.
+func IsClusterResource(prefix, name string) bool {
+	if !strings.HasPrefix(name, prefix+"-") {
+		return false
+	}
+	role := strings.TrimPrefix(name, prefix+"-")
+	return role != "" && !strings.Contains(role, "-")
+}
+
+// ClusterContainers returns the containers of the cluster with the given prefix
+func ClusterContainers(hnd podman.Runtime, prefix string) ([]iopodman.Container, error) {
+	containers, err := hnd.GetPrefixedContainers(prefix + "-")
+	if err != nil {
+		return nil, err
+	}
+	ret := []iopodman.Container{}
+	for _, cont := range containers {
+		if IsClusterResource(prefix, cont.Names) {
+			ret = append(ret, cont)
+		}
+	}
+	return ret, nil
+}
+
+// ClusterVolumes returns the volumes of the cluster with the given prefix
+func ClusterVolumes(hnd podman.Runtime, prefix string) ([]iopodman.Volume, error) {
+	volumes, err := hnd.GetPrefixedVolumes(prefix + "-")
+	if err != nil {
+		return nil, err
+	}
+	ret := []iopodman.Volume{}
+	for _, vol := range volumes {
+		if IsClusterResource(prefix, vol.Name) {
+			ret = append(ret, vol)
+		}
+	}
+	return ret, nil
+}
+
+// RegisterCluster verifies a new cluster doesn't conflict with the existing ones, then records it in the cluster registry.
+// Nothing is created if a conflict is detected. The returned cluster holds the MAC range reserved for the new cluster.
+func RegisterCluster(hnd podman.Runtime, cOpts CommonOpts, provider string, hostPorts []uint) (clusters.Cluster, error) {
+	path, err := clusters.DefaultPath()
+	if err != nil {
+		return clusters.Cluster{}, err
+	}
+
+	var cluster clusters.Cluster
+	err = clusters.Update(path, func(reg *clusters.Registry) error {
+		// catch also the clusters pack8s doesn't know about, e.g. created before the registry existed
+		containers, err := ClusterContainers(hnd, cOpts.Prefix)
+		if err != nil {
+			return err
+		}
+		if len(containers) > 0 {
+			return fmt.Errorf("containers with prefix %s already exist (e.g. %s): remove them with 'pack8s rm -p %s' or choose another prefix", cOpts.Prefix, containers[0].Names, cOpts.Prefix)
+		}
+		if c, ok := reg.Find(cOpts.Prefix, cOpts.PodmanSocket); ok {
+			return fmt.Errorf("cluster %s, created %s, is still registered: remove it with 'pack8s rm -p %s' or choose another prefix", c.Prefix, c.Created.Format("2006-01-02 15:04:05"), c.Prefix)
+		}
+
+		// the ports of the remote hosts can't be checked from here: podman will fail if they are taken
+		if !cOpts.IsRemote() {
+			for _, port := range hostPorts {
+				if err := checkHostPortFree(port); err != nil {
+					return err
+				}
+			}
+		}
+
+		cluster, err = reg.Add(clusters.Cluster{
+			Prefix:       cOpts.Prefix,
+			Provider:     provider,
+			PodmanSocket: cOpts.PodmanSocket,
+			Runtime:      cOpts.Runtime,
+			HostPorts:    hostPorts,
+		})
+		return err
+	})
+	return cluster, err
+}
+
+// UnregisterCluster removes the cluster with the prefix and the podman socket given in cOpts from the cluster registry, if present.
+func UnregisterCluster(cOpts CommonOpts) error {
+	path, err := clusters.DefaultPath()
+	if err != nil {
+		return err
+	}
+	return clusters.Update(path, func(reg *clusters.Registry) error {
+		reg.Remove(cOpts.Prefix, cOpts.PodmanSocket)
+		return nil
+	})
+}
+
+func checkHostPortFree(port uint) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("host port %d is not available: %v", port, err)
+	}
+	return ln.Close()
+}
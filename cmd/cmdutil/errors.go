@@ -0,0 +1,18 @@
+package cmdutil
+
+import (
+	"fmt"
+)
+
+// ExitError makes pack8s exit with the given code. If Err is nil, pack8s exits silently.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
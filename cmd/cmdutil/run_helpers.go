@@ -2,17 +2,96 @@ package cmdutil
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
 
+	"github.com/spf13/pflag"
+
 	"github.com/fromanirh/pack8s/iopodman"
 
 	"github.com/fromanirh/pack8s/internal/pkg/images"
 	"github.com/fromanirh/pack8s/internal/pkg/ledger"
 	"github.com/fromanirh/pack8s/internal/pkg/mounts"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
+)
+
+// LoadSpecFile reads the cluster spec from the given file, starting from def, and hands it to apply,
+// which replaces the command options with it. Then it reapplies the flags explicitely set on the
+// command line, so they take precedence over the file.
+func LoadSpecFile(flagSet *pflag.FlagSet, path string, def spec.Cluster, apply func(cs spec.Cluster) error) error {
+	cs, err := spec.LoadWithDefaults(path, def)
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[string]string)
+	flagSet.Visit(func(flag *pflag.Flag) {
+		// slice values are not bound to the spec, and they can't be reset from their string form
+		if _, ok := flag.Value.(pflag.SliceValue); !ok {
+			changed[flag.Name] = flag.Value.String()
+		}
+	})
+
+	if err := apply(cs); err != nil {
+		return err
+	}
+	for name, value := range changed {
+		if err := flagSet.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClusterSubnet is the network the dnsmasq container of the provider images sets up for the cluster
+var ClusterSubnet = net.IPNet{
+	IP:   net.IPv4(192, 168, 66, 0),
+	Mask: net.CIDRMask(24, 32),
+}
+
+const (
+	servicesHostID  = 2
+	firstNodeHostID = 100
 )
 
-func SetupRegistry(ldgr ledger.Ledger, prefix, network, registryVolume string, privileged bool) error {
+// ServicesAddress returns the address of the dnsmasq container on the cluster network. The services
+// sharing its network namespace (registry, NFS, Ceph) are reachable at the same address.
+func ServicesAddress() string {
+	return subnetAddress(servicesHostID)
+}
+
+// NodeAddress returns the address of the node with the given (1-based) index on the cluster network
+func NodeAddress(index uint) string {
+	return subnetAddress(firstNodeHostID + index)
+}
+
+func subnetAddress(hostID uint) string {
+	ip := ClusterSubnet.IP.To4()
+	return net.IPv4(ip[0], ip[1], ip[2], ip[3]+byte(hostID)).String()
+}
+
+// ClusterNetwork tells how a container joins the network of the cluster: either sharing the
+// network namespace of another container, usually dnsmasq, or as member of the cluster pod.
+type ClusterNetwork struct {
+	// Container is the ID of the container whose network namespace is shared
+	Container string
+	// Pod is the name of the cluster pod. If set, Container is ignored.
+	Pod string
+}
+
+// Apply sets the network of the given container configuration
+func (cn ClusterNetwork) Apply(conf *iopodman.Create) {
+	if cn.Pod != "" {
+		pod := cn.Pod
+		conf.Pod = &pod
+		return
+	}
+	network := fmt.Sprintf("container:%s", cn.Container)
+	conf.Network = &network
+}
+
+func SetupRegistry(ldgr ledger.Ledger, prefix string, network ClusterNetwork, auxImages images.Set, registryVolume string, privileged bool) error {
 	var err error
 	// TODO: how to use the user-supplied name?
 	var registryMounts mounts.MountMapping
@@ -32,18 +111,19 @@ func SetupRegistry(ldgr ledger.Ledger, prefix, network, registryVolume string, p
 	registryName := fmt.Sprintf("%s-registry", prefix)
 	registryMountsStrings := registryMounts.ToStrings()
 	registryLabels := []string{fmt.Sprintf("%s=0001", podman.LabelGeneration)}
-	_, err = ldgr.RunContainer(iopodman.Create{
-		Args:       []string{images.DockerRegistryImage},
+	registryConf := iopodman.Create{
+		Args:       []string{auxImages.Registry},
 		Name:       &registryName,
 		Label:      &registryLabels,
 		Mount:      &registryMountsStrings,
-		Network:    &network,
 		Privileged: &privileged,
-	})
+	}
+	network.Apply(&registryConf)
+	_, err = ldgr.RunContainer(registryConf)
 	return err
 }
 
-func SetupNFS(ldgr ledger.Ledger, prefix, network, nfsData string, privileged bool) error {
+func SetupNFS(ldgr ledger.Ledger, prefix string, network ClusterNetwork, auxImages images.Set, nfsData string, privileged bool) error {
 	var err error
 	nfsData, err = filepath.Abs(nfsData)
 	if err != nil {
@@ -53,13 +133,14 @@ func SetupNFS(ldgr ledger.Ledger, prefix, network, nfsData string, privileged bo
 	nfsName := fmt.Sprintf("%s-nfs", prefix)
 	nfsMounts := []string{fmt.Sprintf("type=bind,source=%s,destination=/data/nfs", nfsData)}
 	nfsLabels := []string{fmt.Sprintf("%s=010", podman.LabelGeneration)}
-	_, err = ldgr.RunContainer(iopodman.Create{
-		Args:       []string{images.NFSGaneshaImage},
+	nfsConf := iopodman.Create{
+		Args:       []string{auxImages.NFS},
 		Name:       &nfsName,
 		Label:      &nfsLabels,
 		Mount:      &nfsMounts,
-		Network:    &network,
 		Privileged: &privileged,
-	})
+	}
+	network.Apply(&nfsConf)
+	_, err = ldgr.RunContainer(nfsConf)
 	return err
 }
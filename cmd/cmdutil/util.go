@@ -2,31 +2,43 @@ package cmdutil
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 
 	logger "github.com/apsdehal/go-logger"
 	isatty "github.com/mattn/go-isatty"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fromanirh/pack8s/internal/pkg/output"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/sshtunnel"
 )
 
+// NewRuntime connects to the container runtimes. Tests replace it to run the commands against a fake runtime.
+var NewRuntime = podman.NewRuntime
+
 type CommonOpts struct {
 	Prefix       string
 	PodmanSocket string
+	Runtime      string
 	Verbose      int
 	Registry     string
 	IsTTY        bool
 	Color        bool
+	Output       string
 }
 
 func AddCommonOpts(rootCmd *cobra.Command) {
 	rootCmd.PersistentFlags().StringP("prefix", "p", "kubevirt", "Prefix to identify containers")
-	rootCmd.PersistentFlags().StringP("podman-socket", "s", podman.DefaultSocket, "Path to podman-socket")
+	rootCmd.PersistentFlags().StringP("podman-socket", "s", podman.DefaultSocket, "Path to podman-socket, or ssh://[user@]host[:port]/path to reach it through SSH")
+	rootCmd.PersistentFlags().String("runtime", podman.DefaultRuntime, fmt.Sprintf("container runtime to talk to through the podman socket: %s", strings.Join(podman.Runtimes, " or ")))
 	rootCmd.PersistentFlags().IntP("verbose", "v", 3, "verbosiness level [1,5)")
 	rootCmd.PersistentFlags().StringP("container-registry", "R", "docker.io", "Registry to pull cluster images from")
+	rootCmd.PersistentFlags().StringP("output", "o", output.FormatText, "output format: text, json or yaml")
 }
 
 func GetCommonOpts(cmd *cobra.Command) (CommonOpts, error) {
@@ -38,6 +50,16 @@ func GetCommonOpts(cmd *cobra.Command) (CommonOpts, error) {
 	if err != nil {
 		return CommonOpts{}, err
 	}
+	runtime, err := cmd.Flags().GetString("runtime")
+	if err != nil {
+		return CommonOpts{}, err
+	}
+	if !podman.IsKnownRuntime(runtime) {
+		return CommonOpts{}, fmt.Errorf("unknown runtime: %s", runtime)
+	}
+	if runtime == podman.RuntimeDocker && !cmd.Flags().Changed("podman-socket") {
+		podmanSocket = podman.DefaultRESTSocket
+	}
 	verbose, err := cmd.Flags().GetInt("verbose")
 	if err != nil {
 		return CommonOpts{}, err
@@ -46,6 +68,14 @@ func GetCommonOpts(cmd *cobra.Command) (CommonOpts, error) {
 	if err != nil {
 		return CommonOpts{}, err
 	}
+	// some commands (e.g. kubeconfig) have their own --output flag, which shadows the common one.
+	outputFormat := output.FormatText
+	if flag := cmd.InheritedFlags().Lookup("output"); flag != nil {
+		outputFormat = flag.Value.String()
+	}
+	if !output.IsKnownFormat(outputFormat) {
+		return CommonOpts{}, fmt.Errorf("unknown output format: %s", outputFormat)
+	}
 
 	if val, ok := os.LookupEnv("PACK8S_VERBOSE"); ok {
 		if v, err := strconv.Atoi(val); err == nil {
@@ -61,18 +91,63 @@ func GetCommonOpts(cmd *cobra.Command) (CommonOpts, error) {
 	return CommonOpts{
 		Prefix:       prefix,
 		PodmanSocket: podmanSocket,
+		Runtime:      runtime,
 		Verbose:      verbose,
 		IsTTY:        isatty.IsTerminal(os.Stderr.Fd()),
 		Color:        color,
 		Registry:     registry,
+		Output:       outputFormat,
 	}, nil
 }
 
+// WantsStructuredOutput tells if the user asked for machine-readable output
+func (co CommonOpts) WantsStructuredOutput() bool {
+	return output.IsStructured(co.Output)
+}
+
+// PrintResult writes the given value using the structured output format requested by the user.
+func (co CommonOpts) PrintResult(w io.Writer, v interface{}) error {
+	return output.Print(w, co.Output, v)
+}
+
 func (co CommonOpts) GetLogger() *logger.Logger {
 	return NewLogger(co.Verbose, co.Color, co.IsTTY)
 }
 
+// IsRemote tells if the containers run on another host, reached through SSH
+func (co CommonOpts) IsRemote() bool {
+	return sshtunnel.IsRemote(co.PodmanSocket)
+}
+
+// HostAddress returns the address of the host the containers publish their ports on
+func (co CommonOpts) HostAddress() string {
+	if addr, err := sshtunnel.Parse(co.PodmanSocket); err == nil {
+		return addr.Host
+	}
+	return "127.0.0.1"
+}
+
+// GetRuntime connects to the container runtime selected by the user
+func (co CommonOpts) GetRuntime() (podman.Runtime, *logger.Logger, error) {
+	ctx := context.Background()
+	log := co.GetLogger()
+	rt, err := NewRuntime(ctx, co.Runtime, co.PodmanSocket, log)
+	return rt, log, err
+}
+
+// RequireVarlink fails unless the user selected the varlink runtime
+func (co CommonOpts) RequireVarlink() error {
+	if co.Runtime != podman.RuntimeVarlink {
+		return fmt.Errorf("this command needs the %s runtime", podman.RuntimeVarlink)
+	}
+	return nil
+}
+
+// GetHandle connects to podman through varlink, for the commands needing more than a Runtime offers
 func (co CommonOpts) GetHandle() (*podman.Handle, *logger.Logger, error) {
+	if err := co.RequireVarlink(); err != nil {
+		return nil, nil, err
+	}
 	ctx := context.Background()
 	log := co.GetLogger()
 	hnd, err := podman.NewHandle(ctx, co.PodmanSocket, log)
@@ -1,26 +1,42 @@
 package cmd
 
 import (
-	"os"
-
 	"github.com/spf13/cobra"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
 )
 
+type execOptions struct {
+	env     []string
+	workdir string
+}
+
 // NewExecCommand runs given command inside container
 func NewExecCommand() *cobra.Command {
+	flags := &execOptions{}
+
 	exec := &cobra.Command{
 		Use:   "exec",
 		Short: "exec runs given command in container",
-		RunE:  execCommand,
-		Args:  cobra.MinimumNArgs(2),
+		Long: `exec runs given command in container
+
+pack8s exits with the exit code of the command.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return execCommand(cmd, flags, args)
+		},
+		Args: cobra.MinimumNArgs(2),
 	}
 
+	exec.Flags().StringArrayVarP(&flags.env, "env", "e", []string{}, "set environment variables for the command (KEY=VALUE)")
+	exec.Flags().StringVarP(&flags.workdir, "workdir", "w", "", "run the command in the given directory")
+
 	return exec
 }
 
-func execCommand(cmd *cobra.Command, args []string) error {
+func execCommand(cmd *cobra.Command, execOpts *execOptions, args []string) error {
 	containerID := args[0]
 	command := args[1:]
 
@@ -29,10 +45,13 @@ func execCommand(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	hnd, _, err := cOpts.GetHandle()
+	hnd, _, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
 
-	return hnd.Exec(containerID, command, os.Stdout)
+	return execAttached(hnd, containerID, command, podman.ExecOptions{
+		Env:     execOpts.env,
+		Workdir: execOpts.workdir,
+	})
 }
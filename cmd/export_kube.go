@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/kube"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+)
+
+type exportKubeOptions struct {
+	service bool
+}
+
+// NewExportKubeCommand returns command to export the cluster as Kubernetes YAML
+func NewExportKubeCommand() *cobra.Command {
+	flags := &exportKubeOptions{}
+
+	exportKube := &cobra.Command{
+		Use:   "export-kube",
+		Short: "export-kube prints the Kubernetes YAML description of the cluster",
+		Long: `export-kube prints the Kubernetes YAML description of the cluster
+
+The cluster is described as one Kubernetes v1 Pod, named after the cluster prefix, holding all
+the cluster containers with their mounts, environment, labels and ports. The description can be
+replayed with 'podman play kube'.
+
+Clusters not running in a pod are described as if they did: the containers sharing the network
+of dnsmasq become members of the same pod. The containers are listed in generation order.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportKube(cmd, flags)
+		},
+		Args: cobra.NoArgs,
+	}
+
+	exportKube.Flags().BoolVar(&flags.service, "service", false, "describe also a Service exposing the ports of the cluster")
+
+	return exportKube
+}
+
+func exportKube(cmd *cobra.Command, ekOpts *exportKubeOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetHandle()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	if _, podFound, err := hnd.FindPod(cOpts.Prefix); err != nil {
+		return err
+	} else if podFound {
+		names = append(names, cOpts.Prefix)
+	} else {
+		containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+		if err != nil {
+			return err
+		}
+		if len(containers) == 0 {
+			return fmt.Errorf("no containers found for cluster %s", cOpts.Prefix)
+		}
+		// oldest generation first, like they were created
+		sort.Stable(sort.Reverse(containerList(containers)))
+		for _, cont := range containers {
+			names = append(names, cont.Names)
+		}
+	}
+
+	pods := []kube.Object{}
+	services := []kube.Object{}
+	for _, name := range names {
+		log.Infof("generating the kubernetes description of %s", name)
+		pod, service, err := generateKube(hnd, name, ekOpts.service)
+		if err != nil {
+			return err
+		}
+		pods = append(pods, pod)
+		if service != nil {
+			services = append(services, service)
+		}
+	}
+
+	objs := []kube.Object{}
+	pod, err := kube.MergePods(cOpts.Prefix, pods)
+	if err != nil {
+		return err
+	}
+	objs = append(objs, pod)
+	if len(services) > 0 {
+		service, err := kube.MergeServices(cOpts.Prefix, services)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, service)
+	}
+
+	return writeKubeObjects(cmd.OutOrStdout(), cOpts.Prefix, objs)
+}
+
+func generateKube(hnd *podman.Handle, name string, service bool) (kube.Object, kube.Object, error) {
+	desc, err := hnd.GenerateKube(name, service)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pod, err := kube.Parse([]byte(desc.Pod))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse the pod description of %s: %v", name, err)
+	}
+	if !service || desc.Service == "" {
+		return pod, nil, nil
+	}
+	svc, err := kube.Parse([]byte(desc.Service))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse the service description of %s: %v", name, err)
+	}
+	return pod, svc, nil
+}
+
+func writeKubeObjects(w io.Writer, prefix string, objs []kube.Object) error {
+	fmt.Fprintf(w, "# Kubernetes description of the pack8s cluster %s\n", prefix)
+	fmt.Fprintf(w, "# Replay it with 'podman play kube'\n")
+	for idx, obj := range objs {
+		if idx > 0 {
+			fmt.Fprintf(w, "---\n")
+		}
+		data, err := obj.Marshal()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
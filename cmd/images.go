@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+)
+
+// NewImagesCommand returns command to manage the auxiliary images
+func NewImagesCommand() *cobra.Command {
+	imgs := &cobra.Command{
+		Use:   "images",
+		Short: "images manages the auxiliary images run alongside the cluster",
+		Long: `images manages the auxiliary images run alongside the cluster
+
+The auxiliary images (registry, nfs, ceph and fluentd) are read from the configuration file
+(default $PACK8S_IMAGES, or images.yaml in the pack8s user configuration directory):
+
+  mirror: mirror.lab:5000
+  images:
+    nfs: mirror.lab:5000/nfs-ganesha:latest
+
+The mirror replaces the registry of the default images. For each role, the image set in
+the environment (e.g. PACK8S_IMAGE_NFS) wins over the configuration file, and PACK8S_IMAGE_MIRROR
+wins over the configured mirror. The images pinned in the cluster spec file win over all of them.
+
+The same file tells how the images are pulled: how many at the same time (PACK8S_PULL_PARALLELISM
+wins over it), how to retry the failed pulls, and which images are optional, so failing to pull
+them only causes a warning. Roles include the provider image:
+
+  pull:
+    parallelism: 3
+    retry:
+      attempts: 4
+      delay: 1s
+      backoff: 2
+      maxDelay: 6s
+    roles:
+      provider:
+        attempts: 8
+      fluentd:
+        optional: true
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprint(cmd.OutOrStderr(), cmd.UsageString())
+		},
+	}
+
+	imgs.AddCommand(
+		NewImagesListCommand(),
+	)
+	return imgs
+}
+
+// NewImagesListCommand returns command to show the effective auxiliary images
+func NewImagesListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list shows the effective auxiliary images, and where they come from",
+		RunE:  listImages,
+		Args:  cobra.NoArgs,
+	}
+}
+
+func listImages(cmd *cobra.Command, _ []string) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	path, err := images.ConfigPath()
+	if err != nil {
+		return err
+	}
+	conf, err := images.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	imgs := conf.Resolve()
+	if cOpts.WantsStructuredOutput() {
+		return cOpts.PrintResult(cmd.OutOrStdout(), imgs)
+	}
+	return writeImagesText(cmd.OutOrStdout(), imgs)
+}
+
+func writeImagesText(w io.Writer, imgs []images.Image) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ROLE\tSOURCE\tIMAGE\n")
+	for _, img := range imgs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", img.Role, img.Source, img.Reference)
+	}
+	return tw.Flush()
+}
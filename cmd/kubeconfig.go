@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/kubeconfig"
+	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	scpkg "github.com/fromanirh/pack8s/internal/pkg/scp"
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	// kubeconfigAdminPath is the path of the admin kubeconfig on the first node of kubernetes clusters
+	kubeconfigAdminPath = "/etc/kubernetes/admin.conf"
+	// kubeconfigOKDPath is the path of the admin kubeconfig in the cluster container of OKD clusters
+	kubeconfigOKDPath = "/root/install/auth/kubeconfig"
+	// kubeconfigVerifyTimeout is how long to wait for the API server while verifying its certificate
+	kubeconfigVerifyTimeout = 10 * time.Second
+)
+
+type kubeconfigOptions struct {
+	output                string
+	merge                 bool
+	sshUser               string
+	insecureSkipTLSVerify bool
+}
+
+// NewKubeconfigCommand returns command to fetch the admin kubeconfig of the cluster
+func NewKubeconfigCommand() *cobra.Command {
+	flags := &kubeconfigOptions{}
+
+	kubeconfig := &cobra.Command{
+		Use:   "kubeconfig [--output path] [--merge]",
+		Short: "kubeconfig fetches the admin kubeconfig of the cluster",
+		Long: `kubeconfig fetches the admin kubeconfig of the cluster
+
+The kubeconfig is fetched from the first node of kubernetes clusters, or from the cluster
+container of OKD clusters. The cluster server is rewritten to the API port published on the
+local host, and the context, the cluster and the user are renamed after the cluster prefix.
+
+By default the kubeconfig is printed on stdout. With --merge, the kubeconfig is merged into
+the --output file (default ~/.kube/config), replacing only the entries of this cluster,
+so kubeconfigs of more pack8s clusters can live side by side.
+
+The certificate authority of the cluster is kept. If the certificate of the API server is not
+issued for the published address, kubectl will refuse to connect: --insecure-skip-tls-verify
+disables the verification.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return getKubeconfig(cmd, flags)
+		},
+		Args: cobra.NoArgs,
+	}
+
+	kubeconfig.Flags().StringVar(&flags.output, "output", "", "write the kubeconfig to this path instead of stdout")
+	kubeconfig.Flags().BoolVar(&flags.merge, "merge", false, "merge the kubeconfig into the existing one (default ~/.kube/config)")
+	kubeconfig.Flags().StringVar(&flags.sshUser, "ssh-user", "vagrant", "the user that used to connect via SSH to the node")
+	kubeconfig.Flags().BoolVar(&flags.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "don't verify the certificate of the API server")
+
+	return kubeconfig
+}
+
+func getKubeconfig(cmd *cobra.Command, kcOpts *kubeconfigOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	var cont iopodman.Container
+	// OKD clusters run in a single container, while kubernetes clusters have their ports published on dnsmasq.
+	if okdCont, err := hnd.FindPrefixedContainer(cOpts.Prefix + "-cluster"); err == nil {
+		cont = okdCont
+		log.Noticef("kubeconfig: fetching %s from %s", kubeconfigOKDPath, cont.Names)
+		err = hnd.Exec(cont.Names, []string{"cat", kubeconfigOKDPath}, &data)
+		if err != nil {
+			return err
+		}
+	} else {
+		cont, err = hnd.FindPrefixedContainer(cOpts.Prefix + "-dnsmasq")
+		if err != nil {
+			return err
+		}
+		cont.Ports, err = publishedPorts(hnd, cOpts.Prefix, cont)
+		if err != nil {
+			return err
+		}
+
+		log.Noticef("kubeconfig: fetching %s from %s", kubeconfigAdminPath, nodeNameFromIndex(1))
+		connection, err := dialNode(cOpts.HostAddress(), cont, nodeNameFromIndex(1), kcOpts.sshUser)
+		if err != nil {
+			return err
+		}
+		defer connection.Close()
+
+		err = copyFromNode(connection.Client, []string{kubeconfigAdminPath}, "-", scpkg.Options{}, &data, cmd.OutOrStderr())
+		if err != nil {
+			return err
+		}
+	}
+
+	apiPort, err := ports.GetPublicPort(ports.PortAPI, cont.Ports)
+	if err != nil {
+		apiPort, err = ports.GetPublicPort(ports.PortOCP, cont.Ports)
+		if err != nil {
+			return fmt.Errorf("the API server port is not published by %s", cont.Names)
+		}
+	}
+
+	// exec runs in a TTY, which turns newlines into CRLF
+	conf, err := kubeconfig.Parse(bytes.Replace(data.Bytes(), []byte("\r\n"), []byte("\n"), -1))
+	if err != nil {
+		return err
+	}
+	clusterConf, err := conf.ForCluster(cOpts.Prefix, "https://"+net.JoinHostPort(cOpts.HostAddress(), strconv.Itoa(apiPort)))
+	if err != nil {
+		return err
+	}
+	if kcOpts.insecureSkipTLSVerify {
+		clusterConf.SkipTLSVerify()
+	} else if err := kubeconfig.VerifyServer(clusterConf.Clusters[0].Cluster, kubeconfigVerifyTimeout); err != nil {
+		log.Warningf("kubeconfig: cannot verify the API server at %s: %v (use --insecure-skip-tls-verify to skip the verification)", clusterConf.Clusters[0].Cluster.Server, err)
+	}
+
+	if kcOpts.merge {
+		path := kcOpts.output
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(home, ".kube", "config")
+		}
+
+		existing, err := kubeconfig.Load(path)
+		if err != nil {
+			return err
+		}
+		existing.Merge(clusterConf)
+		log.Noticef("kubeconfig: merging context %s into %s", cOpts.Prefix, path)
+		return existing.Save(path)
+	}
+
+	if kcOpts.output != "" {
+		log.Noticef("kubeconfig: writing context %s into %s", cOpts.Prefix, kcOpts.output)
+		return clusterConf.Save(kcOpts.output)
+	}
+
+	out, err := clusterConf.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
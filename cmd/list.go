@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	clusterStateRunning     = "running"
+	clusterStateDegraded    = "degraded"
+	clusterStateMissing     = "missing"
+	clusterStateUnreachable = "unreachable"
+)
+
+type clusterEntry struct {
+	clusters.Cluster `yaml:",inline"`
+	State            string `json:"state" yaml:"state"`
+	Containers       int    `json:"containers" yaml:"containers"`
+	Running          int    `json:"running" yaml:"running"`
+}
+
+// NewListCommand returns command to list the clusters started by pack8s
+func NewListCommand() *cobra.Command {
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list shows the clusters started by pack8s",
+		Long: `list shows the clusters started by pack8s
+
+Clusters are registered when run starts and unregistered by rm, or when run fails.
+A cluster whose containers are all gone is reported as missing: use rm to unregister it.
+`,
+		RunE: listClusters,
+		Args: cobra.NoArgs,
+	}
+	return list
+}
+
+func listClusters(cmd *cobra.Command, _ []string) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	path, err := clusters.DefaultPath()
+	if err != nil {
+		return err
+	}
+	reg, err := clusters.Load(path)
+	if err != nil {
+		return err
+	}
+
+	log := cOpts.GetLogger()
+	// clusters may have been started on different podman instances
+	containersBySocket := make(map[string][]iopodman.Container)
+	unreachable := make(map[string]bool)
+
+	entries := []clusterEntry{}
+	for _, c := range reg.Sorted() {
+		entry := clusterEntry{Cluster: c}
+
+		if _, ok := containersBySocket[c.PodmanSocket]; !ok && !unreachable[c.PodmanSocket] {
+			containers, err := getAllContainers(c.Runtime, c.PodmanSocket, cOpts)
+			if err != nil {
+				log.Warningf("cannot list containers on %s: %v", c.PodmanSocket, err)
+				unreachable[c.PodmanSocket] = true
+			} else {
+				containersBySocket[c.PodmanSocket] = containers
+			}
+		}
+
+		if unreachable[c.PodmanSocket] {
+			entry.State = clusterStateUnreachable
+		} else {
+			for _, cont := range containersBySocket[c.PodmanSocket] {
+				if !cmdutil.IsClusterResource(c.Prefix, cont.Names) {
+					continue
+				}
+				entry.Containers++
+				if cont.Containerrunning {
+					entry.Running++
+				}
+			}
+			entry.State = clusterState(entry.Containers, entry.Running)
+		}
+		entries = append(entries, entry)
+	}
+
+	if cOpts.WantsStructuredOutput() {
+		return cOpts.PrintResult(cmd.OutOrStdout(), entries)
+	}
+	return writeClustersText(cmd.OutOrStdout(), entries)
+}
+
+func getAllContainers(runtime, socket string, cOpts cmdutil.CommonOpts) ([]iopodman.Container, error) {
+	hnd, err := cmdutil.NewRuntime(context.Background(), runtime, socket, cOpts.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+	defer hnd.Close()
+	return hnd.GetPrefixedContainers("")
+}
+
+func clusterState(containers, running int) string {
+	switch {
+	case containers == 0:
+		return clusterStateMissing
+	case containers == running:
+		return clusterStateRunning
+	default:
+		return clusterStateDegraded
+	}
+}
+
+func writeClustersText(w io.Writer, entries []clusterEntry) error {
+	if len(entries) == 0 {
+		fmt.Fprintf(w, "no clusters found\n")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PREFIX\tPODMAN SOCKET\tPROVIDER\tSTATE\tCONTAINERS\tMACS\tHOST PORTS\tCREATED\n")
+	for _, e := range entries {
+		hostPorts := []string{}
+		for _, port := range e.HostPorts {
+			hostPorts = append(hostPorts, fmt.Sprintf("%d", port))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d\t%s:xx\t%s\t%s\n", e.Prefix, e.PodmanSocket, e.Provider, e.State, e.Running, e.Containers, e.MACPrefix(), orDash(strings.Join(hostPorts, ",")), e.Created.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
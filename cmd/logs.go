@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/prefixwriter"
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	// allRoles selects the logs of all the containers of the cluster
+	allRoles = "all"
+)
+
+type logsOptions struct {
+	follow bool
+	since  string
+}
+
+// NewLogsCommand returns command to show the logs of the cluster containers
+func NewLogsCommand() *cobra.Command {
+	flags := &logsOptions{}
+	logs := &cobra.Command{
+		Use:   "logs [node01|dnsmasq|registry|...|all]",
+		Short: "logs shows the logs of the containers of a cluster",
+		Long: `logs shows the logs of the containers of a cluster
+
+The container is given by its role, which is its name without the prefix, e.g. 'dnsmasq' or 'node01'.
+With 'all', the default, the lines of all the containers of the cluster are interleaved,
+and prefixed by the role of the container which logged them.
+
+--since takes a duration, e.g. '10m', or a time, e.g. '2020-01-14T14:50:00Z' or '2020-01-14'.
+With --follow, the new lines are shown as soon as they are logged, until interrupted with Ctrl-C.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			role := allRoles
+			if len(args) > 0 {
+				role = args[0]
+			}
+			return showLogs(cmd, role, flags)
+		},
+		Args: cobra.MaximumNArgs(1),
+	}
+
+	logs.Flags().BoolVarP(&flags.follow, "follow", "f", false, "keep showing the new lines until interrupted")
+	logs.Flags().StringVar(&flags.since, "since", "", "show only the lines logged since the given time, or duration ago")
+
+	return logs
+}
+
+// parseSince parses times like 2020-01-14T14:50:00Z and 2020-01-14, or durations ago like 10m
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time or duration: %s", value)
+}
+
+func showLogs(cmd *cobra.Command, role string, logsOpts *logsOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	since, err := parseSince(logsOpts.since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found with prefix %s", cOpts.Prefix)
+	}
+
+	roles := make(map[string]string)
+	names := []string{}
+	for _, cont := range containers {
+		contRole := strings.TrimPrefix(cont.Names, cOpts.Prefix+"-")
+		if role != allRoles && role != contRole {
+			continue
+		}
+		roles[cont.Id] = contRole
+		names = append(names, cont.Names)
+	}
+	if len(names) == 0 {
+		known := []string{}
+		for _, cont := range containers {
+			known = append(known, strings.TrimPrefix(cont.Names, cOpts.Prefix+"-"))
+		}
+		sort.Strings(known)
+		return fmt.Errorf("no container %s in cluster %s (known: %s)", role, cOpts.Prefix, strings.Join(known, ", "))
+	}
+
+	out := cmd.OutOrStdout()
+	opts := podman.LogOptions{
+		Follow: logsOpts.follow,
+		Since:  since,
+	}
+
+	// the plain logs of a single container need no streaming
+	if role != allRoles && !opts.Follow && opts.Since.IsZero() {
+		lines, err := hnd.GetContainerLogs(names[0])
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(out, strings.TrimSuffix(line, "\n")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	lw := newLogWriter(out, roles, role == allRoles)
+	defer lw.Close()
+
+	logHnd := hnd.Clone(ctx)
+	defer logHnd.Close()
+
+	if opts.Follow {
+		log.Infof("following the logs of %s, interrupt with Ctrl-C", strings.Join(names, ", "))
+		err = logHnd.GetContainersLogs(names, opts, lw.Write)
+		if err == context.Canceled {
+			return nil
+		}
+		return err
+	}
+
+	// podman reads the logs of each container concurrently: put the lines back in order
+	lines := []iopodman.LogLine{}
+	err = logHnd.GetContainersLogs(names, opts, func(line iopodman.LogLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return logTime(lines[i]).Before(logTime(lines[j]))
+	})
+	for _, line := range lines {
+		if err := lw.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logTime(line iopodman.LogLine) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, line.Time)
+	return t
+}
+
+// logWriter writes the log lines of the containers, prefixed by their role if asked to.
+// The partial lines are completed before the lines of other containers are written.
+type logWriter struct {
+	out      io.Writer
+	lock     sync.Mutex
+	roles    map[string]string
+	prefixed bool
+	writers  map[string]*prefixwriter.Writer
+}
+
+func newLogWriter(out io.Writer, roles map[string]string, prefixed bool) *logWriter {
+	return &logWriter{
+		out:      out,
+		roles:    roles,
+		prefixed: prefixed,
+		writers:  make(map[string]*prefixwriter.Writer),
+	}
+}
+
+func (lw *logWriter) Write(line iopodman.LogLine) error {
+	w, ok := lw.writers[line.Cid]
+	if !ok {
+		prefix := ""
+		if lw.prefixed {
+			role, ok := lw.roles[line.Cid]
+			if !ok {
+				role = line.Cid
+			}
+			prefix = fmt.Sprintf("[%s] ", role)
+		}
+		w = prefixwriter.New(lw.out, &lw.lock, prefix)
+		lw.writers[line.Cid] = w
+	}
+	msg := strings.TrimSuffix(line.Msg, "\n")
+	// podman splits the long lines in partial ones
+	if line.ParseLogType != "P" {
+		msg += "\n"
+	}
+	_, err := io.WriteString(w, msg)
+	return err
+}
+
+// Close writes the partial lines left, if any
+func (lw *logWriter) Close() error {
+	var err error
+	for _, w := range lw.writers {
+		if closeErr := w.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -5,25 +5,32 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/fromanirh/pack8s/iopodman"
 
+	"github.com/fromanirh/pack8s/internal/pkg/images"
 	"github.com/fromanirh/pack8s/internal/pkg/ledger"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
 	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
 )
 
 type runOptions struct {
 	privileged     bool
+	specFile       string
+	dumpSpec       bool
+	provider       string
 	masterMemory   string
-	masterCpu      string
-	workers        string
+	masterCpu      uint
+	workers        uint
 	workersMemory  string
-	workersCpu     string
+	workersCpu     uint
 	secondaryNics  uint
 	registryVolume string
 	nfsData        string
@@ -36,6 +43,8 @@ type runOptions struct {
 	randomPorts    bool
 	volume         string
 	downloadOnly   bool
+	auxImages      images.Set
+	specImages     images.Set
 }
 
 func (ro runOptions) WantsNFS() bool {
@@ -50,25 +59,147 @@ func (ro runOptions) WantsFluentd() bool {
 	return false
 }
 
+func (ro runOptions) AuxImages() images.Set {
+	return ro.auxImages
+}
+
+// toSpec returns the cluster spec described by the options. The master is the control-plane node,
+// all the other nodes are workers.
+func (ro runOptions) toSpec() spec.Cluster {
+	cs := spec.Default()
+	cs.Provider = ro.provider
+	cs.Nodes.Count = 1 + ro.workers
+	cs.Nodes.Memory = ro.workersMemory
+	cs.Nodes.CPU = ro.workersCpu
+	cs.Nodes.SecondaryNics = ro.secondaryNics
+	cs.Nodes.Overrides = []spec.NodeOverride{
+		spec.NodeOverride{
+			Role:   spec.RoleControlPlane,
+			Memory: ro.masterMemory,
+			CPU:    ro.masterCpu,
+		},
+	}
+	cs.Services.Registry.Volume = ro.registryVolume
+	cs.Services.NFS.Data = ro.nfsData
+	cs.Ports = spec.Ports{
+		Random:     ro.randomPorts,
+		SSH:        ro.sshMasterPort,
+		SSHWorker:  ro.sshWorkerPort,
+		Registry:   ro.registryPort,
+		OCPConsole: ro.ocpConsolePort,
+		K8s:        ro.k8sPort,
+	}
+	cs.Images = ro.specImages
+	return cs
+}
+
+// applySpec replaces the options with the given cluster spec, refusing the settings OKD clusters don't support.
+func (ro *runOptions) applySpec(cs spec.Cluster) error {
+	unsupported := []string{}
+	if cs.Pod {
+		unsupported = append(unsupported, "pod")
+	}
+	if cs.Nodes.Reverse {
+		unsupported = append(unsupported, "nodes.reverse")
+	}
+	if len(cs.Nodes.ProvisionOrder) > 0 {
+		unsupported = append(unsupported, "nodes.provisionOrder")
+	}
+	if cs.Services.Ceph.Enabled {
+		unsupported = append(unsupported, "services.ceph")
+	}
+	if cs.Services.Fluentd.LogDir != "" {
+		unsupported = append(unsupported, "services.fluentd")
+	}
+	if cs.Ports.VNC != 0 {
+		unsupported = append(unsupported, "ports.vnc")
+	}
+	if cs.Ports.OCP != 0 {
+		unsupported = append(unsupported, "ports.ocp")
+	}
+	master := cs.Nodes.Resources(1)
+	worker := cs.Nodes.Resources(2)
+	if master.QemuArgs != "" || worker.QemuArgs != "" {
+		unsupported = append(unsupported, "qemuArgs")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("invalid cluster spec: not supported by OKD clusters: %s", strings.Join(unsupported, ", "))
+	}
+	if cs.Nodes.Count < 1 {
+		return fmt.Errorf("invalid cluster spec: nodes.count must be at least 1")
+	}
+	if cs.Nodes.Count > 1 && master.SecondaryNics != worker.SecondaryNics {
+		return fmt.Errorf("invalid cluster spec: all the OKD nodes must have the same number of secondary nics")
+	}
+	for idx := uint(3); idx <= cs.Nodes.Count; idx++ {
+		if cs.Nodes.Resources(idx) != worker {
+			return fmt.Errorf("invalid cluster spec: all the OKD workers must have the same resources, %s differs from %s", spec.NodeName(idx), spec.NodeName(2))
+		}
+	}
+
+	ro.provider = cs.Provider
+	ro.workers = cs.Nodes.Count - 1
+	ro.masterMemory = master.Memory
+	ro.masterCpu = master.CPU
+	ro.workersMemory = worker.Memory
+	ro.workersCpu = worker.CPU
+	ro.secondaryNics = master.SecondaryNics
+	ro.registryVolume = cs.Services.Registry.Volume
+	ro.nfsData = cs.Services.NFS.Data
+	ro.randomPorts = cs.Ports.Random
+	ro.sshMasterPort = cs.Ports.SSH
+	ro.sshWorkerPort = cs.Ports.SSHWorker
+	ro.registryPort = cs.Ports.Registry
+	ro.ocpConsolePort = cs.Ports.OCPConsole
+	ro.k8sPort = cs.Ports.K8s
+	ro.specImages = cs.Images
+	return nil
+}
+
+// memoryMB converts an amount of memory (e.g. 4096M, 4G) to MB, as the OKD scripts expect it.
+// Amounts without unit are already in MB.
+func memoryMB(amount string) (string, error) {
+	value, err := strconv.ParseUint(strings.TrimRight(amount, "KMG"), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid amount of memory (e.g. 4096M, 4G)", amount)
+	}
+	switch {
+	case strings.HasSuffix(amount, "K"):
+		value /= 1024
+	case strings.HasSuffix(amount, "G"):
+		value *= 1024
+	}
+	return strconv.FormatUint(value, 10), nil
+}
+
 // NewRunCommand returns command that runs OKD cluster
 func NewRunCommand() *cobra.Command {
 	flags := &runOptions{}
 
 	run := &cobra.Command{
-		Use:   "okd",
+		Use:   "okd [PROVIDER]",
 		Short: "run OKD cluster",
+		Long: `run OKD cluster
+
+The cluster can be described using command line flags, using a spec file, or both.
+Command line flags take precedence over the content of the spec file.
+The provider image can be omitted from the command line if it is set in the spec file.
+In the spec file, the master is the control-plane node and all the other nodes are workers.
+`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run(cmd, flags, args)
 		},
-		Args: cobra.ExactArgs(1),
+		Args: cobra.MaximumNArgs(1),
 	}
 
 	flags.privileged = true // always
+	run.Flags().StringVarP(&flags.specFile, "file", "f", "", "read the cluster spec from the given YAML or JSON file")
+	run.Flags().BoolVar(&flags.dumpSpec, "dump-spec", false, "print the effective cluster spec and exit")
 	run.Flags().StringVar(&flags.masterMemory, "master-memory", "12288", "amount of RAM in MB on the master")
-	run.Flags().StringVar(&flags.masterCpu, "master-cpu", "4", "number of CPU cores on the master")
-	run.Flags().StringVar(&flags.workers, "workers", "1", "number of cluster worker nodes to start")
+	run.Flags().UintVar(&flags.masterCpu, "master-cpu", 4, "number of CPU cores on the master")
+	run.Flags().UintVar(&flags.workers, "workers", 1, "number of cluster worker nodes to start")
 	run.Flags().StringVar(&flags.workersMemory, "workers-memory", "6144", "amount of RAM in MB per worker")
-	run.Flags().StringVar(&flags.workersCpu, "workers-cpu", "2", "number of CPU per worker")
+	run.Flags().UintVar(&flags.workersCpu, "workers-cpu", 2, "number of CPU per worker")
 	run.Flags().UintVar(&flags.secondaryNics, "secondary-nics", 0, "number of secondary nics to add")
 	run.Flags().StringVar(&flags.registryVolume, "registry-volume", "", "cache docker registry content in the specified volume")
 	run.Flags().StringVar(&flags.nfsData, "nfs-data", "", "path to data which should be exposed via nfs to the nodes")
@@ -89,49 +220,110 @@ func run(cmd *cobra.Command, okdRunOpts *runOptions, args []string) (err error)
 		return err
 	}
 
+	if okdRunOpts.specFile != "" {
+		err = cmdutil.LoadSpecFile(cmd.Flags(), okdRunOpts.specFile, okdRunOpts.toSpec(), okdRunOpts.applySpec)
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) == 1 {
+		okdRunOpts.provider = args[0]
+	}
+
+	cs := okdRunOpts.toSpec()
+	err = cs.Validate()
+	if err != nil {
+		return err
+	}
+
+	auxImages, err := images.Load()
+	if err != nil {
+		return err
+	}
+	okdRunOpts.auxImages = auxImages.Merge(okdRunOpts.specImages)
+
+	if okdRunOpts.dumpSpec {
+		cs.Images = okdRunOpts.auxImages
+		return cs.Write(cmd.OutOrStdout())
+	}
+
+	masterMemory, err := memoryMB(okdRunOpts.masterMemory)
+	if err != nil {
+		return err
+	}
+	workersMemory, err := memoryMB(okdRunOpts.workersMemory)
+	if err != nil {
+		return err
+	}
+
 	envs := []string{}
-	envs = append(envs, fmt.Sprintf("WORKERS=%s", okdRunOpts.workers))
-	envs = append(envs, fmt.Sprintf("MASTER_MEMORY=%s", okdRunOpts.masterMemory))
-	envs = append(envs, fmt.Sprintf("MASTER_CPU=%s", okdRunOpts.masterCpu))
-	envs = append(envs, fmt.Sprintf("WORKERS_MEMORY=%s", okdRunOpts.workersMemory))
-	envs = append(envs, fmt.Sprintf("WORKERS_CPU=%s", okdRunOpts.workersCpu))
+	envs = append(envs, fmt.Sprintf("WORKERS=%d", okdRunOpts.workers))
+	envs = append(envs, fmt.Sprintf("MASTER_MEMORY=%s", masterMemory))
+	envs = append(envs, fmt.Sprintf("MASTER_CPU=%d", okdRunOpts.masterCpu))
+	envs = append(envs, fmt.Sprintf("WORKERS_MEMORY=%s", workersMemory))
+	envs = append(envs, fmt.Sprintf("WORKERS_CPU=%d", okdRunOpts.workersCpu))
 	envs = append(envs, fmt.Sprintf("NUM_SECONDARY_NICS=%d", okdRunOpts.secondaryNics))
 
-	portMap, err := ports.NewMappingFromFlags(cmd.Flags(), []ports.PortInfo{
+	portMap := ports.NewMapping([]ports.PortInfo{
 		ports.PortInfo{
 			ExposedPort: ports.PortSSH,
 			Name:        "ssh-master-port",
+			PublicPort:  okdRunOpts.sshMasterPort,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortSSHWorker,
 			Name:        "ssh-worker-port",
+			PublicPort:  okdRunOpts.sshWorkerPort,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortAPI,
 			Name:        "k8s-port",
+			PublicPort:  okdRunOpts.k8sPort,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortOCPConsole,
 			Name:        "ocp-console-port",
+			PublicPort:  okdRunOpts.ocpConsolePort,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortRegistry,
 			Name:        "registry-port",
+			PublicPort:  okdRunOpts.registryPort,
 		},
 	})
+
+	cluster := okdRunOpts.provider
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := cOpts.GetLogger()
+	hnd, err := cmdutil.NewRuntime(ctx, cOpts.Runtime, cOpts.PodmanSocket, log)
 	if err != nil {
 		return err
 	}
 
-	cluster := args[0]
+	if !okdRunOpts.downloadOnly {
+		_, err = cmdutil.RegisterCluster(hnd, cOpts, cluster, portMap.HostPorts())
+		if err != nil {
+			return err
+		}
 
-	ctx, cancel := context.WithCancel(context.Background())
+		defer func() {
+			// unless left running in background, the ledger removes the cluster on exit
+			if err != nil || !okdRunOpts.background {
+				if unregErr := cmdutil.UnregisterCluster(cOpts); unregErr != nil {
+					log.Warningf("cannot unregister cluster %s: %v", cOpts.Prefix, unregErr)
+				}
+			}
+		}()
+	}
 
-	log := cOpts.GetLogger()
-	hnd, err := podman.NewHandle(ctx, cOpts.PodmanSocket, log)
+	pullConfig, err := images.LoadPullConfig()
 	if err != nil {
 		return err
 	}
+	hnd.SetPullConfig(pullConfig)
 
 	log.Noticef("downloading all the images needed for %s (from %s)", cluster, cOpts.Registry)
 	err = hnd.PullClusterImages(okdRunOpts, cOpts.Registry, cluster)
@@ -143,15 +335,19 @@ func run(cmd *cobra.Command, okdRunOpts *runOptions, args []string) (err error)
 	ldgr := ledger.NewLedger(hnd, cmd.OutOrStderr(), log)
 
 	defer func() {
-		ldgr.Done <- err
+		ldgr.Close(err)
 	}()
 
 	go func() {
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, os.Interrupt)
-		<-interrupt
-		cancel()
-		ldgr.Done <- fmt.Errorf("Interrupt received, clean up")
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			cancel()
+			ldgr.Close(fmt.Errorf("Interrupt received, clean up"))
+		case <-ldgr.Finished():
+		}
 	}()
 
 	clusterContainerName := cOpts.Prefix + "-cluster"
@@ -176,14 +372,14 @@ func run(cmd *cobra.Command, okdRunOpts *runOptions, args []string) (err error)
 		return err
 	}
 
-	clusterNetwork := fmt.Sprintf("container:%s", clusterID)
+	clusterNetwork := cmdutil.ClusterNetwork{Container: clusterID}
 
-	err = cmdutil.SetupRegistry(ldgr, cOpts.Prefix, clusterNetwork, okdRunOpts.registryVolume, okdRunOpts.privileged)
+	err = cmdutil.SetupRegistry(ldgr, cOpts.Prefix, clusterNetwork, okdRunOpts.auxImages, okdRunOpts.registryVolume, okdRunOpts.privileged)
 	if err != nil {
 		return err
 	}
 	if okdRunOpts.nfsData != "" {
-		err = cmdutil.SetupNFS(ldgr, cOpts.Prefix, clusterNetwork, okdRunOpts.nfsData, okdRunOpts.privileged)
+		err = cmdutil.SetupNFS(ldgr, cOpts.Prefix, clusterNetwork, okdRunOpts.auxImages, okdRunOpts.nfsData, okdRunOpts.privileged)
 		if err != nil {
 			return err
 		}
@@ -198,7 +394,7 @@ func run(cmd *cobra.Command, okdRunOpts *runOptions, args []string) (err error)
 
 	// If background flag was specified, we don't want to clean up if we reach that state
 	if !okdRunOpts.background {
-		ldgr.Done <- fmt.Errorf("Done. please clean up")
+		ldgr.Close(fmt.Errorf("Done. please clean up"))
 	}
 
 	return nil
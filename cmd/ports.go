@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/spf13/cobra"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
 
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
 	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	"github.com/fromanirh/pack8s/iopodman"
 )
 
 // NewPortCommand returns new command to expose public ports for the cluster
@@ -21,6 +24,9 @@ If no port name is specified, all exposed ports are printed.
 If an extra port name is specified, only the exposed port is printed.
 
 Known port names are 'ssh', 'registry', 'ocp' and 'k8s'.
+
+With structured output (-o json or -o yaml), ports are reported as a map from the port name
+to the public port. Exposed ports without a known name are keyed by their container port.
 `,
 		RunE: showPorts,
 		Args: func(cmd *cobra.Command, args []string) error {
@@ -59,7 +65,7 @@ func showPorts(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	hnd, _, err := cOpts.GetHandle()
+	hnd, _, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
@@ -68,12 +74,20 @@ func showPorts(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	cont.Ports, err = publishedPorts(hnd, prefix, cont)
+	if err != nil {
+		return err
+	}
 
 	portName := ""
 	if len(args) > 0 {
 		portName = args[0]
 	}
 
+	if cOpts.WantsStructuredOutput() {
+		return printPortsResult(cmd, cOpts, portName, cont.Ports)
+	}
+
 	if portName != "" {
 		port, err := ports.NameToNumber(portName)
 		if err != nil {
@@ -86,9 +100,63 @@ func showPorts(cmd *cobra.Command, args []string) error {
 		}
 	} else {
 		for _, p := range cont.Ports {
-			fmt.Printf("%s/%s -> %s:%s\n", p.Container_port, p.Protocol, p.Host_ip, p.Host_port)
+			hostIP := p.Host_ip
+			if cOpts.IsRemote() {
+				// the addresses podman reports are meaningful only on its own host
+				hostIP = cOpts.HostAddress()
+			}
+			fmt.Printf("%s/%s -> %s:%s\n", p.Container_port, p.Protocol, hostIP, p.Host_port)
 		}
 	}
 
 	return nil
 }
+
+func printPortsResult(cmd *cobra.Command, cOpts cmdutil.CommonOpts, portName string, containerPorts []iopodman.ContainerPortMappings) error {
+	res := make(map[string]int)
+	if portName != "" {
+		port, err := ports.NameToNumber(portName)
+		if err != nil {
+			return err
+		}
+		publicPort, err := ports.GetPublicPort(port, containerPorts)
+		if err != nil {
+			return err
+		}
+		res[portName] = publicPort
+		return cOpts.PrintResult(cmd.OutOrStdout(), res)
+	}
+
+	for _, p := range containerPorts {
+		publicPort, err := strconv.Atoi(p.Host_port)
+		if err != nil {
+			return err
+		}
+		key := p.Container_port
+		if port, err := strconv.Atoi(p.Container_port); err == nil {
+			if name, err := ports.NumberToName(port); err == nil {
+				key = name
+			}
+		}
+		res[key] = publicPort
+	}
+	return cOpts.PrintResult(cmd.OutOrStdout(), res)
+}
+
+// publishedPorts returns the ports published by the given container. The containers of pod based
+// clusters don't publish ports by themselves: the ports published by the cluster pod are returned instead.
+func publishedPorts(hnd podman.Runtime, prefix string, cont iopodman.Container) ([]iopodman.ContainerPortMappings, error) {
+	if len(cont.Ports) > 0 {
+		return cont.Ports, nil
+	}
+	return podPorts(hnd, prefix)
+}
+
+// podPorts returns the ports published by the pod of the cluster, if any.
+func podPorts(hnd podman.Runtime, prefix string) ([]iopodman.ContainerPortMappings, error) {
+	pod, found, err := hnd.FindPod(prefix)
+	if err != nil || !found {
+		return nil, err
+	}
+	return hnd.GetPodPorts(pod)
+}
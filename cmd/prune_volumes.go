@@ -23,7 +23,7 @@ func pruneVolumes(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	hnd, _, err := cOpts.GetHandle()
+	hnd, _, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
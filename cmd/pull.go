@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	logger "github.com/apsdehal/go-logger"
@@ -10,10 +12,14 @@ import (
 	spin "github.com/tj/go-spin"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/pullprogress"
 )
 
 type pullOptions struct {
 	auxImages bool
+	images    images.Set
 }
 
 func (po pullOptions) WantsNFS() bool {
@@ -28,6 +34,10 @@ func (po pullOptions) WantsFluentd() bool {
 	return po.auxImages
 }
 
+func (po pullOptions) AuxImages() images.Set {
+	return po.images
+}
+
 func NewPullCommand() *cobra.Command {
 	flags := &pullOptions{}
 	show := &cobra.Command{
@@ -42,28 +52,79 @@ func NewPullCommand() *cobra.Command {
 	return show
 }
 
+const (
+	pullBarWidth = 30
+)
+
+// termProgressReporter renders the progress of the image pulls as a progress bar.
+// The progress of concurrent pulls is merged in one bar.
 type termProgressReporter struct {
 	Log      *logger.Logger
 	Spin     *spin.Spinner
 	Interval time.Duration
+	board    *pullprogress.Board
+	lock     sync.Mutex
+}
+
+func newTermProgressReporter(log *logger.Logger, interval time.Duration) *termProgressReporter {
+	s := spin.New()
+	s.Set(spin.Spin1)
+	return &termProgressReporter{
+		Log:      log,
+		Spin:     s,
+		Interval: interval,
+		board:    pullprogress.NewBoard(),
+	}
 }
 
-func (tpp termProgressReporter) GetInterval() time.Duration {
+func (tpp *termProgressReporter) GetInterval() time.Duration {
 	return tpp.Interval
 }
 
-func (tpp termProgressReporter) Report(ref string, elapsed, completed uint64, err error) error {
+func (tpp *termProgressReporter) Report(progress pullprogress.Progress, err error) error {
+	tpp.lock.Lock()
+	defer tpp.lock.Unlock()
+
+	ref := progress.Ref
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "\n")
+		tpp.board.Remove(ref)
+		fmt.Fprintf(os.Stderr, "\r\x1b[2K")
 		tpp.Log.Warningf("download failed for %s: %v\n", ref, err)
-	} else if completed != 0 && elapsed == completed {
-		fmt.Fprintf(os.Stderr, "\n")
-		tpp.Log.Noticef("downloaded completed for %s", ref)
-	} else {
-		fmt.Fprintf(os.Stderr, "\rdownloading %s... %s ", ref, tpp.Spin.Next())
+		return err
 	}
-	return err
 
+	merged := tpp.board.Update(progress)
+	if progress.Done {
+		// clear the bar, it is redrawn below the message
+		fmt.Fprintf(os.Stderr, "\r\x1b[2K")
+		tpp.Log.Noticef("download completed for %s in %v", ref, progress.Elapsed.Round(time.Second))
+	}
+	if !merged.Done {
+		fmt.Fprintf(os.Stderr, "\r%s", formatPullProgress(merged, tpp.Spin))
+	}
+	return nil
+}
+
+func formatPullProgress(progress pullprogress.Progress, s *spin.Spinner) string {
+	percent := progress.Percent()
+	layers := fmt.Sprintf("%d/%d layers", progress.LayersDone(), len(progress.Layers))
+	if percent < 0 {
+		return fmt.Sprintf("downloading %s... %s %s ", progress.Ref, s.Next(), layers)
+	}
+
+	filled := percent * pullBarWidth / 100
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", pullBarWidth-filled)
+	if filled > 0 && filled < pullBarWidth {
+		bar = bar[:filled-1] + ">" + bar[filled:]
+	}
+	current, total := progress.Bytes()
+	eta := ""
+	if remaining := progress.ETA(); remaining > 0 {
+		eta = fmt.Sprintf(" ETA %v", remaining)
+	}
+	// pad, so the leftovers of longer lines are overwritten
+	return fmt.Sprintf("%s [%s] %3d%% %s/%s %s%s    ", progress.Ref, bar, percent,
+		pullprogress.FormatSize(current), pullprogress.FormatSize(total), layers, eta)
 }
 
 func pullImage(cmd *cobra.Command, pullOpts *pullOptions, args []string) error {
@@ -72,27 +133,29 @@ func pullImage(cmd *cobra.Command, pullOpts *pullOptions, args []string) error {
 		return err
 	}
 
-	hnd, log, err := cOpts.GetHandle()
+	hnd, log, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
 
 	if cOpts.IsTTY {
-		s := spin.New()
-		s.Set(spin.Spin1)
-		tpp := termProgressReporter{
-			Log:      log,
-			Spin:     s,
-			Interval: 1,
-		}
-		hnd.PullReporter = tpp
+		hnd.SetPullReporter(newTermProgressReporter(log, 1))
 	}
+	pullConfig, err := images.LoadPullConfig()
+	if err != nil {
+		return err
+	}
+	hnd.SetPullConfig(pullConfig)
 
 	cluster := args[0]
 	if pullOpts.auxImages {
+		pullOpts.images, err = images.Load()
+		if err != nil {
+			return err
+		}
 		// if we always do PullClusterImages, we bring the docker registry, which is something
 		// we may actually don't want to do here (wasted work)
 		return hnd.PullClusterImages(pullOpts, cOpts.Registry, cluster)
 	}
-	return hnd.PullImageFromRegistry(cOpts.Registry, cluster)
+	return hnd.PullImage(cOpts.Registry + "/" + cluster)
 }
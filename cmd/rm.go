@@ -9,6 +9,7 @@ import (
 
 	"github.com/fromanirh/pack8s/iopodman"
 
+	"github.com/fromanirh/pack8s/internal/pkg/ledger"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
 )
 
@@ -61,27 +62,54 @@ func remove(cmd *cobra.Command, rmOpts *rmOptions) error {
 		return err
 	}
 
-	hnd, log, err := cOpts.GetHandle()
+	hnd, log, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
 
-	containers, err := hnd.GetPrefixedContainers(cOpts.Prefix)
+	force := true
+	removeVolumes := true
+
+	// the images imported restoring a snapshot are removed along with the last cluster using them
+	snapshotImages, err := getSnapshotImages(hnd, cOpts.Prefix)
 	if err != nil {
 		return err
 	}
 
-	sort.Sort(containerList(containers))
+	pod, podFound, err := hnd.FindPod(cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	if podFound {
+		log.Infof("bringing pod %s down (containers=%d)", pod.Name, len(pod.Containersinfo))
 
-	force := true
-	removeVolumes := true
+		// the cluster is going away, so it gets the same grace period as on rollback
+		_, err = hnd.StopPod(pod.Name, ledger.StopTimeout)
+		if err != nil {
+			return err
+		}
+
+		log.Noticef("removing pod: %s", pod.Name)
+		_, err = hnd.RemovePod(pod.Name, force)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the members of the pod, if any, are already gone: these are the containers of clusters without pod
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(containerList(containers))
 
 	log.Infof("bringing cluster down (containers=%d)", len(containers))
 
 	for _, cont := range containers {
 		log.Noticef("stopping container: %s", cont.Names)
 
-		_, err = hnd.StopContainer(cont.Id, 5) // TODO
+		_, err = hnd.StopContainer(cont.Id, ledger.StopTimeout)
 		if err != nil {
 			return err
 		}
@@ -93,7 +121,14 @@ func remove(cmd *cobra.Command, rmOpts *rmOptions) error {
 		}
 	}
 
-	volumes, err := hnd.GetPrefixedVolumes(cOpts.Prefix)
+	for _, image := range snapshotImages {
+		log.Noticef("removing snapshot image: %s", image)
+		if err := hnd.RemoveImage(image); err != nil {
+			log.Infof("keeping snapshot image %s: %v", image, err)
+		}
+	}
+
+	volumes, err := cmdutil.ClusterVolumes(hnd, cOpts.Prefix)
 	if err != nil {
 		return err
 	}
@@ -106,6 +141,11 @@ func remove(cmd *cobra.Command, rmOpts *rmOptions) error {
 		}
 	}
 
+	err = cmdutil.UnregisterCluster(cOpts)
+	if err != nil {
+		return err
+	}
+
 	if rmOpts.prune {
 		err = hnd.PruneVolumes()
 	}
@@ -27,6 +27,12 @@ func NewRootCommand() *cobra.Command {
 	cmdutil.AddCommonOpts(root)
 
 	root.AddCommand(
+		NewBundleCommand(),
+		NewExportKubeCommand(),
+		NewImagesCommand(),
+		NewKubeconfigCommand(),
+		NewListCommand(),
+		NewLogsCommand(),
 		NewPortCommand(),
 		NewPullCommand(),
 		NewRemoveCommand(),
@@ -34,6 +40,10 @@ func NewRootCommand() *cobra.Command {
 		NewSCPCommand(),
 		NewSSHCommand(),
 		NewShowCommand(),
+		NewSnapshotCommand(),
+		NewStartCommand(),
+		NewStatusCommand(),
+		NewStopCommand(),
 		NewPruneVolumesCommand(),
 		NewExecCommand(),
 		NewVersionCommand(),
@@ -44,7 +54,15 @@ func NewRootCommand() *cobra.Command {
 }
 
 func Execute() {
-	if err := NewRootCommand().Execute(); err != nil {
+	err := NewRootCommand().Execute()
+	podman.CloseTunnels()
+	if err != nil {
+		if exitErr, ok := err.(*cmdutil.ExitError); ok {
+			if exitErr.Err != nil {
+				fmt.Println(podman.SprintError("pack8s", exitErr.Err))
+			}
+			os.Exit(exitErr.Code)
+		}
 		fmt.Println(podman.SprintError("pack8s", err)) //XXX specific method
 		os.Exit(1)
 	}
@@ -4,22 +4,30 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	logger "github.com/apsdehal/go-logger"
 	"github.com/spf13/cobra"
 
 	"github.com/fromanirh/pack8s/iopodman"
 
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
 	"github.com/fromanirh/pack8s/internal/pkg/images"
 	"github.com/fromanirh/pack8s/internal/pkg/ledger"
 	"github.com/fromanirh/pack8s/internal/pkg/mounts"
+	"github.com/fromanirh/pack8s/internal/pkg/parallel"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
 	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	"github.com/fromanirh/pack8s/internal/pkg/prefixwriter"
+	"github.com/fromanirh/pack8s/internal/pkg/readiness"
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
 
@@ -28,36 +36,14 @@ import (
 
 type runOptions struct {
 	privileged     bool
-	nodes          uint
-	memory         string
-	cpu            uint
-	secondaryNics  uint
-	qemuArgs       string
 	background     bool
-	reverse        bool
-	randomPorts    bool
-	registryVolume string
-	vncPort        uint
-	registryPort   uint
-	ocpPort        uint
-	k8sPort        uint
-	sshPort        uint
-	nfsData        string
-	logDir         string
-	enableCeph     bool
 	downloadOnly   bool
-}
-
-func (ro runOptions) WantsNFS() bool {
-	return ro.nfsData != ""
-}
-
-func (ro runOptions) WantsCeph() bool {
-	return ro.enableCeph
-}
-
-func (ro runOptions) WantsFluentd() bool {
-	return ro.logDir != ""
+	specFile       string
+	dumpSpec       bool
+	nodeOverrides  []string
+	provisionOrder string
+	readiness      readiness.Options
+	spec           spec.Cluster
 }
 
 // NewRunCommand returns command that runs given cluster
@@ -65,32 +51,50 @@ func NewRunCommand() *cobra.Command {
 	flags := &runOptions{}
 
 	run := &cobra.Command{
-		Use:   "run",
+		Use:   "run [PROVIDER]",
 		Short: "run a given cluster",
+		Long: `run a given cluster
+
+The cluster can be described using command line flags, using a spec file, or both.
+Command line flags take precedence over the content of the spec file.
+The provider image can be omitted from the command line if it is set in the spec file.
+`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return run(cmd, flags, args)
 		},
-		Args: cobra.ExactArgs(1),
+		Args: cobra.MaximumNArgs(1),
 	}
 
+	def := spec.Default()
+	flags.spec = def
+	flags.readiness = readiness.DefaultOptions()
 	flags.privileged = true // always
-	run.Flags().UintVarP(&flags.nodes, "nodes", "n", 1, "number of cluster nodes to start")
-	run.Flags().StringVarP(&flags.memory, "memory", "m", "3096M", "amount of ram per node")
-	run.Flags().UintVarP(&flags.cpu, "cpu", "c", 2, "number of cpu cores per node")
-	run.Flags().UintVarP(&flags.secondaryNics, "secondary-nics", "", 0, "number of secondary nics to add")
-	run.Flags().StringVar(&flags.qemuArgs, "qemu-args", "", "additional qemu args to pass through to the nodes")
+	run.Flags().StringVarP(&flags.specFile, "file", "f", "", "read the cluster spec from the given YAML or JSON file")
+	run.Flags().BoolVar(&flags.dumpSpec, "dump-spec", false, "print the effective cluster spec and exit")
+	run.Flags().UintVarP(&flags.spec.Nodes.Count, "nodes", "n", def.Nodes.Count, "number of cluster nodes to start")
+	run.Flags().StringVarP(&flags.spec.Nodes.Memory, "memory", "m", def.Nodes.Memory, "amount of ram per node")
+	run.Flags().UintVarP(&flags.spec.Nodes.CPU, "cpu", "c", def.Nodes.CPU, "number of cpu cores per node")
+	run.Flags().UintVarP(&flags.spec.Nodes.SecondaryNics, "secondary-nics", "", def.Nodes.SecondaryNics, "number of secondary nics to add")
+	run.Flags().StringVar(&flags.spec.Nodes.QemuArgs, "qemu-args", def.Nodes.QemuArgs, "additional qemu args to pass through to the nodes")
+	run.Flags().StringArrayVar(&flags.nodeOverrides, "node-override", []string{}, "override the node resources: SELECTOR:KEY=VALUE[,KEY=VALUE...] (e.g. node02:memory=8G,cpu=4 or worker:secondary-nics=2)")
+	run.Flags().StringVar(&flags.provisionOrder, "provision-order", "", "provisioning stages, separated by ':'; nodes in the same stage, separated by ',', are provisioned in parallel. '*' means all the other nodes (e.g. node01:*)")
+	run.Flags().DurationVar(&flags.readiness.Timeout, "boot-timeout", flags.readiness.Timeout, "maximum time to wait for each node to become reachable through SSH (0 waits forever)")
+	run.Flags().DurationVar(&flags.readiness.Interval, "probe-interval", flags.readiness.Interval, "initial interval between readiness checks")
+	run.Flags().DurationVar(&flags.readiness.MaxInterval, "probe-max-interval", flags.readiness.MaxInterval, "maximum interval between readiness checks")
+	run.Flags().Float64Var(&flags.readiness.Backoff, "probe-backoff", flags.readiness.Backoff, "factor to increase the interval between readiness checks")
 	run.Flags().BoolVarP(&flags.background, "background", "b", false, "go to background after nodes are up")
-	run.Flags().BoolVarP(&flags.reverse, "reverse", "r", false, "revert node startup order")
-	run.Flags().BoolVar(&flags.randomPorts, "random-ports", true, "expose all ports on random localhost ports")
-	run.Flags().StringVar(&flags.registryVolume, "registry-volume", "", "cache docker registry content in the specified volume")
-	run.Flags().UintVar(&flags.vncPort, "vnc-port", 0, "port on localhost for vnc")
-	run.Flags().UintVar(&flags.registryPort, "registry-port", 0, "port on localhost for the docker registry")
-	run.Flags().UintVar(&flags.ocpPort, "ocp-port", 0, "port on localhost for the ocp cluster")
-	run.Flags().UintVar(&flags.k8sPort, "k8s-port", 0, "port on localhost for the k8s cluster")
-	run.Flags().UintVar(&flags.sshPort, "ssh-port", 0, "port on localhost for ssh server")
-	run.Flags().StringVar(&flags.nfsData, "nfs-data", "", "path to data which should be exposed via nfs to the nodes")
-	run.Flags().StringVar(&flags.logDir, "log-to-dir", "", "enables aggregated cluster logging to the folder")
-	run.Flags().BoolVar(&flags.enableCeph, "enable-ceph", false, "enables dynamic storage provisioning using Ceph")
+	run.Flags().BoolVarP(&flags.spec.Nodes.Reverse, "reverse", "r", def.Nodes.Reverse, "revert node startup order")
+	run.Flags().BoolVar(&flags.spec.Ports.Random, "random-ports", def.Ports.Random, "expose all ports on random localhost ports")
+	run.Flags().BoolVar(&flags.spec.Pod, "pod", def.Pod, "run all the cluster containers in one pod, which publishes the cluster ports")
+	run.Flags().StringVar(&flags.spec.Services.Registry.Volume, "registry-volume", def.Services.Registry.Volume, "cache docker registry content in the specified volume")
+	run.Flags().UintVar(&flags.spec.Ports.VNC, "vnc-port", def.Ports.VNC, "port on localhost for vnc")
+	run.Flags().UintVar(&flags.spec.Ports.Registry, "registry-port", def.Ports.Registry, "port on localhost for the docker registry")
+	run.Flags().UintVar(&flags.spec.Ports.OCP, "ocp-port", def.Ports.OCP, "port on localhost for the ocp cluster")
+	run.Flags().UintVar(&flags.spec.Ports.K8s, "k8s-port", def.Ports.K8s, "port on localhost for the k8s cluster")
+	run.Flags().UintVar(&flags.spec.Ports.SSH, "ssh-port", def.Ports.SSH, "port on localhost for ssh server")
+	run.Flags().StringVar(&flags.spec.Services.NFS.Data, "nfs-data", def.Services.NFS.Data, "path to data which should be exposed via nfs to the nodes")
+	run.Flags().StringVar(&flags.spec.Services.Fluentd.LogDir, "log-to-dir", def.Services.Fluentd.LogDir, "enables aggregated cluster logging to the folder")
+	run.Flags().BoolVar(&flags.spec.Services.Ceph.Enabled, "enable-ceph", def.Services.Ceph.Enabled, "enables dynamic storage provisioning using Ceph")
 	run.Flags().BoolVar(&flags.downloadOnly, "download-only", false, "download cluster images and exith")
 
 	run.AddCommand(
@@ -105,44 +109,109 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 		return err
 	}
 
-	portMap, err := ports.NewMappingFromFlags(cmd.Flags(), []ports.PortInfo{
+	if runOpts.specFile != "" {
+		err = cmdutil.LoadSpecFile(cmd.Flags(), runOpts.specFile, spec.Default(), func(cs spec.Cluster) error {
+			runOpts.spec = cs
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) == 1 {
+		runOpts.spec.Provider = args[0]
+	}
+	for _, nodeOverride := range runOpts.nodeOverrides {
+		ov, err := spec.ParseNodeOverride(nodeOverride)
+		if err != nil {
+			return err
+		}
+		runOpts.spec.Nodes.Overrides = append(runOpts.spec.Nodes.Overrides, ov)
+	}
+	if runOpts.provisionOrder != "" {
+		runOpts.spec.Nodes.ProvisionOrder = spec.ParseProvisionOrder(runOpts.provisionOrder)
+	}
+
+	err = runOpts.spec.Validate()
+	if err != nil {
+		return err
+	}
+
+	auxImages, err := images.Load()
+	if err != nil {
+		return err
+	}
+	runOpts.spec.Images = auxImages.Merge(runOpts.spec.Images)
+
+	if runOpts.dumpSpec {
+		return runOpts.spec.Write(cmd.OutOrStdout())
+	}
+
+	portMap := ports.NewMapping([]ports.PortInfo{
 		ports.PortInfo{
 			ExposedPort: ports.PortSSH,
 			Name:        "ssh-port",
+			PublicPort:  runOpts.spec.Ports.SSH,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortVNC,
 			Name:        "vnc-port",
+			PublicPort:  runOpts.spec.Ports.VNC,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortAPI,
 			Name:        "k8s-port",
+			PublicPort:  runOpts.spec.Ports.K8s,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortOCP,
 			Name:        "ocp-port",
+			PublicPort:  runOpts.spec.Ports.OCP,
 		},
 		ports.PortInfo{
 			ExposedPort: ports.PortRegistry,
 			Name:        "registry-port",
+			PublicPort:  runOpts.spec.Ports.Registry,
 		},
 	})
-	if err != nil {
-		return err
-	}
 
-	cluster := args[0]
+	cluster := runOpts.spec.Provider
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	log := cOpts.GetLogger()
-	hnd, err := podman.NewHandle(ctx, cOpts.PodmanSocket, log)
+	hnd, err := cmdutil.NewRuntime(ctx, cOpts.Runtime, cOpts.PodmanSocket, log)
+	if err != nil {
+		return err
+	}
+
+	clusterEntry := clusters.Cluster{MACRange: clusters.FirstMACRange}
+	if !runOpts.downloadOnly {
+		clusterEntry, err = cmdutil.RegisterCluster(hnd, cOpts, cluster, portMap.HostPorts())
+		if err != nil {
+			return err
+		}
+		log.Infof("cluster %s registered, MAC range %s:xx", cOpts.Prefix, clusterEntry.MACPrefix())
+
+		defer func() {
+			// unless left running in background, the ledger removes the cluster on exit
+			if err != nil || !runOpts.background {
+				if unregErr := cmdutil.UnregisterCluster(cOpts); unregErr != nil {
+					log.Warningf("cannot unregister cluster %s: %v", cOpts.Prefix, unregErr)
+				}
+			}
+		}()
+	}
+
+	pullConfig, err := images.LoadPullConfig()
 	if err != nil {
 		return err
 	}
+	hnd.SetPullConfig(pullConfig)
 
 	log.Noticef("downloading all the images needed for %s (from %s)", cluster, cOpts.Registry)
-	err = hnd.PullClusterImages(runOpts, cOpts.Registry, cluster)
+	err = hnd.PullClusterImages(runOpts.spec, cOpts.Registry, cluster)
 	if err != nil || runOpts.downloadOnly {
 		return err
 	}
@@ -151,59 +220,93 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 	ldgr := ledger.NewLedger(hnd, cmd.OutOrStderr(), log)
 
 	defer func() {
-		ldgr.Done <- err
+		ldgr.Close(err)
 	}()
 
 	go func() {
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, os.Interrupt)
-		<-interrupt
-		cancel()
-		ldgr.Done <- fmt.Errorf("Interrupt received, clean up")
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			cancel()
+			ldgr.Close(fmt.Errorf("Interrupt received, clean up"))
+		case <-ldgr.Finished():
+		}
 	}()
 
 	dnsmasqName := fmt.Sprintf("%s-dnsmasq", cOpts.Prefix)
-	dnsmasqExpose := ports.ToStrings(
+	dnsmasqExposedPorts := []int{
 		ports.PortSSH, ports.PortRegistry, ports.PortOCP,
 		ports.PortAPI, ports.PortVNC,
-	)
+	}
+	dnsmasqExpose := ports.ToStrings(dnsmasqExposedPorts...)
 	dnsmasqPorts := portMap.ToStrings()
 	dnsmasqLabels := []string{fmt.Sprintf("%s=000", podman.LabelGeneration)}
-	dnsmasqID, err := ldgr.RunContainer(iopodman.Create{
+	servicesAddress := cmdutil.ServicesAddress()
+	dnsmasqConf := iopodman.Create{
 		AddHost: &[]string{
-			"nfs:192.168.66.2",
-			"registry:192.168.66.2",
-			"ceph:192.168.66.2",
+			"nfs:" + servicesAddress,
+			"registry:" + servicesAddress,
+			"ceph:" + servicesAddress,
 		},
 		Args: []string{cluster, "/bin/bash", "-c", "/dnsmasq.sh"},
 		Env: &[]string{
-			fmt.Sprintf("NUM_NODES=%d", runOpts.nodes),
-			fmt.Sprintf("NUM_SECONDARY_NICS=%d", runOpts.secondaryNics),
+			fmt.Sprintf("NUM_NODES=%d", runOpts.spec.Nodes.Count),
+			// dnsmasq needs to prepare the taps for the node with most nics
+			fmt.Sprintf("NUM_SECONDARY_NICS=%d", runOpts.spec.Nodes.MaxSecondaryNics()),
 		},
-		Expose:     &dnsmasqExpose,
 		Label:      &dnsmasqLabels,
 		Name:       &dnsmasqName,
 		Privileged: &runOpts.privileged,
-		Publish:    &dnsmasqPorts,
-		PublishAll: &runOpts.randomPorts,
-	})
+	}
+
+	var clusterNetwork cmdutil.ClusterNetwork
+	if runOpts.spec.Pod {
+		podPorts := dnsmasqPorts
+		if runOpts.spec.Ports.Random {
+			podPorts = portMap.ToStringsWithRandom(dnsmasqExposedPorts...)
+		}
+		_, err = ldgr.MakePod(iopodman.PodCreate{
+			Name:    cOpts.Prefix,
+			Labels:  map[string]string{},
+			Share:   []string{"net"},
+			Infra:   true,
+			Publish: podPorts,
+		})
+		if err != nil {
+			log.Errorf("Pod creation failed: %v", err)
+			return err
+		}
+		log.Noticef("Pod %s ready", cOpts.Prefix)
+		clusterNetwork.Pod = cOpts.Prefix
+		clusterNetwork.Apply(&dnsmasqConf)
+	} else {
+		dnsmasqConf.Expose = &dnsmasqExpose
+		dnsmasqConf.Publish = &dnsmasqPorts
+		dnsmasqConf.PublishAll = &runOpts.spec.Ports.Random
+	}
+
+	dnsmasqID, err := ldgr.RunContainer(dnsmasqConf)
 	if err != nil {
 		log.Errorf("DNSMasq run failed: %v", err)
 		return err
 	}
 	log.Noticef("DNSMasq container ready")
 
-	dnsmasqNetwork := fmt.Sprintf("container:%s", dnsmasqID)
+	if !runOpts.spec.Pod {
+		clusterNetwork.Container = dnsmasqID
+	}
 
-	err = cmdutil.SetupRegistry(ldgr, cOpts.Prefix, dnsmasqNetwork, runOpts.registryVolume, runOpts.privileged)
+	err = cmdutil.SetupRegistry(ldgr, cOpts.Prefix, clusterNetwork, runOpts.spec.Images, runOpts.spec.Services.Registry.Volume, runOpts.privileged)
 	if err != nil {
 		log.Errorf("Registry run failed: %v", err)
 		return err
 	}
 	log.Noticef("Registry container ready")
 
-	if runOpts.nfsData != "" {
-		err = cmdutil.SetupNFS(ldgr, cOpts.Prefix, dnsmasqNetwork, runOpts.nfsData, runOpts.privileged)
+	if runOpts.spec.Services.NFS.Data != "" {
+		err = cmdutil.SetupNFS(ldgr, cOpts.Prefix, clusterNetwork, runOpts.spec.Images, runOpts.spec.Services.NFS.Data, runOpts.privileged)
 		if err != nil {
 			log.Errorf("NFS run failed: %v", err)
 			return err
@@ -211,22 +314,23 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 		log.Noticef("NFS container ready")
 	}
 
-	if runOpts.enableCeph {
+	if runOpts.spec.Services.Ceph.Enabled {
 		cephName := fmt.Sprintf("%s-ceph", cOpts.Prefix)
 		cephLabels := []string{fmt.Sprintf("%s=011", podman.LabelGeneration)}
-		_, err = ldgr.RunContainer(iopodman.Create{
-			Args: []string{images.CephImage, "demo"},
+		cephConf := iopodman.Create{
+			Args: []string{runOpts.spec.Images.Ceph, "demo"},
 			Name: &cephName,
 			Env: &[]string{
-				"MON_IP=192.168.66.2",
+				"MON_IP=" + servicesAddress,
 				"CEPH_PUBLIC_NETWORK=0.0.0.0/0",
 				"DEMO_DAEMONS=osd,mds",
 				"CEPH_DEMO_UID=demo",
 			},
 			Label:      &cephLabels,
-			Network:    &dnsmasqNetwork,
 			Privileged: &runOpts.privileged,
-		})
+		}
+		clusterNetwork.Apply(&cephConf)
+		_, err = ldgr.RunContainer(cephConf)
 		if err != nil {
 			log.Errorf("CEPH run failed: %v", err)
 			return err
@@ -234,8 +338,8 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 		log.Noticef("CEPH container ready")
 	}
 
-	if runOpts.logDir != "" {
-		logDir, err := filepath.Abs(runOpts.logDir)
+	if runOpts.spec.Services.Fluentd.LogDir != "" {
+		logDir, err := filepath.Abs(runOpts.spec.Services.Fluentd.LogDir)
 		if err != nil {
 			return err
 		}
@@ -247,9 +351,9 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 		fluentdMounts := []string{fmt.Sprintf("type=bind,source=%s,destination=/fluentd/log/collected", logDir)}
 		fluentdName := fmt.Sprintf("%s-fluentd", cOpts.Prefix)
 		fluentdLabels := []string{fmt.Sprintf("%s=012", podman.LabelGeneration)}
-		_, err = ldgr.RunContainer(iopodman.Create{
+		fluentdConf := iopodman.Create{
 			Args: []string{
-				images.FluentdImage,
+				runOpts.spec.Images.Fluentd,
 				"exec", "fluentd",
 				"-i", "\"<system>\n log_level debug\n</system>\n<source>\n@type  forward\n@log_level error\nport  24224\n</source>\n<match **>\n@type file\npath /fluentd/log/collected\n</match>\"",
 				"-p", "/fluentd/plugins", "$FLUENTD_OPT", "-v",
@@ -258,8 +362,9 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 			Mount:      &fluentdMounts,
 			Name:       &fluentdName,
 			Privileged: &runOpts.privileged,
-			Network:    &dnsmasqNetwork,
-		})
+		}
+		clusterNetwork.Apply(&fluentdConf)
+		_, err = ldgr.RunContainer(fluentdConf)
 		if err != nil {
 			log.Errorf("FluentD run failed: %v", err)
 			return err
@@ -267,93 +372,89 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 		log.Noticef("FluentD container ready")
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(int(runOpts.nodes))
+	nodes := []*clusterNode{}
 	macCounter := 0
 
-	for x := 0; x < int(runOpts.nodes); x++ {
-		nodeQemuArgs := runOpts.qemuArgs
+	for x := 0; x < int(runOpts.spec.Nodes.Count); x++ {
+		nodeIndex := x + 1
+		if runOpts.spec.Nodes.Reverse {
+			nodeIndex = int(runOpts.spec.Nodes.Count) - x
+		}
+		nodeRes := runOpts.spec.Nodes.Resources(uint(nodeIndex))
+
+		nodeQemuArgs := nodeRes.QemuArgs
 
-		for i := 0; i < int(runOpts.secondaryNics); i++ {
+		for i := 0; i < int(nodeRes.SecondaryNics); i++ {
 			netSuffix := fmt.Sprintf("%d-%d", x, i)
 			macSuffix := fmt.Sprintf("%02x", macCounter)
 			macCounter++
-			nodeQemuArgs = fmt.Sprintf("%s -device virtio-net-pci,netdev=secondarynet%s,mac=52:55:00:d1:56:%s -netdev tap,id=secondarynet%s,ifname=stap%s,script=no,downscript=no", nodeQemuArgs, netSuffix, macSuffix, netSuffix, netSuffix)
+			nodeQemuArgs = fmt.Sprintf("%s -device virtio-net-pci,netdev=secondarynet%s,mac=%s:%s -netdev tap,id=secondarynet%s,ifname=stap%s,script=no,downscript=no", nodeQemuArgs, netSuffix, clusterEntry.MACPrefix(), macSuffix, netSuffix, netSuffix)
 		}
 
 		if len(nodeQemuArgs) > 0 {
 			nodeQemuArgs = "--qemu-args \"" + nodeQemuArgs + "\""
 		}
 
-		nodeName := nodeNameFromIndex(x + 1)
-		nodeNum := fmt.Sprintf("%02d", x+1)
-		nodeLabels := []string{fmt.Sprintf("%s=1%02d", podman.LabelGeneration, x)}
-		if runOpts.reverse {
-			nodeName = nodeNameFromIndex((int(runOpts.nodes) - x))
-			nodeNum = fmt.Sprintf("%02d", (int(runOpts.nodes) - x))
-		}
-
-		nodeMounts, err := mounts.NewVolumeMappings(ldgr, []mounts.MountInfo{
-			mounts.MountInfo{
-				Name: fmt.Sprintf("%s-%s", cOpts.Prefix, nodeName),
-				Path: "/var/run/disk",
-				Type: "volume",
+		nodeName := nodeNameFromIndex(nodeIndex)
+		log.Infof("node %s (%s): memory=%s cpu=%d secondary-nics=%d", nodeName, spec.NodeRole(uint(nodeIndex)), nodeRes.Memory, nodeRes.CPU, nodeRes.SecondaryNics)
+
+		nodes = append(nodes, &clusterNode{
+			name:       nodeName,
+			container:  nodeContainer(cOpts.Prefix, nodeName),
+			volume:     fmt.Sprintf("%s-%s", cOpts.Prefix, nodeName),
+			generation: fmt.Sprintf("1%02d", x),
+			command:    fmt.Sprintf("/vm.sh -n /var/run/disk/disk.qcow2 --memory %s --cpu %s %s", nodeRes.Memory, strconv.Itoa(int(nodeRes.CPU)), nodeQemuArgs),
+			env: []string{
+				fmt.Sprintf("NODE_NUM=%02d", nodeIndex),
 			},
 		})
-		if err != nil {
-			log.Errorf("Node volume mapping failed: %v", err)
-			return err
-		}
+	}
 
-		contNodeName := nodeContainer(cOpts.Prefix, nodeName)
-		contNodeMountsStrings := nodeMounts.ToStrings()
-		contNodeID, err := ldgr.RunContainer(iopodman.Create{
-			Args: []string{cluster, "/bin/bash", "-c", fmt.Sprintf("/vm.sh -n /var/run/disk/disk.qcow2 --memory %s --cpu %s %s", runOpts.memory, strconv.Itoa(int(runOpts.cpu)), nodeQemuArgs)},
-			Env: &[]string{
-				fmt.Sprintf("NODE_NUM=%s", nodeNum),
-			},
-			Label:      &nodeLabels,
-			Mount:      &contNodeMountsStrings,
-			Name:       &contNodeName,
-			Network:    &dnsmasqNetwork,
-			Privileged: &runOpts.privileged,
+	nodesByName := make(map[string]*clusterNode)
+	outLock := &sync.Mutex{}
+	bootTasks := []parallel.Task{}
+	for _, node := range nodes {
+		node := node
+		nodesByName[node.name] = node
+		bootTasks = append(bootTasks, func(ctx context.Context) error {
+			return bootNode(ctx, hnd, ldgr, log, node, cluster, clusterNetwork, runOpts.privileged, runOpts.readiness)
 		})
-		if err != nil {
-			log.Errorf("Node container run failed: %v", err)
-			return err
-		}
+	}
+	err = parallel.Run(ctx, bootTasks...)
+	if err != nil {
+		return err
+	}
 
-		log.Noticef("waiting on node %s for SSH availability", nodeName)
-		err = hnd.Exec(contNodeName, []string{"/bin/bash", "-c", "while [ ! -f /ssh_ready ] ; do sleep 1; done"}, os.Stdout)
-		if err != nil {
-			return fmt.Errorf("checking for ssh.sh script for node %s failed: %s", nodeName, err)
-		}
-		log.Noticef("node %s has SSH available!", nodeName)
-
-		log.Infof("checking for /scripts/%s.sh", nodeName)
-		//check if we have a special provision script
-		err = hnd.Exec(contNodeName, []string{"/bin/bash", "-c", fmt.Sprintf("test -f /scripts/%s.sh", nodeName)}, os.Stdout)
-		if err == nil {
-			log.Infof("using special provisioning script for %s", nodeName)
-			err = hnd.Exec(contNodeName, []string{"/bin/bash", "-c", fmt.Sprintf("ssh.sh sudo /bin/bash < /scripts/%s.sh", nodeName)}, os.Stdout)
-		} else {
-			log.Infof("using generic provisioning script for %s", nodeName)
-			err = hnd.Exec(contNodeName, []string{"/bin/bash", "-c", "ssh.sh sudo /bin/bash < /scripts/nodes.sh"}, os.Stdout)
+	for _, stage := range runOpts.spec.Nodes.ProvisionStages() {
+		log.Noticef("provisioning nodes: %s", strings.Join(stage, ", "))
+		provisionTasks := []parallel.Task{}
+		for _, nodeName := range stage {
+			node := nodesByName[nodeName]
+			provisionTasks = append(provisionTasks, func(ctx context.Context) error {
+				out := prefixwriter.New(os.Stdout, outLock, fmt.Sprintf("[%s] ", node.name))
+				defer out.Close()
+				return provisionNode(ctx, hnd, log, node, out)
+			})
 		}
+		err = parallel.Run(ctx, provisionTasks...)
 		if err != nil {
-			return fmt.Errorf("provisioning node %s failed: %s", nodeName, err)
+			return err
 		}
+	}
 
-		log.Noticef("waiting for %s", contNodeID)
-		go func(id string) {
-			hnd.WaitContainer(id, int64(1*time.Second))
+	wg := sync.WaitGroup{}
+	wg.Add(len(nodes))
+	for _, node := range nodes {
+		log.Noticef("waiting for %s", node.id)
+		go func(node *clusterNode) {
+			hnd.WaitContainer(node.id, int64(1*time.Second))
 			wg.Done()
-			log.Noticef("%s (%s) container ready", contNodeID, nodeName)
-		}(contNodeID)
+			log.Noticef("%s (%s) container ready", node.id, node.name)
+		}(node)
 	}
 	log.Noticef("Nodes ready")
 
-	if runOpts.enableCeph {
+	if runOpts.spec.Services.Ceph.Enabled {
 		keyRing := new(bytes.Buffer)
 		err := hnd.Exec(nodeContainer(cOpts.Prefix, "ceph"), []string{
 			"/bin/bash",
@@ -384,7 +485,7 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 	}
 
 	// If logging is enabled, deploy the default fluent logging
-	if runOpts.logDir != "" {
+	if runOpts.spec.Services.Fluentd.LogDir != "" {
 		nodeName := nodeNameFromIndex(1)
 		err := hnd.Exec(nodeContainer(cOpts.Prefix, nodeName), []string{
 			"/bin/bash",
@@ -400,15 +501,93 @@ func run(cmd *cobra.Command, runOpts *runOptions, args []string) (err error) {
 	// If background flag was specified, we don't want to clean up if we reach that state
 	if !runOpts.background {
 		wg.Wait()
-		ldgr.Done <- fmt.Errorf("Done. please clean up")
+		ldgr.Close(fmt.Errorf("Done. please clean up"))
 	}
 	return nil
 }
 
 func nodeNameFromIndex(x int) string {
-	return fmt.Sprintf("node%02d", x)
+	return spec.NodeName(uint(x))
 }
 
 func nodeContainer(prefix string, node string) string {
 	return prefix + "-" + node
 }
+
+type clusterNode struct {
+	name       string
+	container  string
+	volume     string
+	generation string
+	command    string
+	env        []string
+	id         string
+}
+
+// bootNode creates and starts the node container, and waits for the node to be reachable through SSH.
+func bootNode(ctx context.Context, hnd podman.Runtime, ldgr ledger.Ledger, log *logger.Logger, node *clusterNode, cluster string, network cmdutil.ClusterNetwork, privileged bool, readinessOpts readiness.Options) error {
+	nodeMounts, err := mounts.NewVolumeMappings(ldgr, []mounts.MountInfo{
+		mounts.MountInfo{
+			Name: node.volume,
+			Path: "/var/run/disk",
+			Type: "volume",
+		},
+	})
+	if err != nil {
+		log.Errorf("Node %s volume mapping failed: %v", node.name, err)
+		return err
+	}
+
+	nodeMountsStrings := nodeMounts.ToStrings()
+	nodeLabels := []string{fmt.Sprintf("%s=%s", podman.LabelGeneration, node.generation)}
+	nodeConf := iopodman.Create{
+		Args:       []string{cluster, "/bin/bash", "-c", node.command},
+		Env:        &node.env,
+		Label:      &nodeLabels,
+		Mount:      &nodeMountsStrings,
+		Name:       &node.container,
+		Privileged: &privileged,
+	}
+	network.Apply(&nodeConf)
+	node.id, err = ldgr.RunContainer(nodeConf)
+	if err != nil {
+		log.Errorf("Node %s container run failed: %v", node.name, err)
+		return err
+	}
+
+	// the probes of each node stop as soon as the others fail
+	nodeHnd := hnd.Clone(ctx)
+	defer nodeHnd.Close()
+
+	log.Noticef("waiting on node %s for SSH availability", node.name)
+	err = readiness.Wait(ctx, node.name, readiness.NewFileExists(nodeHnd, node.container, "/ssh_ready"), readinessOpts)
+	if err != nil {
+		return fmt.Errorf("checking for ssh.sh script for node %s failed: %s", node.name, err)
+	}
+	log.Noticef("node %s has SSH available!", node.name)
+	return nil
+}
+
+// provisionNode runs the provisioning script on the node, preferring the node-specific one if available.
+func provisionNode(ctx context.Context, hnd podman.Runtime, log *logger.Logger, node *clusterNode, out io.Writer) error {
+	nodeHnd := hnd.Clone(ctx)
+	defer nodeHnd.Close()
+
+	log.Infof("checking for /scripts/%s.sh", node.name)
+	//check if we have a special provision script
+	err := nodeHnd.Exec(node.container, []string{"/bin/bash", "-c", fmt.Sprintf("test -f /scripts/%s.sh", node.name)}, out)
+	if err == nil {
+		log.Infof("using special provisioning script for %s", node.name)
+		err = nodeHnd.Exec(node.container, []string{"/bin/bash", "-c", fmt.Sprintf("ssh.sh sudo /bin/bash < /scripts/%s.sh", node.name)}, out)
+	} else if _, ok := err.(*podman.ExitError); !ok {
+		return fmt.Errorf("checking the provisioning script of node %s failed: %v", node.name, err)
+	} else {
+		log.Infof("using generic provisioning script for %s", node.name)
+		err = nodeHnd.Exec(node.container, []string{"/bin/bash", "-c", "ssh.sh sudo /bin/bash < /scripts/nodes.sh"}, out)
+	}
+	if err != nil {
+		return fmt.Errorf("provisioning node %s failed: %s", node.name, err)
+	}
+	log.Noticef("node %s provisioned", node.name)
+	return nil
+}
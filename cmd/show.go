@@ -2,16 +2,57 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
 )
 
 type showOptions struct {
 	containerIdsOnly bool
 }
 
+type containerInfo struct {
+	Name    string            `json:"name" yaml:"name"`
+	ID      string            `json:"id" yaml:"id"`
+	Image   string            `json:"image" yaml:"image"`
+	Status  string            `json:"status" yaml:"status"`
+	Running bool              `json:"running" yaml:"running"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+	Ports   []string          `json:"ports" yaml:"ports"`
+	Mounts  []mountInfo       `json:"mounts" yaml:"mounts"`
+}
+
+type mountInfo struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Type        string `json:"type" yaml:"type"`
+	ReadOnly    bool   `json:"readOnly" yaml:"readOnly"`
+}
+
+type volumeInfo struct {
+	Name       string `json:"name" yaml:"name"`
+	MountPoint string `json:"mountPoint" yaml:"mountPoint"`
+}
+
+type podInfo struct {
+	Name   string   `json:"name" yaml:"name"`
+	ID     string   `json:"id" yaml:"id"`
+	Status string   `json:"status" yaml:"status"`
+	Ports  []string `json:"ports" yaml:"ports"`
+}
+
+type clusterInfo struct {
+	Prefix     string          `json:"prefix" yaml:"prefix"`
+	Pod        *podInfo        `json:"pod,omitempty" yaml:"pod,omitempty"`
+	Containers []containerInfo `json:"containers" yaml:"containers"`
+	Volumes    []volumeInfo    `json:"volumes" yaml:"volumes"`
+}
+
 func NewShowCommand() *cobra.Command {
 	flags := &showOptions{}
 
@@ -44,37 +85,80 @@ func showContainers(cmd *cobra.Command, showOpts *showOptions) error {
 		return err
 	}
 
-	hnd, _, err := cOpts.GetHandle()
+	hnd, _, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
 
-	containers, err := hnd.GetPrefixedContainers(cOpts.Prefix)
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
 	if err != nil {
 		return err
 	}
 
+	if showOpts.containerIdsOnly && cOpts.WantsStructuredOutput() {
+		ids := []string{}
+		for _, cont := range containers {
+			ids = append(ids, cont.Id)
+		}
+		return cOpts.PrintResult(cmd.OutOrStdout(), ids)
+	}
+
 	if showOpts.containerIdsOnly {
 		for _, cont := range containers {
 			fmt.Printf("%s\n", cont.Id)
 		}
 		return nil
-	} else {
-		if len(containers) >= 1 {
-			fmt.Printf("# Container:\n")
-			for _, cont := range containers {
-				fmt.Printf("%-32s\t%s\n", cont.Names, cont.Id)
-			}
-		} else {
-			fmt.Printf("no containers found for cluster %s\n", cOpts.Prefix)
-		}
 	}
 
-	volumes, err := hnd.GetPrefixedVolumes(cOpts.Prefix)
+	volumes, err := cmdutil.ClusterVolumes(hnd, cOpts.Prefix)
 	if err != nil {
 		return err
 	}
 
+	var pod *podInfo
+	podData, podFound, err := hnd.FindPod(cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	if podFound {
+		pPorts, err := hnd.GetPodPorts(podData)
+		if err != nil {
+			return err
+		}
+		pod = &podInfo{
+			Name:   podData.Name,
+			ID:     podData.Id,
+			Status: podData.Status,
+			Ports:  formatPorts(pPorts),
+		}
+	}
+
+	if cOpts.WantsStructuredOutput() {
+		mounts := make(map[string][]podman.ContainerMount)
+		for _, cont := range containers {
+			inspect, err := hnd.InspectContainer(cont.Id)
+			if err != nil {
+				return err
+			}
+			mounts[cont.Id] = inspect.Mounts
+		}
+		return cOpts.PrintResult(cmd.OutOrStdout(), makeClusterInfo(cOpts.Prefix, pod, containers, mounts, volumes))
+	}
+
+	if pod != nil {
+		fmt.Printf("# Pod:\n")
+		fmt.Printf("%-32s\t%s\t%s\t%s\n", pod.Name, pod.ID, pod.Status, strings.Join(pod.Ports, ","))
+	}
+
+	if len(containers) >= 1 {
+		fmt.Printf("# Container:\n")
+		for _, cont := range containers {
+			fmt.Printf("%-32s\t%s\n", cont.Names, cont.Id)
+		}
+	} else {
+		fmt.Printf("no containers found for cluster %s\n", cOpts.Prefix)
+	}
+
 	if len(volumes) >= 1 {
 		fmt.Printf("# Volumes:\n")
 		for _, vol := range volumes {
@@ -86,3 +170,48 @@ func showContainers(cmd *cobra.Command, showOpts *showOptions) error {
 
 	return nil
 }
+
+func makeClusterInfo(prefix string, pod *podInfo, containers []iopodman.Container, mounts map[string][]podman.ContainerMount, volumes []iopodman.Volume) clusterInfo {
+	info := clusterInfo{
+		Prefix:     prefix,
+		Pod:        pod,
+		Containers: []containerInfo{},
+		Volumes:    []volumeInfo{},
+	}
+	for _, cont := range containers {
+		ci := containerInfo{
+			Name:    cont.Names,
+			ID:      cont.Id,
+			Image:   cont.Image,
+			Status:  cont.Status,
+			Running: cont.Containerrunning,
+			Labels:  cont.Labels,
+			Ports:   formatPorts(cont.Ports),
+			Mounts:  []mountInfo{},
+		}
+		for _, mnt := range mounts[cont.Id] {
+			ci.Mounts = append(ci.Mounts, mountInfo{
+				Source:      mnt.Source,
+				Destination: mnt.Destination,
+				Type:        mnt.Type,
+				ReadOnly:    !mnt.RW,
+			})
+		}
+		info.Containers = append(info.Containers, ci)
+	}
+	for _, vol := range volumes {
+		info.Volumes = append(info.Volumes, volumeInfo{
+			Name:       vol.Name,
+			MountPoint: vol.MountPoint,
+		})
+	}
+	return info
+}
+
+func formatPorts(containerPorts []iopodman.ContainerPortMappings) []string {
+	res := []string{}
+	for _, p := range containerPorts {
+		res = append(res, fmt.Sprintf("%s:%s->%s/%s", p.Host_ip, p.Host_port, p.Container_port, p.Protocol))
+	}
+	return res
+}
@@ -0,0 +1,607 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
+	"github.com/fromanirh/pack8s/internal/pkg/ledger"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/snapshot"
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	// snapshotImageRepo is the repository of the images created from the snapshotted containers
+	snapshotImageRepo = "localhost/pack8s-snapshot"
+)
+
+type snapshotOptions struct {
+	dir         string
+	randomPorts bool
+}
+
+// NewSnapshotCommand returns command to save and restore cluster snapshots
+func NewSnapshotCommand() *cobra.Command {
+	flags := &snapshotOptions{}
+
+	snap := &cobra.Command{
+		Use:   "snapshot",
+		Short: "snapshot saves and restores the state of a cluster",
+		Long: `snapshot saves and restores the state of a cluster
+
+A snapshot is a local archive holding the filesystems of all the cluster containers,
+the cluster volumes, like the node disks, and a manifest describing how to recreate them.
+Restoring a snapshot is much faster than provisioning a new cluster.
+
+The podman socket must belong to the local host, because the container filesystems and
+the volumes are read and written directly.
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprint(cmd.OutOrStderr(), cmd.UsageString())
+		},
+	}
+
+	snap.PersistentFlags().StringVar(&flags.dir, "dir", "", "directory holding the snapshots (default $PACK8S_SNAPSHOTS, or the pack8s user cache directory)")
+
+	save := &cobra.Command{
+		Use:   "save NAME",
+		Short: "save stores the state of the cluster in the snapshot NAME",
+		Long: `save stores the state of the cluster in the snapshot NAME
+
+The running containers are checkpointed, or stopped if checkpointing fails, while their state
+is saved, then they are resumed. An existing snapshot with the same name is replaced.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return saveSnapshot(cmd, args[0], flags)
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	restore := &cobra.Command{
+		Use:   "restore NAME",
+		Short: "restore recreates a cluster from the snapshot NAME",
+		Long: `restore recreates a cluster from the snapshot NAME
+
+The cluster is recreated with the current prefix, which may differ from the one of the
+snapshotted cluster. The host ports are the same of the snapshotted cluster, unless
+--random-ports is given.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return restoreSnapshot(cmd, args[0], flags)
+		},
+		Args: cobra.ExactArgs(1),
+	}
+
+	restore.Flags().BoolVar(&flags.randomPorts, "random-ports", false, "publish the cluster ports on random host ports")
+
+	snap.AddCommand(save, restore)
+	return snap
+}
+
+func (snapOpts *snapshotOptions) archivePath(name string) (string, error) {
+	dir := snapOpts.dir
+	if dir == "" {
+		var err error
+		dir, err = snapshot.DefaultDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return snapshot.Path(dir, name)
+}
+
+func saveSnapshot(cmd *cobra.Command, name string, snapOpts *snapshotOptions) (err error) {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	archivePath, err := snapOpts.archivePath(name)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetHandle()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found for cluster %s", cOpts.Prefix)
+	}
+	if _, podFound, err := hnd.FindPod(cOpts.Prefix); err != nil {
+		return err
+	} else if podFound {
+		return fmt.Errorf("cluster %s runs in a pod: snapshots of pod based clusters are not supported", cOpts.Prefix)
+	}
+	// oldest generation first, the same order the containers were created
+	sort.Sort(sort.Reverse(containerList(containers)))
+
+	volumes, err := cmdutil.ClusterVolumes(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := makeSnapshotManifest(hnd, name, cOpts.Prefix, cOpts.PodmanSocket, containers, volumes)
+	if err != nil {
+		return err
+	}
+
+	resume, err := suspendContainers(hnd, log, containers)
+	defer func() {
+		if resumeErr := resume(); resumeErr != nil && err == nil {
+			err = resumeErr
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "pack8s-snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0700); err != nil {
+		return err
+	}
+	// write and rename, so a failed save doesn't clobber an existing snapshot
+	tmpArchivePath := archivePath + ".tmp"
+	out, err := os.OpenFile(tmpArchivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(tmpArchivePath)
+		}
+	}()
+
+	sw, err := snapshot.NewWriter(out, manifest)
+	if err != nil {
+		return err
+	}
+
+	for idx, cont := range containers {
+		entry := manifest.Containers[idx]
+		contPath := filepath.Join(tmpDir, entry.Name+".tar")
+		log.Noticef("snapshot: exporting container %s", cont.Names)
+		if _, err := hnd.ExportContainer(cont.Id, contPath); err != nil {
+			return err
+		}
+		if err := sw.AddContainer(entry, contPath); err != nil {
+			return err
+		}
+		// the exported filesystems may be big, don't keep them around
+		if err := os.Remove(contPath); err != nil {
+			return err
+		}
+	}
+
+	for idx, vol := range volumes {
+		log.Noticef("snapshot: saving volume %s from %s", vol.Name, vol.MountPoint)
+		if err := sw.AddVolume(manifest.Volumes[idx], vol.MountPoint); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpArchivePath, archivePath); err != nil {
+		return err
+	}
+
+	log.Infof("snapshot %s saved in %s (containers=%d volumes=%d)", name, archivePath, len(manifest.Containers), len(manifest.Volumes))
+	return nil
+}
+
+func makeSnapshotManifest(hnd *podman.Handle, name, prefix, socket string, containers []iopodman.Container, volumes []iopodman.Volume) (snapshot.Manifest, error) {
+	containerPrefix := prefix + "-"
+	manifest := snapshot.Manifest{
+		Version:  snapshot.ManifestVersion,
+		Name:     name,
+		Prefix:   prefix,
+		MACRange: clusters.FirstMACRange,
+		Created:  time.Now(),
+	}
+
+	regPath, err := clusters.DefaultPath()
+	if err != nil {
+		return manifest, err
+	}
+	reg, err := clusters.Load(regPath)
+	if err != nil {
+		return manifest, err
+	}
+	if c, ok := reg.Find(prefix, socket); ok {
+		manifest.Provider = c.Provider
+		manifest.MACRange = c.MACRange
+		manifest.HostPorts = c.HostPorts
+	}
+
+	for _, vol := range volumes {
+		manifest.Volumes = append(manifest.Volumes, snapshot.Volume{
+			Name: strings.TrimPrefix(vol.Name, containerPrefix),
+		})
+	}
+
+	namesByID := make(map[string]string)
+	for _, cont := range containers {
+		namesByID[cont.Id] = strings.TrimPrefix(cont.Names, containerPrefix)
+	}
+
+	for _, cont := range containers {
+		inspect, err := hnd.InspectContainer(cont.Id)
+		if err != nil {
+			return manifest, err
+		}
+
+		entry := snapshot.Container{
+			Name:       namesByID[cont.Id],
+			Generation: cont.Labels[podman.LabelGeneration],
+			Image:      inspect.ImageName,
+			Entrypoint: inspect.Config.Entrypoint,
+			Command:    inspect.Config.Cmd,
+			Env:        inspect.Config.Env,
+			WorkDir:    inspect.Config.WorkingDir,
+			Labels:     inspect.Config.Labels,
+			Privileged: inspect.HostConfig.Privileged,
+			AddHost:    inspect.HostConfig.ExtraHosts,
+		}
+
+		if strings.HasPrefix(inspect.HostConfig.NetworkMode, "container:") {
+			netID := strings.TrimPrefix(inspect.HostConfig.NetworkMode, "container:")
+			netName, ok := namesByID[netID]
+			if !ok {
+				return manifest, fmt.Errorf("container %s joins the network of %s, which doesn't belong to cluster %s", cont.Names, netID, prefix)
+			}
+			entry.Network = netName
+		}
+
+		for _, port := range cont.Ports {
+			entry.Publish = append(entry.Publish, fmt.Sprintf("%s:%s", port.Host_port, port.Container_port))
+		}
+
+		for _, mnt := range inspect.Mounts {
+			switch mnt.Type {
+			case "volume":
+				entry.Mounts = append(entry.Mounts, snapshot.Mount{
+					Type:        mnt.Type,
+					Source:      strings.TrimPrefix(mnt.Name, containerPrefix),
+					Destination: mnt.Destination,
+				})
+			case "bind":
+				entry.Mounts = append(entry.Mounts, snapshot.Mount{
+					Type:        mnt.Type,
+					Source:      mnt.Source,
+					Destination: mnt.Destination,
+				})
+			}
+		}
+
+		manifest.Containers = append(manifest.Containers, entry)
+	}
+	return manifest, nil
+}
+
+// suspendContainers checkpoints the running containers, newest generation first, falling back
+// to stop them if the checkpoint fails. The returned function resumes them, oldest generation first.
+func suspendContainers(hnd *podman.Handle, log *logger.Logger, containers []iopodman.Container) (func() error, error) {
+	checkpointed := make(map[string]bool)
+	suspended := []iopodman.Container{}
+
+	resume := func() error {
+		var resumeErr error
+		for idx := len(suspended) - 1; idx >= 0; idx-- {
+			cont := suspended[idx]
+			var err error
+			if checkpointed[cont.Id] {
+				log.Noticef("snapshot: restoring container %s", cont.Names)
+				_, err = hnd.RestoreContainer(cont.Id)
+			} else {
+				log.Noticef("snapshot: starting container %s", cont.Names)
+				_, err = hnd.StartContainer(cont.Id)
+			}
+			if err != nil {
+				log.Errorf("cannot resume container %s: %v", cont.Names, err)
+				resumeErr = err
+			}
+		}
+		return resumeErr
+	}
+
+	for idx := len(containers) - 1; idx >= 0; idx-- {
+		cont := containers[idx]
+		if !cont.Containerrunning {
+			continue
+		}
+
+		log.Noticef("snapshot: checkpointing container %s", cont.Names)
+		if _, err := hnd.CheckpointContainer(cont.Id); err == nil {
+			checkpointed[cont.Id] = true
+		} else {
+			log.Warningf("cannot checkpoint container %s, stopping it: %v", cont.Names, err)
+			if _, err := hnd.StopContainer(cont.Id, ledger.StopTimeout); err != nil {
+				return resume, err
+			}
+		}
+		suspended = append(suspended, cont)
+	}
+	return resume, nil
+}
+
+func restoreSnapshot(cmd *cobra.Command, name string, snapOpts *snapshotOptions) (err error) {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	archivePath, err := snapOpts.archivePath(name)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	sr, err := snapshot.NewReader(src)
+	if err != nil {
+		return err
+	}
+	manifest := sr.Manifest
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := cOpts.RequireVarlink(); err != nil {
+		return err
+	}
+	log := cOpts.GetLogger()
+	hnd, err := podman.NewHandle(ctx, cOpts.PodmanSocket, log)
+	if err != nil {
+		return err
+	}
+
+	hostPorts := manifest.HostPorts
+	if snapOpts.randomPorts {
+		hostPorts = []uint{}
+	}
+	clusterEntry, err := cmdutil.RegisterCluster(hnd, cOpts, manifest.Provider, hostPorts)
+	if err != nil {
+		return err
+	}
+	log.Infof("cluster %s registered, MAC range %s:xx", cOpts.Prefix, clusterEntry.MACPrefix())
+	defer func() {
+		if err != nil {
+			if unregErr := cmdutil.UnregisterCluster(cOpts); unregErr != nil {
+				log.Warningf("cannot unregister cluster %s: %v", cOpts.Prefix, unregErr)
+			}
+		}
+	}()
+
+	ldgr := ledger.NewLedger(hnd, cmd.OutOrStderr(), log)
+	defer func() {
+		ldgr.Close(err)
+	}()
+
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			cancel()
+			ldgr.Close(fmt.Errorf("Interrupt received, clean up"))
+		case <-ldgr.Finished():
+		}
+	}()
+
+	containerPrefix := cOpts.Prefix + "-"
+	for _, vol := range manifest.Volumes {
+		if _, err := ldgr.MakeVolume(containerPrefix + vol.Name); err != nil {
+			return err
+		}
+	}
+	volumes, err := cmdutil.ClusterVolumes(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	mountPoints := make(map[string]string)
+	for _, vol := range volumes {
+		mountPoints[strings.TrimPrefix(vol.Name, containerPrefix)] = vol.MountPoint
+	}
+
+	// the images of a snapshot are imported once, and shared by all the clusters restored from it.
+	// rm removes them along with the last of these clusters.
+	existingImages, err := getImageTags(hnd)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "pack8s-snapshot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	log.Noticef("snapshot: extracting %s", archivePath)
+	err = sr.Extract(func(contName string) (string, error) {
+		if existingImages[snapshotImage(manifest, contName)] {
+			return "", nil
+		}
+		return filepath.Join(tmpDir, contName+".tar"), nil
+	}, func(volName string) (string, error) {
+		mountPoint, ok := mountPoints[volName]
+		if !ok {
+			return "", fmt.Errorf("cannot find the mount point of volume %s", containerPrefix+volName)
+		}
+		return mountPoint, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	oldMACPrefix := clusters.Cluster{MACRange: manifest.MACRange}.MACPrefix() + ":"
+	newMACPrefix := clusterEntry.MACPrefix() + ":"
+	idsByName := make(map[string]string)
+	for _, entry := range manifest.Containers {
+		image := snapshotImage(manifest, entry.Name)
+		if !existingImages[image] {
+			log.Noticef("snapshot: importing %s", image)
+			message := fmt.Sprintf("pack8s snapshot %s of %s-%s", manifest.Name, manifest.Prefix, entry.Name)
+			if _, err := hnd.ImportImage(filepath.Join(tmpDir, entry.Name+".tar"), image, message); err != nil {
+				return err
+			}
+		}
+
+		conf, err := makeSnapshotCreate(entry, image, containerPrefix, idsByName, manifest.Volumes, snapOpts.randomPorts)
+		if err != nil {
+			return err
+		}
+		// the MAC addresses of the secondary NICs are set in the node command lines
+		replaceMACPrefix(conf.Args, oldMACPrefix, newMACPrefix)
+		replaceMACPrefix(*conf.Env, oldMACPrefix, newMACPrefix)
+
+		log.Noticef("snapshot: starting container %s", *conf.Name)
+		id, err := ldgr.RunContainer(conf)
+		if err != nil {
+			return err
+		}
+		idsByName[entry.Name] = id
+	}
+
+	log.Infof("cluster %s restored from snapshot %s (containers=%d volumes=%d)", cOpts.Prefix, name, len(manifest.Containers), len(manifest.Volumes))
+	return nil
+}
+
+func makeSnapshotCreate(entry snapshot.Container, image, containerPrefix string, idsByName map[string]string, volumes []snapshot.Volume, randomPorts bool) (iopodman.Create, error) {
+	name := containerPrefix + entry.Name
+	env := entry.Env
+	privileged := entry.Privileged
+
+	labels := []string{}
+	for key, value := range entry.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(labels)
+
+	snapshotVolumes := make(map[string]bool)
+	for _, vol := range volumes {
+		snapshotVolumes[vol.Name] = true
+	}
+	mountStrings := []string{}
+	for _, mnt := range entry.Mounts {
+		source := mnt.Source
+		if mnt.Type == "volume" && snapshotVolumes[source] {
+			source = containerPrefix + source
+		}
+		mountStrings = append(mountStrings, fmt.Sprintf("type=%s,source=%s,destination=%s", mnt.Type, source, mnt.Destination))
+	}
+
+	publish := []string{}
+	for _, port := range entry.Publish {
+		if randomPorts {
+			// keep only the container port
+			items := strings.Split(port, ":")
+			port = items[len(items)-1]
+		}
+		publish = append(publish, port)
+	}
+
+	conf := iopodman.Create{
+		Args:       append([]string{image}, entry.Command...),
+		AddHost:    &entry.AddHost,
+		Env:        &env,
+		Label:      &labels,
+		Mount:      &mountStrings,
+		Name:       &name,
+		Privileged: &privileged,
+		Publish:    &publish,
+	}
+	if entry.Entrypoint != "" {
+		conf.Entrypoint = &entry.Entrypoint
+	}
+	if entry.WorkDir != "" {
+		conf.WorkDir = &entry.WorkDir
+	}
+	if entry.Network != "" {
+		netID, ok := idsByName[entry.Network]
+		if !ok {
+			return conf, fmt.Errorf("container %s joins the network of %s, which is not restored yet", entry.Name, entry.Network)
+		}
+		network := fmt.Sprintf("container:%s", netID)
+		conf.Network = &network
+	}
+	return conf, nil
+}
+
+func snapshotImage(manifest snapshot.Manifest, contName string) string {
+	// the creation time tells apart the snapshots saved with the same name
+	return strings.ToLower(fmt.Sprintf("%s/%s-%s:%d", snapshotImageRepo, manifest.Name, contName, manifest.Created.Unix()))
+}
+
+// getSnapshotImages returns the snapshot images used by the containers with the given prefix
+func getSnapshotImages(hnd podman.Runtime, prefix string) ([]string, error) {
+	containers, err := cmdutil.ClusterContainers(hnd, prefix)
+	if err != nil {
+		return nil, err
+	}
+	images := []string{}
+	seen := make(map[string]bool)
+	for _, cont := range containers {
+		if strings.HasPrefix(cont.Image, snapshotImageRepo+"/") && !seen[cont.Image] {
+			images = append(images, cont.Image)
+			seen[cont.Image] = true
+		}
+	}
+	return images, nil
+}
+
+func getImageTags(hnd *podman.Handle) (map[string]bool, error) {
+	images, err := hnd.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool)
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			tags[tag] = true
+		}
+	}
+	return tags, nil
+}
+
+func replaceMACPrefix(items []string, oldPrefix, newPrefix string) {
+	if oldPrefix == newPrefix {
+		return
+	}
+	for idx := range items {
+		items[idx] = strings.Replace(items[idx], oldPrefix, newPrefix, -1)
+	}
+}
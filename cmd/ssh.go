@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
 )
 
 // NewSSHCommand returns command to SSH to the cluster node
@@ -14,8 +19,19 @@ func NewSSHCommand() *cobra.Command {
 	ssh := &cobra.Command{
 		Use:   "ssh",
 		Short: "ssh into a node",
-		RunE:  ssh,
-		Args:  cobra.MinimumNArgs(1),
+		Long: `ssh into a node
+
+If both the standard input and output are terminals, the session is interactive:
+the local terminal is put in raw mode and its size changes are forwarded to the node.
+Otherwise (e.g. with pipes) the command runs without a pseudo terminal, and its
+standard error is kept separate from its standard output.
+
+pack8s exits with the exit code of the remote command, e.g.
+
+  pack8s ssh node01 -- test -f /etc/kubernetes/admin.conf
+`,
+		RunE: ssh,
+		Args: cobra.MinimumNArgs(1),
 	}
 	return ssh
 }
@@ -28,7 +44,7 @@ func ssh(cmd *cobra.Command, args []string) error {
 
 	node := args[0]
 
-	hnd, _, err := cOpts.GetHandle()
+	hnd, _, err := cOpts.GetRuntime()
 	if err != nil {
 		return err
 	}
@@ -36,7 +52,87 @@ func ssh(cmd *cobra.Command, args []string) error {
 	container := cOpts.Prefix + "-" + node
 	sshCommand := append([]string{"ssh.sh"}, args[1:]...)
 
-	err = hnd.Exec(container, sshCommand, os.Stdout)
+	return execAttached(hnd, container, sshCommand, podman.ExecOptions{})
+}
 
+// execAttached runs the command in the container, attached to the standard streams.
+// If the standard input and output are terminals, the command gets a pseudo terminal,
+// the local terminal is put in raw mode and its size changes are forwarded.
+// If the command fails, pack8s exits with the same code.
+func execAttached(hnd podman.Runtime, container string, command []string, opts podman.ExecOptions) error {
+	err := execWithTerminal(hnd, container, command, opts)
+	if exitErr, ok := err.(*podman.ExitError); ok {
+		return &cmdutil.ExitError{Code: exitErr.Code}
+	}
 	return err
 }
+
+func execWithTerminal(hnd podman.Runtime, container string, command []string, opts podman.ExecOptions) error {
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+	opts.Stdout = os.Stdout
+	opts.Stderr = os.Stderr
+
+	if !terminal.IsTerminal(stdinFd) || !terminal.IsTerminal(stdoutFd) {
+		// don't steal the input from the terminal if only the output is redirected
+		if !terminal.IsTerminal(stdinFd) {
+			opts.Stdin = os.Stdin
+		}
+		return hnd.ExecWithOptions(container, command, opts)
+	}
+
+	oldState, err := terminal.MakeRaw(stdinFd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(stdinFd, oldState)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	resize := make(chan podman.TerminalSize, 1)
+	go func() {
+		// the remote terminal starts with the local size
+		sendTerminalSize(stdoutFd, resize)
+		for {
+			select {
+			case <-done:
+				return
+			case <-winch:
+				sendTerminalSize(stdoutFd, resize)
+			}
+		}
+	}()
+
+	opts.Stdin = os.Stdin
+	opts.Tty = true
+	opts.Resize = resize
+	return hnd.ExecWithOptions(container, command, opts)
+}
+
+func sendTerminalSize(fd int, resize chan podman.TerminalSize) {
+	width, height, err := terminal.GetSize(fd)
+	if err != nil {
+		return
+	}
+	size := podman.TerminalSize{
+		Width:  uint16(width),
+		Height: uint16(height),
+	}
+	// only the latest size matters
+	for {
+		select {
+		case resize <- size:
+			return
+		default:
+			select {
+			case <-resize:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/parallel"
+	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	"github.com/fromanirh/pack8s/internal/pkg/readiness"
+)
+
+type startOptions struct {
+	readiness    readiness.Options
+	probeTimeout time.Duration
+}
+
+// NewStartCommand returns command to start again a stopped cluster
+func NewStartCommand() *cobra.Command {
+	flags := &startOptions{
+		readiness: readiness.DefaultOptions(),
+	}
+	start := &cobra.Command{
+		Use:   "start",
+		Short: "start brings back a cluster stopped with stop",
+		Long: `start brings back a cluster stopped with stop
+
+The containers are started oldest first, like run creates them. Once all the nodes answer SSH
+and the Kubernetes API server, if published, is ready, the status of the cluster is reported.
+
+The exit code is 2 if the cluster is degraded, 1 on error.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return startCluster(cmd, flags)
+		},
+		Args: cobra.NoArgs,
+	}
+
+	start.Flags().DurationVar(&flags.readiness.Timeout, "boot-timeout", flags.readiness.Timeout, "maximum time to wait for each node to become reachable through SSH (0 waits forever)")
+	start.Flags().DurationVar(&flags.readiness.Interval, "probe-interval", flags.readiness.Interval, "initial interval between readiness checks")
+	start.Flags().DurationVar(&flags.readiness.MaxInterval, "probe-max-interval", flags.readiness.MaxInterval, "maximum interval between readiness checks")
+	start.Flags().Float64Var(&flags.readiness.Backoff, "probe-backoff", flags.readiness.Backoff, "factor to increase the interval between readiness checks")
+	start.Flags().DurationVar(&flags.probeTimeout, "probe-timeout", 10*time.Second, "maximum time to wait for each health check of the final status report")
+
+	return start
+}
+
+func startCluster(cmd *cobra.Command, startOpts *startOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("no containers found for cluster %s", cOpts.Prefix)
+	}
+
+	// oldest generation first: the nodes join the network of dnsmasq
+	sort.Stable(sort.Reverse(containerList(containers)))
+
+	log.Infof("starting cluster (containers=%d)", len(containers))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waitTasks := []parallel.Task{}
+	apiAddress := ""
+	for _, cont := range containers {
+		cont := cont
+		if cont.Containerrunning {
+			log.Infof("container already running: %s", cont.Names)
+		} else {
+			log.Noticef("starting container: %s", cont.Names)
+			_, err = hnd.StartContainer(cont.Id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if nodeContainerRe.MatchString(cont.Names) {
+			waitTasks = append(waitTasks, func(ctx context.Context) error {
+				// the probes of each node stop as soon as the others fail
+				nodeHnd := hnd.Clone(ctx)
+				defer nodeHnd.Close()
+
+				log.Noticef("waiting on %s for SSH availability", cont.Names)
+				// the node VM boots again from its disk, so the /ssh_ready marker of run is stale
+				err := readiness.Wait(ctx, cont.Names, readiness.NewExec(nodeHnd, cont.Names, "ssh.sh", "/bin/true"), startOpts.readiness)
+				if err != nil {
+					return err
+				}
+				log.Noticef("%s has SSH available!", cont.Names)
+				return nil
+			})
+		}
+
+		if apiPort, err := ports.GetPublicPort(ports.PortAPI, cont.Ports); err == nil && apiAddress == "" {
+			apiAddress = net.JoinHostPort(cOpts.HostAddress(), strconv.Itoa(apiPort))
+		}
+	}
+
+	if apiAddress == "" {
+		// the containers of pod based clusters don't publish ports by themselves
+		pPorts, err := podPorts(hnd, cOpts.Prefix)
+		if err != nil {
+			return err
+		}
+		if apiPort, err := ports.GetPublicPort(ports.PortAPI, pPorts); err == nil {
+			apiAddress = net.JoinHostPort(cOpts.HostAddress(), strconv.Itoa(apiPort))
+		}
+	}
+
+	err = parallel.Run(ctx, waitTasks...)
+	if err != nil {
+		return err
+	}
+
+	if apiAddress != "" {
+		log.Noticef("waiting for the Kubernetes API server at %s", apiAddress)
+		err = readiness.Wait(ctx, apiAddress, readiness.NewKubeAPIReady(apiAddress), startOpts.readiness)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("cluster %s started", cOpts.Prefix)
+
+	st, err := getClusterStatus(hnd, log, cOpts.Prefix, cOpts.HostAddress(), startOpts.probeTimeout)
+	if err != nil {
+		return err
+	}
+	return reportClusterStatus(cmd, cOpts, st)
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/ports"
+	"github.com/fromanirh/pack8s/internal/pkg/readiness"
+)
+
+const (
+	// statusDegradedExitCode is the exit code of the status command when the cluster is not healthy
+	statusDegradedExitCode = 2
+)
+
+var nodeContainerRe = regexp.MustCompile(`-node[0-9]+$`)
+
+type statusOptions struct {
+	probeTimeout time.Duration
+}
+
+type containerStatus struct {
+	Name       string   `json:"name" yaml:"name"`
+	ID         string   `json:"id" yaml:"id"`
+	Generation string   `json:"generation" yaml:"generation"`
+	State      string   `json:"state" yaml:"state"`
+	Running    bool     `json:"running" yaml:"running"`
+	ExitCode   int32    `json:"exitCode" yaml:"exitCode"`
+	Uptime     string   `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+	Ports      []string `json:"ports" yaml:"ports"`
+	SSH        string   `json:"ssh,omitempty" yaml:"ssh,omitempty"`
+	healthy    bool
+}
+
+type apiStatus struct {
+	Address string `json:"address" yaml:"address"`
+	Ready   bool   `json:"ready" yaml:"ready"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type clusterStatus struct {
+	Prefix     string            `json:"prefix" yaml:"prefix"`
+	Healthy    bool              `json:"healthy" yaml:"healthy"`
+	Containers []containerStatus `json:"containers" yaml:"containers"`
+	API        *apiStatus        `json:"api,omitempty" yaml:"api,omitempty"`
+}
+
+// NewStatusCommand returns command to report the health of the cluster
+func NewStatusCommand() *cobra.Command {
+	flags := &statusOptions{}
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "status reports the health of the cluster",
+		Long: `status reports the health of the cluster
+
+For each container of the cluster, status reports its state, uptime and published ports.
+For each node, status checks if the node VM answers SSH.
+If the Kubernetes API port is published, status checks if the API server is ready.
+
+The exit code is 2 if the cluster is degraded, 1 on error.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showStatus(cmd, flags)
+		},
+		Args: cobra.NoArgs,
+	}
+
+	status.Flags().DurationVar(&flags.probeTimeout, "probe-timeout", 10*time.Second, "maximum time to wait for each health check")
+
+	return status
+}
+
+func showStatus(cmd *cobra.Command, statusOpts *statusOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	st, err := getClusterStatus(hnd, log, cOpts.Prefix, cOpts.HostAddress(), statusOpts.probeTimeout)
+	if err != nil {
+		return err
+	}
+	return reportClusterStatus(cmd, cOpts, st)
+}
+
+// getClusterStatus inspects all the containers of the cluster, and checks the health of the nodes and of the API server,
+// whose port is published on hostAddress.
+func getClusterStatus(hnd podman.Runtime, log *logger.Logger, prefix, hostAddress string, probeTimeout time.Duration) (clusterStatus, error) {
+	containers, err := cmdutil.ClusterContainers(hnd, prefix)
+	if err != nil {
+		return clusterStatus{}, err
+	}
+
+	// oldest generation first, like they were created
+	sort.Stable(sort.Reverse(containerList(containers)))
+
+	st := clusterStatus{
+		Prefix:  prefix,
+		Healthy: len(containers) > 0,
+	}
+
+	for _, cont := range containers {
+		cs := containerStatus{
+			Name:       cont.Names,
+			ID:         cont.Id,
+			Generation: cont.Labels[podman.LabelGeneration],
+			Ports:      formatPorts(cont.Ports),
+		}
+
+		state, err := hnd.GetContainerState(cont.Id)
+		if err != nil {
+			log.Warningf("cannot inspect container %s: %v", cont.Names, err)
+			cs.State = "unknown"
+		} else {
+			cs.State = state.Status
+			cs.Running = state.Running
+			cs.ExitCode = state.ExitCode
+			if state.Running {
+				cs.Uptime = time.Since(state.StartedAt).Round(time.Second).String()
+			}
+		}
+		cs.healthy = cs.Running
+
+		if cs.Running && nodeContainerRe.MatchString(cont.Names) {
+			err := probeOnce(hnd, probeTimeout, func(nodeHnd podman.Runtime) readiness.Probe {
+				return readiness.NewExec(nodeHnd, cont.Names, "ssh.sh", "/bin/true")
+			})
+			if err != nil {
+				cs.SSH = fmt.Sprintf("failed: %v", err)
+				cs.healthy = false
+			} else {
+				cs.SSH = "ok"
+			}
+		}
+
+		if !cs.healthy {
+			st.Healthy = false
+		}
+		st.Containers = append(st.Containers, cs)
+
+		if apiPort, err := ports.GetPublicPort(ports.PortAPI, cont.Ports); err == nil && st.API == nil {
+			st.API = &apiStatus{
+				Address: net.JoinHostPort(hostAddress, strconv.Itoa(apiPort)),
+			}
+		}
+	}
+
+	if st.API == nil {
+		// the containers of pod based clusters don't publish ports by themselves
+		pPorts, err := podPorts(hnd, prefix)
+		if err != nil {
+			log.Warningf("cannot get the ports of pod %s: %v", prefix, err)
+		} else if apiPort, err := ports.GetPublicPort(ports.PortAPI, pPorts); err == nil {
+			st.API = &apiStatus{
+				Address: net.JoinHostPort(hostAddress, strconv.Itoa(apiPort)),
+			}
+		}
+	}
+
+	if st.API != nil {
+		err := probeOnce(hnd, probeTimeout, func(_ podman.Runtime) readiness.Probe {
+			return readiness.NewKubeAPIReady(st.API.Address)
+		})
+		if err != nil {
+			st.API.Error = err.Error()
+			st.Healthy = false
+		} else {
+			st.API.Ready = true
+		}
+	}
+
+	return st, nil
+}
+
+// reportClusterStatus prints the cluster status, and turns a degraded cluster into an ExitError.
+func reportClusterStatus(cmd *cobra.Command, cOpts cmdutil.CommonOpts, st clusterStatus) error {
+	var err error
+	if cOpts.WantsStructuredOutput() {
+		err = cOpts.PrintResult(cmd.OutOrStdout(), st)
+	} else {
+		err = writeStatusText(cmd.OutOrStdout(), st)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !st.Healthy {
+		return &cmdutil.ExitError{Code: statusDegradedExitCode}
+	}
+	return nil
+}
+
+// probeOnce runs a single check, using a dedicated handle bound to the timeout.
+func probeOnce(hnd podman.Runtime, timeout time.Duration, makeProbe func(podman.Runtime) readiness.Probe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	probeHnd := hnd.Clone(ctx)
+	defer probeHnd.Close()
+	return makeProbe(probeHnd).Probe(ctx)
+}
+
+func writeStatusText(w io.Writer, st clusterStatus) error {
+	if len(st.Containers) == 0 {
+		fmt.Fprintf(w, "no containers found for cluster %s\n", st.Prefix)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tSTATE\tGENERATION\tUPTIME\tSSH\tPORTS\n")
+	for _, cs := range st.Containers {
+		state := cs.State
+		if !cs.Running && cs.State != "unknown" {
+			state = fmt.Sprintf("%s (%d)", cs.State, cs.ExitCode)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", cs.Name, state, orDash(cs.Generation), orDash(cs.Uptime), orDash(cs.SSH), orDash(strings.Join(cs.Ports, ",")))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if st.API != nil {
+		if st.API.Ready {
+			fmt.Fprintf(w, "\nKubernetes API at %s: ready\n", st.API.Address)
+		} else {
+			fmt.Fprintf(w, "\nKubernetes API at %s: not ready (%s)\n", st.API.Address, st.API.Error)
+		}
+	}
+
+	if st.Healthy {
+		fmt.Fprintf(w, "\ncluster %s is healthy\n", st.Prefix)
+	} else {
+		fmt.Fprintf(w, "\ncluster %s is degraded\n", st.Prefix)
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
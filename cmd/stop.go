@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+)
+
+type stopOptions struct {
+	timeout int64
+}
+
+// NewStopCommand returns command to stop the cluster, keeping its containers and volumes
+func NewStopCommand() *cobra.Command {
+	flags := &stopOptions{}
+	stop := &cobra.Command{
+		Use:   "stop",
+		Short: "stop stops all the containers of a cluster, without removing them",
+		Long: `stop stops all the containers of a cluster, without removing them
+
+The containers are stopped newest first, and both the containers and the volumes are kept,
+so the cluster can be brought back later with start, without provisioning it again.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return stopCluster(cmd, flags)
+		},
+		Args: cobra.NoArgs,
+	}
+
+	stop.Flags().Int64VarP(&flags.timeout, "timeout", "t", 30, "seconds to wait for each container to stop before killing it")
+
+	return stop
+}
+
+func stopCluster(cmd *cobra.Command, stopOpts *stopOptions) error {
+	cOpts, err := cmdutil.GetCommonOpts(cmd)
+	if err != nil {
+		return err
+	}
+
+	hnd, log, err := cOpts.GetRuntime()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cmdutil.ClusterContainers(hnd, cOpts.Prefix)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(containerList(containers))
+
+	log.Infof("stopping cluster (containers=%d)", len(containers))
+
+	for _, cont := range containers {
+		if !cont.Containerrunning {
+			log.Infof("container already stopped: %s", cont.Names)
+			continue
+		}
+
+		log.Noticef("stopping container: %s", cont.Names)
+		_, err = hnd.StopContainer(cont.Id, stopOpts.timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("cluster %s stopped", cOpts.Prefix)
+	return nil
+}
@@ -3,16 +3,38 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/fromanirh/pack8s/internal/pkg/version"
 	"github.com/spf13/cobra"
+
+	"github.com/fromanirh/pack8s/cmd/cmdutil"
+
+	"github.com/fromanirh/pack8s/internal/pkg/version"
 )
 
+type versionInfo struct {
+	Component string `json:"component" yaml:"component"`
+	Version   string `json:"version" yaml:"version"`
+	Revision  string `json:"revision" yaml:"revision"`
+	Branch    string `json:"branch" yaml:"branch"`
+}
+
 // NewVersionCommand runs given command inside container
 func NewVersionCommand() *cobra.Command {
 	exec := &cobra.Command{
 		Use:   "version",
 		Short: "dump the version and exits",
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cOpts, err := cmdutil.GetCommonOpts(cmd)
+			if err != nil {
+				return err
+			}
+			if cOpts.WantsStructuredOutput() {
+				return cOpts.PrintResult(cmd.OutOrStdout(), versionInfo{
+					Component: version.COMPONENT,
+					Version:   version.VERSION,
+					Revision:  version.REVISION,
+					Branch:    version.BRANCH,
+				})
+			}
 			fmt.Printf("pack8s %s %s\n", version.VERSION, version.REVISION)
 			return nil
 		},
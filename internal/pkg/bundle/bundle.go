@@ -0,0 +1,248 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestVersion is the current version of the bundle manifest format
+	ManifestVersion = 1
+
+	// RoleProvider is the role of the provider image, the aux images use the images roles
+	RoleProvider = "provider"
+
+	manifestName = "manifest.yaml"
+	imagesDir    = "images"
+	digestAlgo   = "sha256"
+)
+
+// Image is an image stored in the bundle, as docker archive
+type Image struct {
+	Role string `yaml:"role"`
+	// Reference is the reference the image was pulled with
+	Reference string `yaml:"reference"`
+	// Tag is the reference the image is stored with. It differs from Reference for the images pinned by digest.
+	Tag string `yaml:"tag"`
+	// ID is the ID of the image, which must be the same once loaded
+	ID string `yaml:"id"`
+	// File is the name of the archive of the image in the bundle
+	File string `yaml:"file"`
+	Size int64  `yaml:"size"`
+	// Digest is the digest of the archive of the image, e.g. "sha256:abc..."
+	Digest string `yaml:"digest"`
+}
+
+// Manifest describes the content of a bundle.
+type Manifest struct {
+	Version  int       `yaml:"version"`
+	Provider string    `yaml:"provider"`
+	Created  time.Time `yaml:"created"`
+	Images   []Image   `yaml:"images"`
+}
+
+// FileName returns the name of the archive of the image with the given role in the bundle
+func FileName(role string) string {
+	return role + ".tar"
+}
+
+// Digest returns the digest and the size of the file at the given path
+func Digest(filePath string) (string, int64, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, src)
+	if err != nil {
+		return "", 0, err
+	}
+	return formatDigest(h.Sum(nil)), size, nil
+}
+
+func formatDigest(sum []byte) string {
+	return digestAlgo + ":" + hex.EncodeToString(sum)
+}
+
+// Writer writes a bundle: a tarball holding the manifest first, then the image archives.
+// Image archives are already compressed, if at all, so the bundle is not.
+type Writer struct {
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+// NewWriter starts a new bundle, writing the given manifest. The digests of the images
+// must be already known, so the bundle can be verified while it is read.
+func NewWriter(w io.Writer, m Manifest) (*Writer, error) {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := &Writer{
+		tw:       tar.NewWriter(w),
+		manifest: m,
+	}
+	err = bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  m.Created,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bw.tw.Write(data); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// AddImage stores the archive of the given image, read from srcPath.
+func (bw *Writer) AddImage(img Image, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = bw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(imagesDir, img.File),
+		Mode:     0644,
+		Size:     img.Size,
+		ModTime:  bw.manifest.Created,
+	})
+	if err != nil {
+		return err
+	}
+	// the file could have changed since its digest was computed: the reader will tell
+	_, err = io.CopyN(bw.tw, src, img.Size)
+	return err
+}
+
+// Close completes the bundle. It doesn't close the underlying writer.
+func (bw *Writer) Close() error {
+	return bw.tw.Close()
+}
+
+// Reader reads a bundle written by Writer
+type Reader struct {
+	Manifest Manifest
+	tr       *tar.Reader
+}
+
+// NewReader opens a bundle, reading its manifest.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := &Reader{
+		tr: tar.NewReader(r),
+	}
+
+	hdr, err := br.tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("malformed bundle: %v", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("malformed bundle: expected %s, found %s", manifestName, hdr.Name)
+	}
+	data, err := ioutil.ReadAll(br.tr)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &br.Manifest); err != nil {
+		return nil, fmt.Errorf("malformed bundle manifest: %v", err)
+	}
+	if br.Manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported bundle manifest version %d (supported: %d)", br.Manifest.Version, ManifestVersion)
+	}
+	return br, nil
+}
+
+// Extract unpacks the image archives in the files returned by imagePath, verifying their digests.
+// All the images listed in the manifest must be found in the bundle.
+func (br *Reader) Extract(imagePath func(img Image) (string, error)) error {
+	found := make(map[string]bool)
+	for {
+		hdr, err := br.tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		img, err := br.findImage(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if found[img.File] {
+			return fmt.Errorf("malformed bundle: duplicate entry %s", hdr.Name)
+		}
+		found[img.File] = true
+
+		dst, err := imagePath(img)
+		if err != nil {
+			return err
+		}
+		if err := extractImage(dst, img, br.tr); err != nil {
+			return err
+		}
+	}
+
+	for _, img := range br.Manifest.Images {
+		if !found[img.File] {
+			return fmt.Errorf("malformed bundle: image %s is missing", img.Reference)
+		}
+	}
+	return nil
+}
+
+// findImage returns the manifest entry of the image stored in the bundle entry with the given name
+func (br *Reader) findImage(name string) (Image, error) {
+	items := strings.Split(path.Clean(name), "/")
+	if len(items) == 2 && items[0] == imagesDir {
+		for _, img := range br.Manifest.Images {
+			if img.File == items[1] {
+				return img, nil
+			}
+		}
+	}
+	return Image{}, fmt.Errorf("malformed bundle: unexpected entry %s", name)
+}
+
+func extractImage(dst string, img Image, r io.Reader) error {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if size != img.Size {
+		return fmt.Errorf("corrupted bundle: image %s has size %d, expected %d", img.Reference, size, img.Size)
+	}
+	if digest := formatDigest(h.Sum(nil)); digest != img.Digest {
+		return fmt.Errorf("corrupted bundle: image %s has digest %s, expected %s", img.Reference, digest, img.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package bundle_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/bundle"
+)
+
+var _ = Describe("bundle", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-bundle")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	makeImage := func(role, ref, content string) bundle.Image {
+		srcPath := filepath.Join(tmpDir, bundle.FileName(role))
+		Expect(ioutil.WriteFile(srcPath, []byte(content), 0644)).To(Succeed())
+		digest, size, err := bundle.Digest(srcPath)
+		Expect(err).To(BeNil())
+		return bundle.Image{
+			Role:      role,
+			Reference: ref,
+			Tag:       ref,
+			File:      bundle.FileName(role),
+			Size:      size,
+			Digest:    digest,
+		}
+	}
+
+	writeBundle := func(m bundle.Manifest) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		bw, err := bundle.NewWriter(buf, m)
+		Expect(err).To(BeNil())
+		for _, img := range m.Images {
+			Expect(bw.AddImage(img, filepath.Join(tmpDir, img.File))).To(Succeed())
+		}
+		Expect(bw.Close()).To(Succeed())
+		return buf
+	}
+
+	extract := func(br *bundle.Reader) (map[string]string, error) {
+		outDir := filepath.Join(tmpDir, "out")
+		Expect(os.MkdirAll(outDir, 0755)).To(Succeed())
+		err := br.Extract(func(img bundle.Image) (string, error) {
+			return filepath.Join(outDir, img.File), nil
+		})
+		contents := make(map[string]string)
+		for _, img := range br.Manifest.Images {
+			data, _ := ioutil.ReadFile(filepath.Join(outDir, img.File))
+			contents[img.Role] = string(data)
+		}
+		return contents, err
+	}
+
+	It("Should round trip the images", func() {
+		m := bundle.Manifest{
+			Version:  bundle.ManifestVersion,
+			Provider: "docker.io/kubevirtci/k8s-1.17.0",
+			Created:  time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC),
+			Images: []bundle.Image{
+				makeImage(bundle.RoleProvider, "docker.io/kubevirtci/k8s-1.17.0", "provider layers"),
+				makeImage("registry", "docker.io/library/registry:2.7.1", "registry layers"),
+			},
+		}
+		Expect(m.Images[0].Digest).To(Equal("sha256:bca69970884f3dba589d578752a840416b80f35ddc2b05e645147ad6d3287548"))
+
+		br, err := bundle.NewReader(writeBundle(m))
+		Expect(err).To(BeNil())
+		Expect(br.Manifest).To(Equal(m))
+
+		contents, err := extract(br)
+		Expect(err).To(BeNil())
+		Expect(contents).To(Equal(map[string]string{
+			bundle.RoleProvider: "provider layers",
+			"registry":          "registry layers",
+		}))
+	})
+
+	It("Should detect corrupted images", func() {
+		img := makeImage("registry", "docker.io/library/registry:2.7.1", "registry layers")
+		m := bundle.Manifest{
+			Version: bundle.ManifestVersion,
+			Images:  []bundle.Image{img},
+		}
+		// same size, different content
+		Expect(ioutil.WriteFile(filepath.Join(tmpDir, img.File), []byte("REGISTRY layers"), 0644)).To(Succeed())
+
+		br, err := bundle.NewReader(writeBundle(m))
+		Expect(err).To(BeNil())
+		_, err = extract(br)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("corrupted bundle"))
+	})
+
+	It("Should detect missing images", func() {
+		m := bundle.Manifest{
+			Version: bundle.ManifestVersion,
+			Images: []bundle.Image{
+				makeImage("registry", "docker.io/library/registry:2.7.1", "registry layers"),
+			},
+		}
+		buf := &bytes.Buffer{}
+		bw, err := bundle.NewWriter(buf, m)
+		Expect(err).To(BeNil())
+		Expect(bw.Close()).To(Succeed())
+
+		br, err := bundle.NewReader(buf)
+		Expect(err).To(BeNil())
+		_, err = extract(br)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should reject unknown manifest versions", func() {
+		buf := writeBundle(bundle.Manifest{Version: bundle.ManifestVersion + 1})
+		_, err := bundle.NewReader(buf)
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,226 @@
+package clusters
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/fromanirh/pack8s/internal/pkg/sshtunnel"
+)
+
+const (
+	// EnvRegistryPath overrides the path of the cluster registry file
+	EnvRegistryPath = "PACK8S_CLUSTERS"
+
+	// FirstMACRange is the MAC range given to the first cluster, to keep the historical addresses (52:55:00:d1:56:xx)
+	FirstMACRange = 0x56
+	// MaxMACRanges is the number of distinct MAC ranges, hence the maximum number of clusters
+	MaxMACRanges = 256
+
+	macBase = "52:55:00:d1"
+)
+
+// Cluster is a cluster started by pack8s
+type Cluster struct {
+	Prefix       string `json:"prefix" yaml:"prefix"`
+	Provider     string `json:"provider" yaml:"provider"`
+	PodmanSocket string `json:"podmanSocket" yaml:"podmanSocket"`
+	// Runtime is the container runtime serving PodmanSocket. Empty means the default one.
+	Runtime   string    `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	MACRange  uint      `json:"macRange" yaml:"macRange"`
+	HostPorts []uint    `json:"hostPorts,omitempty" yaml:"hostPorts,omitempty"`
+	Created   time.Time `json:"created" yaml:"created"`
+}
+
+// MACPrefix returns the first five octets of the MAC addresses reserved to the cluster.
+func (c Cluster) MACPrefix() string {
+	return fmt.Sprintf("%s:%02x", macBase, c.MACRange)
+}
+
+// host returns the host the cluster publishes its ports on: empty for the local host.
+func (c Cluster) host() string {
+	return socketHost(c.PodmanSocket)
+}
+
+// Registry holds all the clusters started by pack8s from this host.
+// Clusters are identified by their prefix and the podman socket serving them.
+type Registry struct {
+	Clusters []Cluster `yaml:"clusters"`
+}
+
+// DefaultPath returns the path of the cluster registry file
+func DefaultPath() (string, error) {
+	if path, ok := os.LookupEnv(EnvRegistryPath); ok && path != "" {
+		return path, nil
+	}
+	confDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(confDir, "pack8s", "clusters.yaml"), nil
+}
+
+// Load reads the registry from the given path. A missing file is not an error: an empty registry is returned.
+func Load(path string) (*Registry, error) {
+	reg := &Registry{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("malformed cluster registry %s: %v", path, err)
+	}
+	return reg, nil
+}
+
+func (reg *Registry) Save(path string) error {
+	data, err := yaml.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// write and rename, so readers never see a partial file
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Update loads the registry, calls fn and saves the registry if fn succeeds.
+// The registry is locked for the whole update, so more pack8s instances can safely run at the same time.
+func Update(path string, fn func(reg *Registry) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("cannot lock the cluster registry %s: %v", path, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	reg, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(reg); err != nil {
+		return err
+	}
+	return reg.Save(path)
+}
+
+// Find returns the cluster with the given prefix served by the given podman socket
+func (reg *Registry) Find(prefix, socket string) (Cluster, bool) {
+	for _, c := range reg.Clusters {
+		if c.Prefix == prefix && c.PodmanSocket == socket {
+			return c, true
+		}
+	}
+	return Cluster{}, false
+}
+
+// Remove drops the cluster with the given prefix served by the given podman socket from the registry,
+// and tells if it was found.
+func (reg *Registry) Remove(prefix, socket string) bool {
+	for idx, c := range reg.Clusters {
+		if c.Prefix == prefix && c.PodmanSocket == socket {
+			reg.Clusters = append(reg.Clusters[:idx], reg.Clusters[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Sorted returns the registered clusters, oldest first.
+func (reg *Registry) Sorted() []Cluster {
+	res := make([]Cluster, len(reg.Clusters))
+	copy(res, reg.Clusters)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+// CheckConflicts verifies a new cluster with the given prefix, podman socket and host ports can coexist with the registered ones.
+// Prefixes must be unique among the clusters served by the same podman socket.
+// Host ports must be unique among the clusters publishing their ports on the same host.
+func (reg *Registry) CheckConflicts(prefix, socket string, hostPorts []uint) error {
+	host := socketHost(socket)
+	for _, c := range reg.Clusters {
+		if c.PodmanSocket == socket {
+			if c.Prefix == prefix {
+				return fmt.Errorf("cluster %s already exists", prefix)
+			}
+		}
+		if c.host() != host {
+			continue
+		}
+		for _, port := range hostPorts {
+			for _, used := range c.HostPorts {
+				if port == used {
+					return fmt.Errorf("host port %d is already used by cluster %s", port, c.Prefix)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Add registers a new cluster, after checking for conflicts, and reserves a MAC range for it.
+func (reg *Registry) Add(c Cluster) (Cluster, error) {
+	if err := reg.CheckConflicts(c.Prefix, c.PodmanSocket, c.HostPorts); err != nil {
+		return c, err
+	}
+	macRange, err := reg.freeMACRange()
+	if err != nil {
+		return c, err
+	}
+	c.MACRange = macRange
+	if c.Created.IsZero() {
+		c.Created = time.Now()
+	}
+	reg.Clusters = append(reg.Clusters, c)
+	return c, nil
+}
+
+func (reg *Registry) freeMACRange() (uint, error) {
+	used := make(map[uint]bool)
+	for _, c := range reg.Clusters {
+		used[c.MACRange] = true
+	}
+	for i := uint(0); i < MaxMACRanges; i++ {
+		macRange := (FirstMACRange + i) % MaxMACRanges
+		if !used[macRange] {
+			return macRange, nil
+		}
+	}
+	return 0, fmt.Errorf("no free MAC range left: too many clusters")
+}
+
+// socketHost returns the host serving the given podman socket: empty for the local host.
+func socketHost(socket string) string {
+	if !sshtunnel.IsRemote(socket) {
+		return ""
+	}
+	addr, err := sshtunnel.Parse(socket)
+	if err != nil {
+		// malformed sockets are never used, so they can't conflict with anything but themselves
+		return socket
+	}
+	return addr.Host
+}
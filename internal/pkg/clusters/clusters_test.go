@@ -0,0 +1,113 @@
+package clusters_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/clusters"
+)
+
+var _ = Describe("clusters", func() {
+	Context("registry", func() {
+		It("Should give unique MAC ranges", func() {
+			reg := &clusters.Registry{}
+			first, err := reg.Add(clusters.Cluster{Prefix: "first"})
+			Expect(err).To(BeNil())
+			Expect(first.MACPrefix()).To(Equal("52:55:00:d1:56"))
+
+			second, err := reg.Add(clusters.Cluster{Prefix: "second"})
+			Expect(err).To(BeNil())
+			Expect(second.MACPrefix()).To(Equal("52:55:00:d1:57"))
+
+			Expect(reg.Remove("first", "")).To(BeTrue())
+			third, err := reg.Add(clusters.Cluster{Prefix: "third"})
+			Expect(err).To(BeNil())
+			Expect(third.MACPrefix()).To(Equal("52:55:00:d1:56"))
+		})
+
+		It("Should run out of MAC ranges", func() {
+			reg := &clusters.Registry{}
+			for i := 0; i < clusters.MaxMACRanges; i++ {
+				_, err := reg.Add(clusters.Cluster{Prefix: fmt.Sprintf("c%03d", i)})
+				Expect(err).To(BeNil())
+			}
+			_, err := reg.Add(clusters.Cluster{Prefix: "onemore"})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should detect prefix conflicts", func() {
+			reg := &clusters.Registry{}
+			_, err := reg.Add(clusters.Cluster{Prefix: "kubevirt"})
+			Expect(err).To(BeNil())
+
+			Expect(reg.CheckConflicts("kubevirt", "", nil)).NotTo(BeNil())
+			Expect(reg.CheckConflicts("kubevirt2", "", nil)).To(BeNil())
+			Expect(reg.CheckConflicts("kubevirt-ipv6", "", nil)).To(BeNil())
+			Expect(reg.CheckConflicts("kube", "", nil)).To(BeNil())
+			Expect(reg.CheckConflicts("other", "", nil)).To(BeNil())
+		})
+
+		It("Should allow the same prefix on different podman sockets", func() {
+			reg := &clusters.Registry{}
+			_, err := reg.Add(clusters.Cluster{Prefix: "kubevirt", PodmanSocket: "unix:/run/podman/io.podman"})
+			Expect(err).To(BeNil())
+
+			Expect(reg.CheckConflicts("kubevirt", "ssh://core@builder/run/podman/io.podman", nil)).To(BeNil())
+			Expect(reg.CheckConflicts("kubevirt2", "ssh://core@builder/run/podman/io.podman", nil)).To(BeNil())
+			Expect(reg.CheckConflicts("kubevirt", "unix:/run/podman/io.podman", nil)).NotTo(BeNil())
+		})
+
+		It("Should detect host port conflicts", func() {
+			reg := &clusters.Registry{}
+			_, err := reg.Add(clusters.Cluster{Prefix: "first", HostPorts: []uint{2201, 6443}})
+			Expect(err).To(BeNil())
+
+			_, err = reg.Add(clusters.Cluster{Prefix: "second", HostPorts: []uint{6443}})
+			Expect(err).NotTo(BeNil())
+			_, err = reg.Add(clusters.Cluster{Prefix: "second", HostPorts: []uint{6444}})
+			Expect(err).To(BeNil())
+		})
+
+		It("Should check the host ports only among the clusters on the same host", func() {
+			reg := &clusters.Registry{}
+			_, err := reg.Add(clusters.Cluster{Prefix: "first", PodmanSocket: "ssh://core@builder/run/podman/io.podman", HostPorts: []uint{6443}})
+			Expect(err).To(BeNil())
+
+			_, err = reg.Add(clusters.Cluster{Prefix: "second", PodmanSocket: "unix:/run/podman/io.podman", HostPorts: []uint{6443}})
+			Expect(err).To(BeNil())
+			_, err = reg.Add(clusters.Cluster{Prefix: "third", PodmanSocket: "ssh://other@builder:2222/run/user/1000/podman/io.podman", HostPorts: []uint{6443}})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should persist the clusters", func() {
+			tmpDir, err := ioutil.TempDir("", "pack8s-clusters")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(tmpDir)
+
+			path := filepath.Join(tmpDir, "pack8s", "clusters.yaml")
+			err = clusters.Update(path, func(reg *clusters.Registry) error {
+				_, err := reg.Add(clusters.Cluster{Prefix: "first", Provider: "k8s-1.16.2", HostPorts: []uint{2201}})
+				return err
+			})
+			Expect(err).To(BeNil())
+
+			err = clusters.Update(path, func(reg *clusters.Registry) error {
+				return fmt.Errorf("failed")
+			})
+			Expect(err).NotTo(BeNil())
+
+			reg, err := clusters.Load(path)
+			Expect(err).To(BeNil())
+			c, ok := reg.Find("first", "")
+			Expect(ok).To(BeTrue())
+			Expect(c.Provider).To(Equal("k8s-1.16.2"))
+			Expect(c.HostPorts).To(Equal([]uint{2201}))
+			Expect(c.Created.IsZero()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,1043 @@
+package fakeruntime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	StatusCreated = "created"
+	StatusRunning = "running"
+	StatusExited  = "exited"
+
+	// FirstRandomPort is the first host port given to the ports published on random host ports
+	FirstRandomPort = 32768
+
+	volumesDir = "/var/lib/containers/storage/volumes"
+)
+
+// Container is a container of the fake runtime, along with the settings it was created with
+type Container struct {
+	iopodman.Container
+	Conf     iopodman.Create
+	ExitCode int32
+	exited   chan struct{}
+}
+
+// Pod is a pod of the fake runtime, along with the settings it was created with
+type Pod struct {
+	iopodman.ListPodData
+	Conf iopodman.PodCreate
+}
+
+// ExecCall is a command run in a container
+type ExecCall struct {
+	Container string
+	Args      []string
+	Env       []string
+	Workdir   string
+}
+
+// ExecHandler emulates the commands run in the containers. The returned error is returned
+// to the caller: use *podman.ExitError to emulate a command failure.
+type ExecHandler func(call ExecCall, opts podman.ExecOptions) error
+
+// state is shared by a Runtime and all its clones
+type state struct {
+	lock        sync.Mutex
+	lastID      int
+	lastPort    int
+	containers  []*Container
+	volumes     []iopodman.Volume
+	pods        []*Pod
+	execs       []ExecCall
+	pulls       []string
+	removed     []string
+	faults      map[string]error
+	execHandler ExecHandler
+	pullConfig  images.PullConfig
+	logs        []iopodman.LogLine
+	// logged is closed, and replaced, when new lines are logged
+	logged chan struct{}
+}
+
+// Runtime is an in-memory podman.Runtime, which records everything done through it. It runs no command:
+// the exec calls succeed unless told otherwise with SetExecHandler.
+// A Runtime can be used concurrently by more goroutines.
+type Runtime struct {
+	*state
+	ctx context.Context
+}
+
+var _ podman.Runtime = &Runtime{}
+
+// New returns an empty Runtime
+func New() *Runtime {
+	return &Runtime{
+		state: &state{
+			lastPort:   FirstRandomPort - 1,
+			faults:     make(map[string]error),
+			pullConfig: images.DefaultPullConfig(),
+			logged:     make(chan struct{}),
+		},
+		ctx: context.Background(),
+	}
+}
+
+// Fail makes the given method fail with err when called on the object with the given name, or on any object
+// if name is empty. The object is the container, volume, pod or image the method is about. A nil err removes the fault.
+func (rt *Runtime) Fail(method, name string, err error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	key := method + "/" + name
+	if err == nil {
+		delete(rt.faults, key)
+		return
+	}
+	rt.faults[key] = err
+}
+
+// SetExecHandler sets how the commands run in the containers behave
+func (rt *Runtime) SetExecHandler(handler ExecHandler) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.execHandler = handler
+}
+
+// Containers returns all the containers, in creation order
+func (rt *Runtime) Containers() []Container {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	ret := []Container{}
+	for _, cont := range rt.containers {
+		ret = append(ret, *cont)
+	}
+	return ret
+}
+
+// Volumes returns all the volumes, in creation order
+func (rt *Runtime) Volumes() []iopodman.Volume {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]iopodman.Volume{}, rt.volumes...)
+}
+
+// Pods returns all the pods, in creation order
+func (rt *Runtime) Pods() []Pod {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	ret := []Pod{}
+	for _, pod := range rt.pods {
+		ret = append(ret, *pod)
+	}
+	return ret
+}
+
+// Execs returns all the commands run in the containers, in call order
+func (rt *Runtime) Execs() []ExecCall {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]ExecCall{}, rt.execs...)
+}
+
+// Pulls returns all the images pulled, in call order
+func (rt *Runtime) Pulls() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]string{}, rt.pulls...)
+}
+
+// RemovedImages returns all the images removed, in call order
+func (rt *Runtime) RemovedImages() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]string{}, rt.removed...)
+}
+
+// PullConfig returns the configuration set by the last SetPullConfig
+func (rt *Runtime) PullConfig() images.PullConfig {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return rt.pullConfig
+}
+
+// Exit makes the given running container exit with the given code
+func (rt *Runtime) Exit(name string, code int32) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return err
+	}
+	cont.ExitCode = code
+	rt.stop(cont)
+	return nil
+}
+
+// Log makes the given container log the given line. The line is logged now, unless it tells otherwise.
+func (rt *Runtime) Log(name string, line iopodman.LogLine) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return err
+	}
+	line.Cid = cont.Id
+	if line.Device == "" {
+		line.Device = podman.LogStdout
+	}
+	if line.ParseLogType == "" {
+		line.ParseLogType = "F"
+	}
+	if line.Time == "" {
+		line.Time = time.Now().Format(time.RFC3339Nano)
+	}
+	rt.logs = append(rt.logs, line)
+	close(rt.logged)
+	rt.logged = make(chan struct{})
+	return nil
+}
+
+// fault returns the error injected for the given method and object, if any. Must be called with the lock held.
+func (rt *Runtime) fault(method, name string) error {
+	if err, ok := rt.faults[method+"/"+name]; ok {
+		return err
+	}
+	return rt.faults[method+"/"]
+}
+
+// findContainer returns the container with the given ID or name. Must be called with the lock held.
+func (rt *Runtime) findContainer(name string) (*Container, error) {
+	for _, cont := range rt.containers {
+		if cont.Id == name || cont.Names == name {
+			return cont, nil
+		}
+	}
+	return nil, &iopodman.ContainerNotFound{Id: name, Reason: "no such container"}
+}
+
+// findPod returns the pod with the given ID or name. Must be called with the lock held.
+func (rt *Runtime) findPod(name string) (*Pod, error) {
+	for _, pod := range rt.pods {
+		if pod.Id == name || pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, &iopodman.PodNotFound{Name: name, Reason: "no such pod"}
+}
+
+func (rt *Runtime) findVolume(name string) int {
+	for idx, vol := range rt.volumes {
+		if vol.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// newID returns a new, unique, object ID. Must be called with the lock held.
+func (rt *Runtime) newID() string {
+	rt.lastID++
+	return fmt.Sprintf("%064x", rt.lastID)
+}
+
+// publish returns the mappings of the given published ports, like "[ip:][hostPort:]containerPort[/protocol]".
+// Must be called with the lock held.
+func (rt *Runtime) publish(specs []string) ([]iopodman.ContainerPortMappings, error) {
+	ret := []iopodman.ContainerPortMappings{}
+	for _, spec := range specs {
+		pm := iopodman.ContainerPortMappings{Protocol: "tcp"}
+		items := strings.Split(spec, ":")
+		switch len(items) {
+		case 1:
+		case 2:
+			pm.Host_port = items[0]
+		case 3:
+			pm.Host_ip, pm.Host_port = items[0], items[1]
+		default:
+			return nil, fmt.Errorf("malformed published port: %s", spec)
+		}
+		pm.Container_port = items[len(items)-1]
+		if idx := strings.Index(pm.Container_port, "/"); idx >= 0 {
+			pm.Container_port, pm.Protocol = pm.Container_port[:idx], pm.Container_port[idx+1:]
+		}
+		if pm.Host_port == "" || pm.Host_port == "0" {
+			rt.lastPort++
+			pm.Host_port = strconv.Itoa(rt.lastPort)
+		}
+		ret = append(ret, pm)
+	}
+	return ret, nil
+}
+
+// mounts returns the mounts of the container, creating the named volumes which don't exist yet, like podman does.
+// Must be called with the lock held.
+func (rt *Runtime) mounts(specs []string) []iopodman.ContainerMount {
+	ret := []iopodman.ContainerMount{}
+	for _, spec := range specs {
+		mnt := iopodman.ContainerMount{}
+		for _, item := range strings.Split(spec, ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) == 1 && (kv[0] == "readonly" || kv[0] == "ro") {
+				kv = append(kv, "true")
+			}
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "type":
+				mnt.Type = kv[1]
+			case "source", "src":
+				mnt.Source = kv[1]
+			case "destination", "dst", "target":
+				mnt.Destination = kv[1]
+			case "readonly", "ro":
+				if kv[1] == "true" {
+					mnt.Options = append(mnt.Options, "ro")
+				}
+			}
+		}
+		if mnt.Type == "volume" && rt.findVolume(mnt.Source) == -1 {
+			rt.volumes = append(rt.volumes, newVolume(mnt.Source))
+		}
+		ret = append(ret, mnt)
+	}
+	return ret
+}
+
+func isReadOnly(mnt iopodman.ContainerMount) bool {
+	for _, opt := range mnt.Options {
+		if opt == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+func newVolume(name string) iopodman.Volume {
+	return iopodman.Volume{
+		Name:       name,
+		Labels:     map[string]string{},
+		MountPoint: volumesDir + "/" + name + "/_data",
+		Driver:     "local",
+		Options:    map[string]string{},
+	}
+}
+
+func (rt *Runtime) CreateContainer(conf iopodman.Create) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if len(conf.Args) == 0 {
+		return "", &iopodman.ErrorOccurred{Reason: "missing container image"}
+	}
+	name := ""
+	if conf.Name != nil {
+		name = *conf.Name
+	}
+	if err := rt.fault("CreateContainer", name); err != nil {
+		return "", err
+	}
+	if name != "" {
+		if _, err := rt.findContainer(name); err == nil {
+			return "", &iopodman.ErrorOccurred{Reason: fmt.Sprintf("the container name %q is already in use", name)}
+		}
+	}
+
+	var pod *Pod
+	if conf.Pod != nil && *conf.Pod != "" {
+		var err error
+		if pod, err = rt.findPod(*conf.Pod); err != nil {
+			return "", err
+		}
+	}
+
+	id := rt.newID()
+	if name == "" {
+		name = "container-" + id[len(id)-8:]
+	}
+	cont := &Container{
+		Container: iopodman.Container{
+			Id:        id,
+			Image:     conf.Args[0],
+			Command:   conf.Args[1:],
+			Createdat: time.Now().Format(time.RFC3339),
+			Status:    StatusCreated,
+			Names:     name,
+			Labels:    make(map[string]string),
+			Ports:     []iopodman.ContainerPortMappings{},
+		},
+		Conf:   conf,
+		exited: make(chan struct{}),
+	}
+	if conf.Label != nil {
+		for _, label := range *conf.Label {
+			kv := strings.SplitN(label, "=", 2)
+			cont.Labels[kv[0]] = ""
+			if len(kv) == 2 {
+				cont.Labels[kv[0]] = kv[1]
+			}
+		}
+	}
+	if conf.Publish != nil {
+		ports, err := rt.publish(*conf.Publish)
+		if err != nil {
+			return "", err
+		}
+		cont.Ports = ports
+	}
+	if conf.PublishAll != nil && *conf.PublishAll && conf.Expose != nil {
+		published := make(map[string]bool)
+		for _, pm := range cont.Ports {
+			published[pm.Container_port] = true
+		}
+		for _, port := range *conf.Expose {
+			if published[port] {
+				continue
+			}
+			ports, err := rt.publish([]string{port})
+			if err != nil {
+				return "", err
+			}
+			cont.Ports = append(cont.Ports, ports...)
+		}
+	}
+	if conf.Mount != nil {
+		cont.Mounts = rt.mounts(*conf.Mount)
+	}
+
+	rt.containers = append(rt.containers, cont)
+	if pod != nil {
+		pod.Containersinfo = append(pod.Containersinfo, iopodman.ListPodContainerInfo{
+			Name:   cont.Names,
+			Id:     cont.Id,
+			Status: cont.Status,
+		})
+	}
+	return id, nil
+}
+
+func (rt *Runtime) StartContainer(contID string) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	cont, err := rt.findContainer(contID)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.fault("StartContainer", cont.Names); err != nil {
+		return "", err
+	}
+	if cont.Containerrunning {
+		return cont.Id, nil
+	}
+	cont.Status = StatusRunning
+	cont.Containerrunning = true
+	cont.ExitCode = 0
+	cont.exited = make(chan struct{})
+	rt.updatePods(cont)
+	return cont.Id, nil
+}
+
+func (rt *Runtime) StopContainer(name string, timeout int64) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.fault("StopContainer", cont.Names); err != nil {
+		return "", err
+	}
+	rt.stop(cont)
+	return cont.Id, nil
+}
+
+// stop stops the container, if running. Must be called with the lock held.
+func (rt *Runtime) stop(cont *Container) {
+	if !cont.Containerrunning {
+		return
+	}
+	cont.Status = StatusExited
+	cont.Containerrunning = false
+	close(cont.exited)
+	rt.updatePods(cont)
+}
+
+// updatePods updates the container status in its pod, if any. Must be called with the lock held.
+func (rt *Runtime) updatePods(cont *Container) {
+	for _, pod := range rt.pods {
+		for idx := range pod.Containersinfo {
+			if pod.Containersinfo[idx].Id == cont.Id {
+				pod.Containersinfo[idx].Status = cont.Status
+			}
+		}
+	}
+}
+
+func (rt *Runtime) RemoveContainer(cont iopodman.Container, force, removeVolumes bool) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	name := cont.Id
+	if name == "" {
+		name = cont.Names
+	}
+	target, err := rt.findContainer(name)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.fault("RemoveContainer", target.Names); err != nil {
+		return "", err
+	}
+	if target.Containerrunning && !force {
+		return "", &iopodman.ErrorOccurred{Reason: fmt.Sprintf("cannot remove container %s as it is running", target.Id)}
+	}
+	rt.remove(target)
+	return target.Id, nil
+}
+
+// remove removes the container, stopping it if needed. Must be called with the lock held.
+func (rt *Runtime) remove(target *Container) {
+	rt.stop(target)
+	for idx, cont := range rt.containers {
+		if cont == target {
+			rt.containers = append(rt.containers[:idx], rt.containers[idx+1:]...)
+			break
+		}
+	}
+	for _, pod := range rt.pods {
+		for idx, info := range pod.Containersinfo {
+			if info.Id == target.Id {
+				pod.Containersinfo = append(pod.Containersinfo[:idx], pod.Containersinfo[idx+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (rt *Runtime) GetPrefixedContainers(prefix string) ([]iopodman.Container, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("GetPrefixedContainers", prefix); err != nil {
+		return nil, err
+	}
+	ret := []iopodman.Container{}
+	for _, cont := range rt.containers {
+		if strings.HasPrefix(cont.Names, prefix) {
+			ret = append(ret, cont.Container)
+		}
+	}
+	return ret, nil
+}
+
+func (rt *Runtime) FindPrefixedContainer(prefixedName string) (iopodman.Container, error) {
+	containers, err := rt.GetPrefixedContainers(prefixedName)
+	if err != nil {
+		return iopodman.Container{}, err
+	}
+	if len(containers) != 1 {
+		return iopodman.Container{}, fmt.Errorf("failed to found the container with name %s", prefixedName)
+	}
+	return containers[0], nil
+}
+
+// WaitContainer waits for the container to exit, by Exit or StopContainer, or to be removed.
+func (rt *Runtime) WaitContainer(name string, interval int64) (int64, error) {
+	rt.lock.Lock()
+	cont, err := rt.findContainer(name)
+	if err == nil {
+		err = rt.fault("WaitContainer", cont.Names)
+	}
+	rt.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-cont.exited:
+	case <-rt.ctx.Done():
+		return 0, rt.ctx.Err()
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return int64(cont.ExitCode), nil
+}
+
+func (rt *Runtime) InspectContainer(name string) (podman.ContainerInspect, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return podman.ContainerInspect{}, err
+	}
+	inspect := podman.ContainerInspect{
+		ImageName: cont.Image,
+		Mounts:    []podman.ContainerMount{},
+	}
+	for _, mnt := range cont.Mounts {
+		inspect.Mounts = append(inspect.Mounts, podman.ContainerMount{
+			Type:        mnt.Type,
+			Name:        mnt.Source,
+			Source:      mnt.Source,
+			Destination: mnt.Destination,
+			RW:          !isReadOnly(mnt),
+		})
+	}
+	conf := cont.Conf
+	inspect.Config.Cmd = cont.Command
+	inspect.Config.Labels = cont.Labels
+	if conf.Env != nil {
+		inspect.Config.Env = *conf.Env
+	}
+	if conf.Entrypoint != nil {
+		inspect.Config.Entrypoint = *conf.Entrypoint
+	}
+	if conf.WorkDir != nil {
+		inspect.Config.WorkingDir = *conf.WorkDir
+	}
+	if conf.Privileged != nil {
+		inspect.HostConfig.Privileged = *conf.Privileged
+	}
+	if conf.Network != nil {
+		inspect.HostConfig.NetworkMode = *conf.Network
+	}
+	if conf.AddHost != nil {
+		inspect.HostConfig.ExtraHosts = *conf.AddHost
+	}
+	return inspect, nil
+}
+
+func (rt *Runtime) GetContainerState(name string) (podman.ContainerState, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return podman.ContainerState{}, err
+	}
+	return podman.ContainerState{
+		Status:   cont.Status,
+		Running:  cont.Containerrunning,
+		ExitCode: cont.ExitCode,
+	}, nil
+}
+
+func (rt *Runtime) Exec(container string, args []string, out io.Writer) error {
+	return rt.ExecWithOptions(container, args, podman.ExecOptions{
+		Stdout: out,
+		Stderr: os.Stderr,
+		Tty:    true,
+	})
+}
+
+// ExecWithOptions records the call, then runs the ExecHandler, if any.
+func (rt *Runtime) ExecWithOptions(container string, args []string, opts podman.ExecOptions) error {
+	rt.lock.Lock()
+	cont, err := rt.findContainer(container)
+	if err == nil && !cont.Containerrunning {
+		err = &iopodman.ErrorOccurred{Reason: fmt.Sprintf("cannot exec into container %s: not running", container)}
+	}
+	if err == nil {
+		err = rt.fault("ExecWithOptions", cont.Names)
+	}
+	if err != nil {
+		rt.lock.Unlock()
+		return err
+	}
+	call := ExecCall{
+		Container: container,
+		Args:      append([]string{}, args...),
+		Env:       opts.Env,
+		Workdir:   opts.Workdir,
+	}
+	rt.execs = append(rt.execs, call)
+	handler := rt.execHandler
+	rt.lock.Unlock()
+
+	// the handler may take its time, and may use the runtime itself
+	if handler == nil {
+		return nil
+	}
+	// like the real runtimes, stop waiting for the command once the context is done
+	errs := make(chan error, 1)
+	go func() {
+		errs <- handler(call, opts)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-rt.ctx.Done():
+		return rt.ctx.Err()
+	}
+}
+
+// GetContainerLogs returns the messages logged by the given container
+func (rt *Runtime) GetContainerLogs(name string) ([]string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	cont, err := rt.findContainer(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := rt.fault("GetContainerLogs", cont.Names); err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range rt.logs {
+		if line.Cid == cont.Id {
+			lines = append(lines, line.Msg)
+		}
+	}
+	return lines, nil
+}
+
+// GetContainersLogs sends the lines logged by the given containers to handle, in the order they were logged.
+// Following, it waits for new lines until the context of the Runtime is done.
+func (rt *Runtime) GetContainersLogs(names []string, opts podman.LogOptions, handle podman.LogHandler) error {
+	ids := make(map[string]bool)
+	rt.lock.Lock()
+	for _, name := range names {
+		cont, err := rt.findContainer(name)
+		if err == nil {
+			err = rt.fault("GetContainersLogs", cont.Names)
+		}
+		if err != nil {
+			rt.lock.Unlock()
+			return err
+		}
+		ids[cont.Id] = true
+	}
+	rt.lock.Unlock()
+
+	next := 0
+	for {
+		rt.lock.Lock()
+		lines := rt.logs[next:]
+		next = len(rt.logs)
+		logged := rt.logged
+		rt.lock.Unlock()
+
+		for _, line := range lines {
+			if !ids[line.Cid] {
+				continue
+			}
+			if !opts.Since.IsZero() {
+				if ts, err := time.Parse(time.RFC3339Nano, line.Time); err == nil && ts.Before(opts.Since) {
+					continue
+				}
+			}
+			if err := handle(line); err != nil {
+				return err
+			}
+		}
+
+		if !opts.Follow {
+			return nil
+		}
+		select {
+		case <-logged:
+		case <-rt.ctx.Done():
+			return rt.ctx.Err()
+		}
+	}
+}
+
+func (rt *Runtime) CreateNamedVolume(name string) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("CreateNamedVolume", name); err != nil {
+		return "", err
+	}
+	if rt.findVolume(name) != -1 {
+		return "", &iopodman.ErrorOccurred{Reason: fmt.Sprintf("volume with name %s already exists", name)}
+	}
+	rt.volumes = append(rt.volumes, newVolume(name))
+	return name, nil
+}
+
+func (rt *Runtime) GetPrefixedVolumes(prefix string) ([]iopodman.Volume, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	ret := []iopodman.Volume{}
+	for _, vol := range rt.volumes {
+		if strings.HasPrefix(vol.Name, prefix) {
+			ret = append(ret, vol)
+		}
+	}
+	return ret, nil
+}
+
+func (rt *Runtime) GetAllVolumes() ([]iopodman.Volume, error) {
+	return rt.GetPrefixedVolumes("")
+}
+
+func (rt *Runtime) RemoveVolumes(volumes []iopodman.Volume) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	for _, vol := range volumes {
+		if err := rt.fault("RemoveVolumes", vol.Name); err != nil {
+			return err
+		}
+		idx := rt.findVolume(vol.Name)
+		if idx == -1 {
+			return &iopodman.VolumeNotFound{Id: vol.Name, Reason: "no such volume"}
+		}
+		rt.volumes = append(rt.volumes[:idx], rt.volumes[idx+1:]...)
+	}
+	return nil
+}
+
+// PruneVolumes removes the volumes not mounted by any container
+func (rt *Runtime) PruneVolumes() error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("PruneVolumes", ""); err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, cont := range rt.containers {
+		for _, mnt := range cont.Mounts {
+			if mnt.Type == "volume" {
+				used[mnt.Source] = true
+			}
+		}
+	}
+	volumes := []iopodman.Volume{}
+	for _, vol := range rt.volumes {
+		if used[vol.Name] {
+			volumes = append(volumes, vol)
+		}
+	}
+	rt.volumes = volumes
+	return nil
+}
+
+// CreatePod creates the pod along with its infra container, which publishes the ports of the pod.
+func (rt *Runtime) CreatePod(conf iopodman.PodCreate) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("CreatePod", conf.Name); err != nil {
+		return "", err
+	}
+	if _, err := rt.findPod(conf.Name); err == nil {
+		return "", &iopodman.ErrorOccurred{Reason: fmt.Sprintf("the pod name %q is already in use", conf.Name)}
+	}
+	ports, err := rt.publish(conf.Publish)
+	if err != nil {
+		return "", err
+	}
+
+	id := rt.newID()
+	pod := &Pod{
+		ListPodData: iopodman.ListPodData{
+			Id:        id,
+			Name:      conf.Name,
+			Createdat: time.Now().Format(time.RFC3339),
+			Status:    StatusCreated,
+			Labels:    conf.Labels,
+		},
+		Conf: conf,
+	}
+	if conf.Infra {
+		infraID := rt.newID()
+		infra := &Container{
+			Container: iopodman.Container{
+				Id:        infraID,
+				Image:     "k8s.gcr.io/pause:3.1",
+				Createdat: pod.Createdat,
+				Status:    StatusRunning,
+				Names:     id[:12] + "-infra",
+				Labels:    map[string]string{},
+				Ports:     ports,
+
+				Containerrunning: true,
+			},
+			exited: make(chan struct{}),
+		}
+		rt.containers = append(rt.containers, infra)
+		pod.Containersinfo = append(pod.Containersinfo, iopodman.ListPodContainerInfo{
+			Name:   infra.Names,
+			Id:     infra.Id,
+			Status: infra.Status,
+		})
+		pod.Status = StatusRunning
+	}
+	rt.pods = append(rt.pods, pod)
+	return id, nil
+}
+
+func (rt *Runtime) StopPod(name string, timeout int64) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	pod, err := rt.findPod(name)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.fault("StopPod", pod.Name); err != nil {
+		return "", err
+	}
+	for _, info := range pod.Containersinfo {
+		if cont, err := rt.findContainer(info.Id); err == nil {
+			rt.stop(cont)
+		}
+	}
+	pod.Status = StatusExited
+	return pod.Id, nil
+}
+
+// RemovePod removes the given pod. With force, the containers of the pod are removed too.
+func (rt *Runtime) RemovePod(name string, force bool) (string, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	pod, err := rt.findPod(name)
+	if err != nil {
+		return "", err
+	}
+	if err := rt.fault("RemovePod", pod.Name); err != nil {
+		return "", err
+	}
+	infos := append([]iopodman.ListPodContainerInfo{}, pod.Containersinfo...)
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name, "-infra") {
+			continue
+		}
+		if !force {
+			return "", &iopodman.ErrorOccurred{Reason: fmt.Sprintf("pod %s contains containers and cannot be removed", pod.Name)}
+		}
+	}
+	for _, info := range infos {
+		if cont, err := rt.findContainer(info.Id); err == nil {
+			rt.remove(cont)
+		}
+	}
+	for idx, p := range rt.pods {
+		if p == pod {
+			rt.pods = append(rt.pods[:idx], rt.pods[idx+1:]...)
+			break
+		}
+	}
+	return pod.Id, nil
+}
+
+func (rt *Runtime) FindPod(name string) (iopodman.ListPodData, bool, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	pod, err := rt.findPod(name)
+	if err != nil {
+		return iopodman.ListPodData{}, false, nil
+	}
+	data := pod.ListPodData
+	data.Containersinfo = append([]iopodman.ListPodContainerInfo{}, pod.Containersinfo...)
+	data.Numberofcontainers = strconv.Itoa(len(data.Containersinfo))
+	return data, true, nil
+}
+
+// GetPodPorts returns the ports published by the given pod. The ports of a pod are held by its infra container.
+func (rt *Runtime) GetPodPorts(pod iopodman.ListPodData) ([]iopodman.ContainerPortMappings, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	for _, info := range pod.Containersinfo {
+		if !strings.HasSuffix(info.Name, "-infra") {
+			continue
+		}
+		cont, err := rt.findContainer(info.Id)
+		if err != nil {
+			return nil, err
+		}
+		return cont.Ports, nil
+	}
+	return nil, fmt.Errorf("pod %s has no infra container", pod.Name)
+}
+
+// PullImage records the pull of the given image
+func (rt *Runtime) PullImage(ref string) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("PullImage", ref); err != nil {
+		return err
+	}
+	rt.pulls = append(rt.pulls, ref)
+	return nil
+}
+
+// PullClusterImages pulls the provider image and the auxiliary images the cluster wants, one after another.
+// Optional images which can't be pulled are skipped.
+func (rt *Runtime) PullClusterImages(reqs images.Requests, clusterRegistry, clusterImage string) error {
+	if err := rt.PullImage(clusterRegistry + "/" + clusterImage); err != nil {
+		return err
+	}
+	auxImages := reqs.AuxImages()
+	for _, role := range images.WantedRoles(reqs) {
+		err := rt.PullImage(auxImages.Get(role))
+		if err != nil && !rt.PullConfig().Policy(role).Optional {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveImage records the removal of the given image, refused if a container uses it
+func (rt *Runtime) RemoveImage(name string) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.fault("RemoveImage", name); err != nil {
+		return err
+	}
+	for _, cont := range rt.containers {
+		if cont.Image == name {
+			return fmt.Errorf("image %s is in use by container %s", name, cont.Id)
+		}
+	}
+	rt.removed = append(rt.removed, name)
+	return nil
+}
+
+func (rt *Runtime) SetPullConfig(conf images.PullConfig) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.pullConfig = conf
+}
+
+// SetPullReporter does nothing: the fake pulls have no progress to report
+func (rt *Runtime) SetPullReporter(reporter podman.PullProgressReporter) {
+}
+
+// Clone returns a Runtime sharing the state with this one, bound to the given context
+func (rt *Runtime) Clone(ctx context.Context) podman.Runtime {
+	return &Runtime{
+		state: rt.state,
+		ctx:   ctx,
+	}
+}
+
+func (rt *Runtime) Close() error {
+	return nil
+}
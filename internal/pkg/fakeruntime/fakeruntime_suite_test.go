@@ -0,0 +1,13 @@
+package fakeruntime_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFakeRuntime(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FakeRuntime Suite")
+}
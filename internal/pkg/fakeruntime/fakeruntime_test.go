@@ -0,0 +1,249 @@
+package fakeruntime_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/fakeruntime"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+var _ = Describe("fakeruntime", func() {
+	var rt *fakeruntime.Runtime
+
+	BeforeEach(func() {
+		rt = fakeruntime.New()
+	})
+
+	runContainer := func(conf iopodman.Create) string {
+		id, err := rt.CreateContainer(conf)
+		Expect(err).To(BeNil())
+		_, err = rt.StartContainer(id)
+		Expect(err).To(BeNil())
+		return id
+	}
+
+	Context("containers", func() {
+		It("Should record the containers settings", func() {
+			name := "pack8s-test"
+			labels := []string{podman.LabelGeneration + "=042"}
+			publish := []string{"127.0.0.1:2201:22", "5000"}
+			mounts := []string{
+				"type=volume,source=pack8s-test-vol,destination=/data",
+				"type=bind,source=/etc/hosts,destination=/etc/hosts,readonly",
+			}
+			id := runContainer(iopodman.Create{
+				Args:    []string{"registry:2", "serve"},
+				Name:    &name,
+				Label:   &labels,
+				Publish: &publish,
+				Mount:   &mounts,
+			})
+
+			cont, err := rt.FindPrefixedContainer("pack8s")
+			Expect(err).To(BeNil())
+			Expect(cont.Id).To(Equal(id))
+			Expect(cont.Image).To(Equal("registry:2"))
+			Expect(cont.Containerrunning).To(BeTrue())
+			Expect(cont.Labels[podman.LabelGeneration]).To(Equal("042"))
+			Expect(cont.Ports).To(Equal([]iopodman.ContainerPortMappings{
+				{Host_ip: "127.0.0.1", Host_port: "2201", Container_port: "22", Protocol: "tcp"},
+				{Host_port: "32768", Container_port: "5000", Protocol: "tcp"},
+			}))
+
+			volumes, err := rt.GetPrefixedVolumes("pack8s-test-vol")
+			Expect(err).To(BeNil())
+			Expect(len(volumes)).To(Equal(1))
+
+			inspect, err := rt.InspectContainer(name)
+			Expect(err).To(BeNil())
+			Expect(inspect.Config.Cmd).To(Equal([]string{"serve"}))
+			Expect(inspect.Mounts[0].Destination).To(Equal("/data"))
+			Expect(inspect.Mounts[0].RW).To(BeTrue())
+			Expect(inspect.Mounts[1].RW).To(BeFalse())
+		})
+
+		It("Should refuse duplicate names", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			_, err := rt.CreateContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should report missing containers as not found", func() {
+			_, err := rt.GetContainerState("pack8s-missing")
+			Expect(podman.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Should wait for containers to exit", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+
+			exitErr := make(chan error, 1)
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				exitErr <- rt.Exit(name, 7)
+			}()
+			rc, err := rt.WaitContainer(name, 1)
+			Expect(err).To(BeNil())
+			Expect(rc).To(Equal(int64(7)))
+			Expect(<-exitErr).To(BeNil())
+
+			state, err := rt.GetContainerState(name)
+			Expect(err).To(BeNil())
+			Expect(state.Running).To(BeFalse())
+			Expect(state.ExitCode).To(Equal(int32(7)))
+		})
+
+		It("Should stop waiting once the context is canceled", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := rt.Clone(ctx).WaitContainer(name, 1)
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Context("pods", func() {
+		It("Should publish the ports through the infra container", func() {
+			_, err := rt.CreatePod(iopodman.PodCreate{
+				Name:    "pack8s",
+				Infra:   true,
+				Publish: []string{"2201:22"},
+			})
+			Expect(err).To(BeNil())
+
+			pod := "pack8s"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Pod: &pod})
+
+			data, found, err := rt.FindPod("pack8s")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(len(data.Containersinfo)).To(Equal(2))
+
+			ports, err := rt.GetPodPorts(data)
+			Expect(err).To(BeNil())
+			Expect(ports).To(Equal([]iopodman.ContainerPortMappings{
+				{Host_port: "2201", Container_port: "22", Protocol: "tcp"},
+			}))
+
+			_, err = rt.RemovePod("pack8s", true)
+			Expect(err).To(BeNil())
+			Expect(rt.Containers()).To(BeEmpty())
+			_, found, err = rt.FindPod("pack8s")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Context("volumes", func() {
+		It("Should prune only the unused volumes", func() {
+			_, err := rt.CreateNamedVolume("pack8s-unused")
+			Expect(err).To(BeNil())
+			mounts := []string{"type=volume,source=pack8s-used,destination=/data"}
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Mount: &mounts})
+
+			Expect(rt.PruneVolumes()).To(BeNil())
+			volumes := rt.Volumes()
+			Expect(len(volumes)).To(Equal(1))
+			Expect(volumes[0].Name).To(Equal("pack8s-used"))
+		})
+	})
+
+	Context("exec", func() {
+		It("Should record the commands and run the handler", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				if call.Args[0] == "false" {
+					return &podman.ExitError{Code: 1}
+				}
+				fmt.Fprintf(opts.Stdout, "ok\n")
+				return nil
+			})
+
+			out := GinkgoWriter
+			Expect(rt.Exec(name, []string{"true"}, out)).To(BeNil())
+			err := rt.Exec(name, []string{"false"}, out)
+			_, ok := err.(*podman.ExitError)
+			Expect(ok).To(BeTrue())
+
+			Expect(rt.Execs()).To(Equal([]fakeruntime.ExecCall{
+				{Container: name, Args: []string{"true"}},
+				{Container: name, Args: []string{"false"}},
+			}))
+		})
+
+		It("Should refuse to exec in stopped containers", func() {
+			name := "pack8s-test"
+			_, err := rt.CreateContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			Expect(err).To(BeNil())
+			Expect(rt.Exec(name, []string{"true"}, GinkgoWriter)).NotTo(BeNil())
+		})
+	})
+
+	Context("logs", func() {
+		It("Should follow the logs until the context is done", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			Expect(rt.Log(name, iopodman.LogLine{Msg: "first"})).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			msgs := make(chan string, 2)
+			done := make(chan error, 1)
+			go func() {
+				done <- rt.Clone(ctx).GetContainersLogs([]string{name}, podman.LogOptions{Follow: true}, func(line iopodman.LogLine) error {
+					msgs <- line.Msg
+					return nil
+				})
+			}()
+			Eventually(msgs).Should(Receive(Equal("first")))
+
+			Expect(rt.Log(name, iopodman.LogLine{Msg: "second"})).To(Succeed())
+			Eventually(msgs).Should(Receive(Equal("second")))
+
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+		})
+
+		It("Should skip the lines logged before since", func() {
+			name := "pack8s-test"
+			runContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+			Expect(rt.Log(name, iopodman.LogLine{Msg: "old", Time: "2020-01-14T14:50:00Z"})).To(Succeed())
+			Expect(rt.Log(name, iopodman.LogLine{Msg: "new"})).To(Succeed())
+
+			since := time.Now().Add(-time.Minute)
+			msgs := []string{}
+			err := rt.GetContainersLogs([]string{name}, podman.LogOptions{Since: since}, func(line iopodman.LogLine) error {
+				msgs = append(msgs, line.Msg)
+				return nil
+			})
+			Expect(err).To(BeNil())
+			Expect(msgs).To(Equal([]string{"new"}))
+		})
+	})
+
+	Context("faults", func() {
+		It("Should fail the given method on the given object only", func() {
+			failure := fmt.Errorf("injected failure")
+			rt.Fail("CreateNamedVolume", "pack8s-bad", failure)
+
+			_, err := rt.CreateNamedVolume("pack8s-bad")
+			Expect(err).To(Equal(failure))
+			_, err = rt.CreateNamedVolume("pack8s-good")
+			Expect(err).To(BeNil())
+
+			rt.Fail("CreateNamedVolume", "pack8s-bad", nil)
+			_, err = rt.CreateNamedVolume("pack8s-bad")
+			Expect(err).To(BeNil())
+		})
+	})
+})
@@ -1,5 +1,17 @@
 package images
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
 const (
 	// NFSGaneshaImage contains the reference to NFS docker image
 	NFSGaneshaImage = "docker.io/janeczku/nfs-ganesha@sha256:17fe1813fd20d9fdfa497a26c8a2e39dd49748cd39dbb0559df7627d9bcf4c53"
@@ -11,8 +23,433 @@ const (
 	FluentdImage = "docker.io/fluent/fluentd:v1.2-debian"
 )
 
+const (
+	// EnvConfigPath overrides the path of the image configuration file
+	EnvConfigPath = "PACK8S_IMAGES"
+	// EnvMirror overrides the mirror set in the image configuration file
+	EnvMirror = "PACK8S_IMAGE_MIRROR"
+	// EnvImagePrefix, followed by the upper case role (e.g. PACK8S_IMAGE_NFS), overrides the image of that role
+	EnvImagePrefix = "PACK8S_IMAGE_"
+	// EnvPullParallelism overrides the number of images pulled at the same time
+	EnvPullParallelism = "PACK8S_PULL_PARALLELISM"
+
+	// DefaultPullParallelism is the number of images pulled at the same time, unless configured otherwise
+	DefaultPullParallelism = 3
+)
+
+const (
+	// RoleProvider is the role of the provider image. It only matters for the pull policies.
+	RoleProvider = "provider"
+	RoleRegistry = "registry"
+	RoleNFS      = "nfs"
+	RoleCeph     = "ceph"
+	RoleFluentd  = "fluentd"
+)
+
+const (
+	// SourceDefault marks the images pack8s uses out of the box
+	SourceDefault = "default"
+	// SourceMirror marks the default images, pulled from the configured mirror
+	SourceMirror = "mirror"
+	// SourceConfig marks the images set in the configuration file
+	SourceConfig = "config"
+	// SourceEnv marks the images set in the environment
+	SourceEnv = "env"
+)
+
+// Roles lists all the auxiliary image roles, in the order the images are pulled
+var Roles = []string{RoleRegistry, RoleNFS, RoleCeph, RoleFluentd}
+
 type Requests interface {
 	WantsNFS() bool
 	WantsCeph() bool
 	WantsFluentd() bool
+	// AuxImages returns the references of the auxiliary images to pull and to run
+	AuxImages() Set
+}
+
+// WantedRoles returns the roles of the auxiliary images needed to satisfy reqs, in the Roles order.
+// The registry is always needed.
+func WantedRoles(reqs Requests) []string {
+	roles := []string{RoleRegistry}
+	if reqs.WantsNFS() {
+		roles = append(roles, RoleNFS)
+	}
+	if reqs.WantsCeph() {
+		roles = append(roles, RoleCeph)
+	}
+	if reqs.WantsFluentd() {
+		roles = append(roles, RoleFluentd)
+	}
+	return roles
+}
+
+// Set maps each auxiliary image role to the image reference to use
+type Set struct {
+	Registry string `json:"registry,omitempty" yaml:"registry,omitempty"`
+	NFS      string `json:"nfs,omitempty" yaml:"nfs,omitempty"`
+	Ceph     string `json:"ceph,omitempty" yaml:"ceph,omitempty"`
+	Fluentd  string `json:"fluentd,omitempty" yaml:"fluentd,omitempty"`
+}
+
+// Defaults returns the images pack8s uses out of the box
+func Defaults() Set {
+	return Set{
+		Registry: DockerRegistryImage,
+		NFS:      NFSGaneshaImage,
+		Ceph:     CephImage,
+		Fluentd:  FluentdImage,
+	}
+}
+
+// Get returns the image of the given role, or an empty string if the role is unknown or unset
+func (s Set) Get(role string) string {
+	if ref := s.field(role); ref != nil {
+		return *ref
+	}
+	return ""
+}
+
+// Merge returns a copy of the Set with the images set in other replacing its own
+func (s Set) Merge(other Set) Set {
+	for _, role := range Roles {
+		if ref := other.Get(role); ref != "" {
+			*s.field(role) = ref
+		}
+	}
+	return s
+}
+
+func (s *Set) field(role string) *string {
+	switch role {
+	case RoleRegistry:
+		return &s.Registry
+	case RoleNFS:
+		return &s.NFS
+	case RoleCeph:
+		return &s.Ceph
+	case RoleFluentd:
+		return &s.Fluentd
+	}
+	return nil
+}
+
+// Config tells where to pull the auxiliary images from
+type Config struct {
+	// Mirror replaces the registry of the default images, e.g. "mirror.lab:5000" or "mirror.lab/dockerhub".
+	// The images explicitely set are not affected.
+	Mirror string     `yaml:"mirror,omitempty"`
+	Images Set        `yaml:"images,omitempty"`
+	Pull   PullConfig `yaml:"pull,omitempty"`
+}
+
+// Retry tells how to retry the failed pulls. The delay between attempts grows by the backoff factor,
+// up to the maximum delay. Unset fields keep their default value.
+type Retry struct {
+	Attempts int           `yaml:"attempts,omitempty"`
+	Delay    time.Duration `yaml:"delay,omitempty"`
+	Backoff  float64       `yaml:"backoff,omitempty"`
+	MaxDelay time.Duration `yaml:"maxDelay,omitempty"`
+}
+
+// DefaultRetry returns the retry parameters used unless configured otherwise
+func DefaultRetry() Retry {
+	return Retry{
+		Attempts: 4,
+		Delay:    1 * time.Second,
+		Backoff:  2,
+		MaxDelay: 6 * time.Second,
+	}
+}
+
+// Merge returns a copy of the Retry with the fields set in other replacing its own
+func (r Retry) Merge(other Retry) Retry {
+	if other.Attempts > 0 {
+		r.Attempts = other.Attempts
+	}
+	if other.Delay > 0 {
+		r.Delay = other.Delay
+	}
+	if other.Backoff > 0 {
+		r.Backoff = other.Backoff
+	}
+	if other.MaxDelay > 0 {
+		r.MaxDelay = other.MaxDelay
+	}
+	return r
+}
+
+// Delays returns the delays to wait before each attempt: the first attempt is immediate
+func (r Retry) Delays() []time.Duration {
+	delays := []time.Duration{}
+	delay := r.Delay
+	for i := 0; i < r.Attempts; i++ {
+		if i == 0 {
+			delays = append(delays, 0)
+			continue
+		}
+		if r.MaxDelay > 0 && delay > r.MaxDelay {
+			delay = r.MaxDelay
+		}
+		delays = append(delays, delay)
+		delay = time.Duration(float64(delay) * r.Backoff)
+	}
+	return delays
+}
+
+// PullPolicy tells how to pull the image of a role
+type PullPolicy struct {
+	Retry `yaml:",inline"`
+	// Optional images only cause a warning if they can't be pulled, instead of failing all the pulls
+	Optional bool `yaml:"optional,omitempty"`
+}
+
+// PullConfig tells how to pull the images
+type PullConfig struct {
+	// Parallelism is the number of images pulled at the same time
+	Parallelism int `yaml:"parallelism,omitempty"`
+	// Retry applies to all the images, unless overridden by their role
+	Retry Retry `yaml:"retry,omitempty"`
+	// Roles maps the image roles, including "provider", to their pull policy
+	Roles map[string]PullPolicy `yaml:"roles,omitempty"`
+}
+
+// DefaultPullConfig returns the pull configuration used unless configured otherwise
+func DefaultPullConfig() PullConfig {
+	return PullConfig{
+		Parallelism: DefaultPullParallelism,
+		Retry:       DefaultRetry(),
+	}
+}
+
+// Policy returns the pull policy of the image with the given role
+func (pc PullConfig) Policy(role string) PullPolicy {
+	policy := pc.Roles[role]
+	policy.Retry = DefaultRetry().Merge(pc.Retry).Merge(policy.Retry)
+	return policy
+}
+
+// Resolve returns the effective pull configuration: the default values are used for the
+// unset fields, and the parallelism set in the environment wins over the configured one.
+func (pc PullConfig) Resolve() (PullConfig, error) {
+	if pc.Parallelism == 0 {
+		pc.Parallelism = DefaultPullParallelism
+	}
+	if val, ok := os.LookupEnv(EnvPullParallelism); ok && val != "" {
+		parallelism, err := strconv.Atoi(val)
+		if err != nil {
+			return pc, fmt.Errorf("malformed %s: %v", EnvPullParallelism, err)
+		}
+		pc.Parallelism = parallelism
+	}
+	if pc.Parallelism < 1 {
+		return pc, fmt.Errorf("invalid pull parallelism %d", pc.Parallelism)
+	}
+	defaults := Defaults()
+	for role := range pc.Roles {
+		if role != RoleProvider && defaults.field(role) == nil {
+			return pc, fmt.Errorf("unknown image role %q in the pull configuration", role)
+		}
+	}
+	pc.Retry = DefaultRetry().Merge(pc.Retry)
+	return pc, nil
+}
+
+// Image is an auxiliary image, along with the place its reference comes from
+type Image struct {
+	Role      string `json:"role" yaml:"role"`
+	Reference string `json:"reference" yaml:"reference"`
+	Source    string `json:"source" yaml:"source"`
+}
+
+// ConfigPath returns the path of the image configuration file
+func ConfigPath() (string, error) {
+	if path, ok := os.LookupEnv(EnvConfigPath); ok && path != "" {
+		return path, nil
+	}
+	confDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(confDir, "pack8s", "images.yaml"), nil
+}
+
+// LoadConfig reads the image configuration from the given path. A missing file is not an error:
+// an empty configuration is returned, so the default images are used.
+func LoadConfig(path string) (Config, error) {
+	conf := Config{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return conf, err
+	}
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return conf, fmt.Errorf("malformed image configuration %s: %v", path, err)
+	}
+	return conf, nil
+}
+
+// SetConfigImages sets the given images in the configuration file at the given path, creating it if missing.
+// The rest of the configuration is kept as it is.
+func SetConfigImages(path string, imgs Set) error {
+	doc := yaml.MapSlice{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("malformed image configuration %s: %v", path, err)
+	}
+
+	var confImages yaml.MapSlice
+	idx := findKey(doc, "images")
+	if idx >= 0 {
+		if items, ok := doc[idx].Value.(yaml.MapSlice); ok {
+			confImages = items
+		}
+	} else {
+		doc = append(doc, yaml.MapItem{Key: "images"})
+		idx = len(doc) - 1
+	}
+	for _, role := range Roles {
+		ref := imgs.Get(role)
+		if ref == "" {
+			continue
+		}
+		if pos := findKey(confImages, role); pos >= 0 {
+			confImages[pos].Value = ref
+		} else {
+			confImages = append(confImages, yaml.MapItem{Key: role, Value: ref})
+		}
+	}
+	doc[idx].Value = confImages
+
+	data, err = yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, &Config{}); err != nil {
+		return fmt.Errorf("malformed image configuration %s: %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write and rename, so readers never see a partial file
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func findKey(items yaml.MapSlice, key string) int {
+	for idx, item := range items {
+		if k, ok := item.Key.(string); ok && k == key {
+			return idx
+		}
+	}
+	return -1
+}
+
+// Resolve returns the effective auxiliary images, in the Roles order. For each role, the image set
+// in the environment wins over the one in the configuration, which wins over the default one.
+func (conf Config) Resolve() []Image {
+	mirror := conf.Mirror
+	if val, ok := os.LookupEnv(EnvMirror); ok {
+		mirror = val
+	}
+
+	defaults := Defaults()
+	imgs := []Image{}
+	for _, role := range Roles {
+		img := Image{
+			Role:      role,
+			Reference: defaults.Get(role),
+			Source:    SourceDefault,
+		}
+		if mirror != "" {
+			img.Reference = WithMirror(img.Reference, mirror)
+			img.Source = SourceMirror
+		}
+		if ref := conf.Images.Get(role); ref != "" {
+			img.Reference = ref
+			img.Source = SourceConfig
+		}
+		if ref, ok := os.LookupEnv(EnvImagePrefix + strings.ToUpper(role)); ok && ref != "" {
+			img.Reference = ref
+			img.Source = SourceEnv
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+// ToSet returns the Set made of the given images
+func ToSet(imgs []Image) Set {
+	s := Set{}
+	for _, img := range imgs {
+		if ref := s.field(img.Role); ref != nil {
+			*ref = img.Reference
+		}
+	}
+	return s
+}
+
+// Load returns the effective auxiliary images, reading the configuration from its default path
+func Load() (Set, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return Set{}, err
+	}
+	conf, err := LoadConfig(path)
+	if err != nil {
+		return Set{}, err
+	}
+	return ToSet(conf.Resolve()), nil
+}
+
+// LoadPullConfig returns the effective pull configuration, reading it from the default path
+func LoadPullConfig() (PullConfig, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return PullConfig{}, err
+	}
+	conf, err := LoadConfig(path)
+	if err != nil {
+		return PullConfig{}, err
+	}
+	return conf.Pull.Resolve()
+}
+
+// WithMirror returns the image reference pointing to the given mirror instead of its registry.
+// References without an explicit registry (e.g. "fluent/fluentd") get the mirror prepended.
+func WithMirror(ref, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	items := strings.SplitN(ref, "/", 2)
+	if len(items) == 2 && isRegistry(items[0]) {
+		return mirror + "/" + items[1]
+	}
+	return mirror + "/" + ref
+}
+
+// isRegistry tells if the first component of a reference is a registry host, using the docker rules
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// LocalTag returns a reference for the given one which can be stored in image archives.
+// Archives can't hold references pinned by digest, like "repo@sha256:abc": those become "repo:sha256-abc".
+func LocalTag(ref string) string {
+	items := strings.SplitN(ref, "@", 2)
+	if len(items) != 2 {
+		return ref
+	}
+	repo := items[0]
+	// the tag, if any, is superseded by the digest
+	if idx := strings.LastIndex(repo, ":"); idx > strings.LastIndex(repo, "/") {
+		repo = repo[:idx]
+	}
+	return repo + ":" + strings.Replace(items[1], ":", "-", 1)
 }
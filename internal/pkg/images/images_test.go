@@ -0,0 +1,179 @@
+package images_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+)
+
+var _ = Describe("images", func() {
+	Context("mirror", func() {
+		It("Should replace the registry", func() {
+			Expect(images.WithMirror("docker.io/library/registry:2.7.1", "mirror.lab:5000")).To(Equal("mirror.lab:5000/library/registry:2.7.1"))
+			Expect(images.WithMirror("docker.io/fluent/fluentd:v1.2-debian", "mirror.lab/dockerhub/")).To(Equal("mirror.lab/dockerhub/fluent/fluentd:v1.2-debian"))
+		})
+
+		It("Should prepend the mirror to references without registry", func() {
+			Expect(images.WithMirror("fluent/fluentd:v1.2-debian", "mirror.lab")).To(Equal("mirror.lab/fluent/fluentd:v1.2-debian"))
+		})
+	})
+
+	Context("local tag", func() {
+		It("Should turn digests into tags", func() {
+			Expect(images.LocalTag("docker.io/ceph/daemon@sha256:939b")).To(Equal("docker.io/ceph/daemon:sha256-939b"))
+			Expect(images.LocalTag("mirror.lab:5000/ceph/daemon:v4@sha256:939b")).To(Equal("mirror.lab:5000/ceph/daemon:sha256-939b"))
+		})
+
+		It("Should keep tags", func() {
+			Expect(images.LocalTag(images.DockerRegistryImage)).To(Equal(images.DockerRegistryImage))
+		})
+	})
+
+	Context("resolve", func() {
+		var tmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "pack8s-images")
+			Expect(err).To(BeNil())
+			for _, role := range images.Roles {
+				os.Unsetenv(images.EnvImagePrefix + strings.ToUpper(role))
+			}
+			os.Unsetenv(images.EnvMirror)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("PACK8S_IMAGE_CEPH")
+			os.Unsetenv(images.EnvMirror)
+			os.RemoveAll(tmpDir)
+		})
+
+		It("Should use the defaults without configuration", func() {
+			conf, err := images.LoadConfig(filepath.Join(tmpDir, "missing.yaml"))
+			Expect(err).To(BeNil())
+			Expect(images.ToSet(conf.Resolve())).To(Equal(images.Defaults()))
+		})
+
+		It("Should reject unknown roles", func() {
+			path := filepath.Join(tmpDir, "images.yaml")
+			Expect(ioutil.WriteFile(path, []byte("images:\n  etcd: quay.io/coreos/etcd\n"), 0644)).To(Succeed())
+			_, err := images.LoadConfig(path)
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should let the environment win over the configuration", func() {
+			path := filepath.Join(tmpDir, "images.yaml")
+			data := []byte("mirror: mirror.lab:5000\nimages:\n  nfs: mirror.lab:5000/nfs-ganesha:latest\n  ceph: mirror.lab:5000/ceph:latest\n")
+			Expect(ioutil.WriteFile(path, data, 0644)).To(Succeed())
+			os.Setenv("PACK8S_IMAGE_CEPH", "localhost/ceph:devel")
+
+			conf, err := images.LoadConfig(path)
+			Expect(err).To(BeNil())
+			Expect(conf.Resolve()).To(Equal([]images.Image{
+				{Role: images.RoleRegistry, Reference: "mirror.lab:5000/library/registry:2.7.1", Source: images.SourceMirror},
+				{Role: images.RoleNFS, Reference: "mirror.lab:5000/nfs-ganesha:latest", Source: images.SourceConfig},
+				{Role: images.RoleCeph, Reference: "localhost/ceph:devel", Source: images.SourceEnv},
+				{Role: images.RoleFluentd, Reference: "mirror.lab:5000/fluent/fluentd:v1.2-debian", Source: images.SourceMirror},
+			}))
+		})
+
+		It("Should merge only the images set", func() {
+			merged := images.Defaults().Merge(images.Set{Fluentd: "localhost/fluentd:devel"})
+			Expect(merged.Fluentd).To(Equal("localhost/fluentd:devel"))
+			Expect(merged.Registry).To(Equal(images.DockerRegistryImage))
+		})
+	})
+
+	Context("pull", func() {
+		var tmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "pack8s-images")
+			Expect(err).To(BeNil())
+			os.Unsetenv(images.EnvPullParallelism)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv(images.EnvPullParallelism)
+			os.RemoveAll(tmpDir)
+		})
+
+		It("Should back off up to the maximum delay", func() {
+			Expect(images.DefaultRetry().Delays()).To(Equal([]time.Duration{0, 1 * time.Second, 2 * time.Second, 4 * time.Second}))
+			retry := images.DefaultRetry().Merge(images.Retry{Attempts: 6})
+			Expect(retry.Delays()).To(Equal([]time.Duration{0, 1 * time.Second, 2 * time.Second, 4 * time.Second, 6 * time.Second, 6 * time.Second}))
+		})
+
+		It("Should apply the role policies", func() {
+			path := filepath.Join(tmpDir, "images.yaml")
+			data := []byte("pull:\n  parallelism: 2\n  retry:\n    attempts: 2\n  roles:\n    provider:\n      attempts: 8\n      delay: 5s\n    fluentd:\n      optional: true\n")
+			Expect(ioutil.WriteFile(path, data, 0644)).To(Succeed())
+			conf, err := images.LoadConfig(path)
+			Expect(err).To(BeNil())
+			pc, err := conf.Pull.Resolve()
+			Expect(err).To(BeNil())
+			Expect(pc.Parallelism).To(Equal(2))
+
+			provider := pc.Policy(images.RoleProvider)
+			Expect(provider.Optional).To(BeFalse())
+			Expect(provider.Attempts).To(Equal(8))
+			Expect(provider.Delay).To(Equal(5 * time.Second))
+			Expect(provider.Backoff).To(Equal(images.DefaultRetry().Backoff))
+
+			fluentd := pc.Policy(images.RoleFluentd)
+			Expect(fluentd.Optional).To(BeTrue())
+			Expect(fluentd.Attempts).To(Equal(2))
+		})
+
+		It("Should set images in the configuration, keeping the rest", func() {
+			path := filepath.Join(tmpDir, "images.yaml")
+			Expect(ioutil.WriteFile(path, []byte(`mirror: mirror.lab:5000
+images:
+  nfs: mirror.lab:5000/nfs-ganesha:latest
+pull:
+  retry:
+    delay: 5s
+`), 0644)).To(BeNil())
+
+			Expect(images.SetConfigImages(path, images.Set{Ceph: "docker.io/ceph/daemon:sha256-939b"})).To(BeNil())
+
+			conf, err := images.LoadConfig(path)
+			Expect(err).To(BeNil())
+			Expect(conf.Mirror).To(Equal("mirror.lab:5000"))
+			Expect(conf.Images).To(Equal(images.Set{
+				NFS:  "mirror.lab:5000/nfs-ganesha:latest",
+				Ceph: "docker.io/ceph/daemon:sha256-939b",
+			}))
+			Expect(conf.Pull.Retry.Delay).To(Equal(5 * time.Second))
+		})
+
+		It("Should create the configuration to set images", func() {
+			path := filepath.Join(tmpDir, "pack8s", "images.yaml")
+			Expect(images.SetConfigImages(path, images.Set{NFS: "docker.io/janeczku/nfs-ganesha:sha256-17fe"})).To(BeNil())
+
+			conf, err := images.LoadConfig(path)
+			Expect(err).To(BeNil())
+			Expect(conf.Images).To(Equal(images.Set{NFS: "docker.io/janeczku/nfs-ganesha:sha256-17fe"}))
+		})
+
+		It("Should let the environment set the parallelism", func() {
+			os.Setenv(images.EnvPullParallelism, "5")
+			pc, err := images.PullConfig{Parallelism: 2}.Resolve()
+			Expect(err).To(BeNil())
+			Expect(pc.Parallelism).To(Equal(5))
+		})
+
+		It("Should reject unknown roles", func() {
+			_, err := images.PullConfig{Roles: map[string]images.PullPolicy{"etcd": {Optional: true}}}.Resolve()
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package kube
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Object is a Kubernetes object description, like the Pods and the Services generated by podman.
+// Objects are handled generically, so all the fields podman generates are preserved.
+type Object map[interface{}]interface{}
+
+// Parse reads an Object from its YAML description
+func Parse(data []byte) (Object, error) {
+	// decoding into Object would make all the nested maps Objects too
+	obj := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("malformed kubernetes object: %v", err)
+	}
+	if len(obj) == 0 {
+		return nil, fmt.Errorf("empty kubernetes object")
+	}
+	return Object(obj), nil
+}
+
+// Kind returns the kind of the Object, e.g. "Pod"
+func (obj Object) Kind() string {
+	kind, _ := obj["kind"].(string)
+	return kind
+}
+
+// Marshal returns the YAML description of the Object. The keys are sorted, so the same
+// Object always gives the same description.
+func (obj Object) Marshal() ([]byte, error) {
+	return yaml.Marshal(map[interface{}]interface{}(obj))
+}
+
+// Rename sets the name of the Object, and the app label the podman Services use to select their Pods.
+// The creation timestamp is dropped, so descriptions of different clusters can be compared.
+func (obj Object) Rename(name string) {
+	meta := obj.section("metadata")
+	meta["name"] = name
+	delete(meta, "creationTimestamp")
+
+	labels := meta.section("labels")
+	labels["app"] = name
+
+	if obj.Kind() == "Service" {
+		selector := obj.section("spec").section("selector")
+		selector["app"] = name
+	}
+}
+
+// MergePods combines the given Pods in a new one with the given name, holding all their containers, in order,
+// and all their volumes. Volumes with the same name are expected to be the same, and they are listed once.
+func MergePods(name string, pods []Object) (Object, error) {
+	return merge(name, "Pod", pods, "containers", "volumes")
+}
+
+// MergeServices combines the given Services in a new one with the given name, exposing all their ports.
+func MergeServices(name string, services []Object) (Object, error) {
+	return merge(name, "Service", services, "ports")
+}
+
+// merge combines objects of the same kind, concatenating the given list fields of their specs.
+// Items of the lists which have the same name are listed once.
+func merge(name, kind string, objs []Object, lists ...string) (Object, error) {
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("nothing to merge")
+	}
+	for _, obj := range objs {
+		if obj.Kind() != kind {
+			return nil, fmt.Errorf("cannot merge %s with %s", obj.Kind(), kind)
+		}
+	}
+
+	res := objs[0].copy()
+	res.Rename(name)
+	spec := res.section("spec")
+	for _, list := range lists {
+		items := []interface{}{}
+		seen := make(map[string]bool)
+		for _, obj := range objs {
+			objItems, _ := obj.section("spec")[list].([]interface{})
+			for _, item := range objItems {
+				if itemName := nameOf(item); itemName != "" {
+					if seen[itemName] {
+						continue
+					}
+					seen[itemName] = true
+				}
+				items = append(items, item)
+			}
+		}
+		spec[list] = items
+	}
+	return res, nil
+}
+
+// section returns the map stored under key, creating it if missing
+func (obj Object) section(key string) Object {
+	if value, ok := obj[key].(map[interface{}]interface{}); ok {
+		return Object(value)
+	}
+	value := map[interface{}]interface{}{}
+	obj[key] = value
+	return Object(value)
+}
+
+// copy returns a deep copy of the Object, so the merged objects are not modified
+func (obj Object) copy() Object {
+	return Object(copyValue(map[interface{}]interface{}(obj)).(map[interface{}]interface{}))
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			res[key] = copyValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for idx, item := range v {
+			res[idx] = copyValue(item)
+		}
+		return res
+	}
+	return value
+}
+
+func nameOf(item interface{}) string {
+	obj, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := obj["name"].(string)
+	return name
+}
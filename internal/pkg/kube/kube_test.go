@@ -0,0 +1,148 @@
+package kube_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/kube"
+)
+
+const dnsmasqPod = `# Generation of Kubernetes YAML is still under development!
+apiVersion: v1
+kind: Pod
+metadata:
+  creationTimestamp: "2020-01-01T00:00:00Z"
+  labels:
+    app: kubevirt-dnsmasq
+  name: kubevirt-dnsmasq
+spec:
+  containers:
+  - name: kubevirt-dnsmasq
+    image: docker.io/kubevirtci/k8s-1.17:latest
+    ports:
+    - containerPort: 2201
+      hostPort: 2201
+      protocol: TCP
+status: {}
+`
+
+const nodePod = `apiVersion: v1
+kind: Pod
+metadata:
+  creationTimestamp: "2020-01-01T00:00:01Z"
+  labels:
+    app: kubevirt-node01
+  name: kubevirt-node01
+spec:
+  containers:
+  - name: kubevirt-node01
+    image: docker.io/kubevirtci/k8s-1.17:latest
+    volumeMounts:
+    - mountPath: /var/run/disk
+      name: var-lib-containers-storage-volumes-kubevirt-node01-_data
+  volumes:
+  - hostPath:
+      path: /var/lib/containers/storage/volumes/kubevirt-node01/_data
+    name: var-lib-containers-storage-volumes-kubevirt-node01-_data
+status: {}
+`
+
+const dnsmasqService = `apiVersion: v1
+kind: Service
+metadata:
+  creationTimestamp: "2020-01-01T00:00:00Z"
+  labels:
+    app: kubevirt-dnsmasq
+  name: kubevirt-dnsmasq
+spec:
+  ports:
+  - name: "2201"
+    nodePort: 30001
+    port: 2201
+    protocol: TCP
+    targetPort: 0
+  selector:
+    app: kubevirt-dnsmasq
+  type: NodePort
+status:
+  loadBalancer: {}
+`
+
+var _ = Describe("kube", func() {
+	Context("parse", func() {
+		It("Should parse the podman descriptions", func() {
+			pod, err := kube.Parse([]byte(dnsmasqPod))
+			Expect(err).To(BeNil())
+			Expect(pod.Kind()).To(Equal("Pod"))
+		})
+
+		It("Should reject empty descriptions", func() {
+			_, err := kube.Parse([]byte("# just a comment\n"))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Context("merge", func() {
+		It("Should merge the containers and the volumes of more pods", func() {
+			dnsmasq, err := kube.Parse([]byte(dnsmasqPod))
+			Expect(err).To(BeNil())
+			node, err := kube.Parse([]byte(nodePod))
+			Expect(err).To(BeNil())
+
+			merged, err := kube.MergePods("kubevirt", []kube.Object{dnsmasq, node, node})
+			Expect(err).To(BeNil())
+
+			data, err := merged.Marshal()
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal(`apiVersion: v1
+kind: Pod
+metadata:
+  labels:
+    app: kubevirt
+  name: kubevirt
+spec:
+  containers:
+  - image: docker.io/kubevirtci/k8s-1.17:latest
+    name: kubevirt-dnsmasq
+    ports:
+    - containerPort: 2201
+      hostPort: 2201
+      protocol: TCP
+  - image: docker.io/kubevirtci/k8s-1.17:latest
+    name: kubevirt-node01
+    volumeMounts:
+    - mountPath: /var/run/disk
+      name: var-lib-containers-storage-volumes-kubevirt-node01-_data
+  volumes:
+  - hostPath:
+      path: /var/lib/containers/storage/volumes/kubevirt-node01/_data
+    name: var-lib-containers-storage-volumes-kubevirt-node01-_data
+status: {}
+`))
+
+			// the merged pods are untouched
+			Expect(dnsmasq["metadata"].(map[interface{}]interface{})["name"]).To(Equal("kubevirt-dnsmasq"))
+		})
+
+		It("Should select the merged pod from the merged service", func() {
+			svc, err := kube.Parse([]byte(dnsmasqService))
+			Expect(err).To(BeNil())
+
+			merged, err := kube.MergeServices("kubevirt", []kube.Object{svc})
+			Expect(err).To(BeNil())
+			spec := merged["spec"].(map[interface{}]interface{})
+			Expect(spec["selector"]).To(Equal(map[interface{}]interface{}{"app": "kubevirt"}))
+			Expect(spec["ports"]).To(HaveLen(1))
+		})
+
+		It("Should refuse to merge different kinds", func() {
+			pod, err := kube.Parse([]byte(dnsmasqPod))
+			Expect(err).To(BeNil())
+			svc, err := kube.Parse([]byte(dnsmasqService))
+			Expect(err).To(BeNil())
+
+			_, err = kube.MergePods("kubevirt", []kube.Object{pod, svc})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,261 @@
+package kubeconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the subset of a kubeconfig file pack8s needs to know about.
+// All the fields pack8s doesn't know about are preserved as they are.
+type Config struct {
+	APIVersion     string                 `yaml:"apiVersion,omitempty"`
+	Kind           string                 `yaml:"kind,omitempty"`
+	Clusters       []NamedCluster         `yaml:"clusters"`
+	Contexts       []NamedContext         `yaml:"contexts"`
+	Users          []NamedUser            `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type NamedCluster struct {
+	Name    string  `yaml:"name"`
+	Cluster Cluster `yaml:"cluster"`
+}
+
+type Cluster struct {
+	Server                   string                 `yaml:"server"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	CertificateAuthority     string                 `yaml:"certificate-authority,omitempty"`
+	InsecureSkipTLSVerify    bool                   `yaml:"insecure-skip-tls-verify,omitempty"`
+	Extra                    map[string]interface{} `yaml:",inline"`
+}
+
+type NamedContext struct {
+	Name    string  `yaml:"name"`
+	Context Context `yaml:"context"`
+}
+
+type Context struct {
+	Cluster   string                 `yaml:"cluster"`
+	User      string                 `yaml:"user"`
+	Namespace string                 `yaml:"namespace,omitempty"`
+	Extra     map[string]interface{} `yaml:",inline"`
+}
+
+type NamedUser struct {
+	Name string                 `yaml:"name"`
+	User map[string]interface{} `yaml:"user"`
+}
+
+// New returns an empty kubeconfig
+func New() *Config {
+	return &Config{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters:   []NamedCluster{},
+		Contexts:   []NamedContext{},
+		Users:      []NamedUser{},
+	}
+}
+
+func Parse(data []byte) (*Config, error) {
+	conf := New()
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("malformed kubeconfig: %v", err)
+	}
+	return conf, nil
+}
+
+// Load reads the kubeconfig from the given path. A missing file is not an error: an empty kubeconfig is returned.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func (conf *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(conf)
+}
+
+// Save writes the kubeconfig to the given path, creating the parent directories if needed.
+// The kubeconfig holds credentials, so it is readable only by the owner.
+func (conf *Config) Save(path string) error {
+	data, err := conf.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// ForCluster extracts the current context (or the only context, if no one is current) and returns a new
+// kubeconfig holding only that context, whose cluster server is replaced by the given one.
+// The context, the cluster and the user are renamed after the given name.
+// The certificate authority is kept: use VerifyServer to check the certificate of the API server is valid
+// for the new server address.
+func (conf *Config) ForCluster(name, server string) (*Config, error) {
+	ctxName := conf.CurrentContext
+	if ctxName == "" && len(conf.Contexts) == 1 {
+		ctxName = conf.Contexts[0].Name
+	}
+	ctx, ok := conf.findContext(ctxName)
+	if !ok {
+		return nil, fmt.Errorf("cannot find the context %q in kubeconfig", ctxName)
+	}
+	cluster, ok := conf.findCluster(ctx.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("cannot find the cluster %q in kubeconfig", ctx.Cluster)
+	}
+	user, ok := conf.findUser(ctx.User)
+	if !ok {
+		return nil, fmt.Errorf("cannot find the user %q in kubeconfig", ctx.User)
+	}
+
+	userName := name + "-admin"
+
+	cluster.Server = server
+
+	ctx.Cluster = name
+	ctx.User = userName
+
+	res := New()
+	res.Clusters = append(res.Clusters, NamedCluster{Name: name, Cluster: cluster})
+	res.Contexts = append(res.Contexts, NamedContext{Name: name, Context: ctx})
+	res.Users = append(res.Users, NamedUser{Name: userName, User: user})
+	res.CurrentContext = name
+	return res, nil
+}
+
+// SkipTLSVerify disables the verification of the API server certificates of all the clusters. The certificate
+// authorities are dropped, as kubectl refuses them along with insecure-skip-tls-verify.
+func (conf *Config) SkipTLSVerify() {
+	for idx := range conf.Clusters {
+		cluster := &conf.Clusters[idx].Cluster
+		cluster.CertificateAuthorityData = ""
+		cluster.CertificateAuthority = ""
+		cluster.InsecureSkipTLSVerify = true
+	}
+}
+
+// VerifyServer connects to the server of the cluster, and checks its certificate is issued by the certificate
+// authority of the cluster for the server address.
+func VerifyServer(cluster Cluster, timeout time.Duration) error {
+	if cluster.CertificateAuthorityData == "" {
+		return fmt.Errorf("no certificate authority data for %s", cluster.Server)
+	}
+	pem, err := base64.StdEncoding.DecodeString(cluster.CertificateAuthorityData)
+	if err != nil {
+		return fmt.Errorf("malformed certificate authority data: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates in the certificate authority data")
+	}
+	u, err := url.Parse(cluster.Server)
+	if err != nil {
+		return err
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "443")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, &tls.Config{
+		RootCAs:    roots,
+		ServerName: u.Hostname(),
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Merge adds all the clusters, contexts and users of the given kubeconfig to this one,
+// replacing the entries with the same name. The current context is taken from the given kubeconfig.
+func (conf *Config) Merge(other *Config) {
+	for _, cluster := range other.Clusters {
+		conf.Clusters = mergeCluster(conf.Clusters, cluster)
+	}
+	for _, ctx := range other.Contexts {
+		conf.Contexts = mergeContext(conf.Contexts, ctx)
+	}
+	for _, user := range other.Users {
+		conf.Users = mergeUser(conf.Users, user)
+	}
+	if other.CurrentContext != "" {
+		conf.CurrentContext = other.CurrentContext
+	}
+}
+
+func (conf *Config) findContext(name string) (Context, bool) {
+	for _, ctx := range conf.Contexts {
+		if ctx.Name == name {
+			return ctx.Context, true
+		}
+	}
+	return Context{}, false
+}
+
+func (conf *Config) findCluster(name string) (Cluster, bool) {
+	for _, cluster := range conf.Clusters {
+		if cluster.Name == name {
+			return cluster.Cluster, true
+		}
+	}
+	return Cluster{}, false
+}
+
+func (conf *Config) findUser(name string) (map[string]interface{}, bool) {
+	for _, user := range conf.Users {
+		if user.Name == name {
+			return user.User, true
+		}
+	}
+	return nil, false
+}
+
+func mergeCluster(clusters []NamedCluster, cluster NamedCluster) []NamedCluster {
+	for idx := range clusters {
+		if clusters[idx].Name == cluster.Name {
+			clusters[idx] = cluster
+			return clusters
+		}
+	}
+	return append(clusters, cluster)
+}
+
+func mergeContext(contexts []NamedContext, ctx NamedContext) []NamedContext {
+	for idx := range contexts {
+		if contexts[idx].Name == ctx.Name {
+			contexts[idx] = ctx
+			return contexts
+		}
+	}
+	return append(contexts, ctx)
+}
+
+func mergeUser(users []NamedUser, user NamedUser) []NamedUser {
+	for idx := range users {
+		if users[idx].Name == user.Name {
+			users[idx] = user
+			return users
+		}
+	}
+	return append(users, user)
+}
@@ -0,0 +1,153 @@
+package kubeconfig_test
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/kubeconfig"
+)
+
+const adminConf = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: Q0FEQVRB
+    server: https://192.168.66.101:6443
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: kubernetes-admin
+  name: kubernetes-admin@kubernetes
+current-context: kubernetes-admin@kubernetes
+kind: Config
+preferences: {}
+users:
+- name: kubernetes-admin
+  user:
+    client-certificate-data: Q0VSVERBVEE=
+    client-key-data: S0VZREFUQQ==
+`
+
+var _ = Describe("kubeconfig", func() {
+	Context("rewrite", func() {
+		It("Should rename the context and replace the server", func() {
+			conf, err := kubeconfig.Parse([]byte(adminConf))
+			Expect(err).To(BeNil())
+
+			res, err := conf.ForCluster("kubevirt", "https://127.0.0.1:32768")
+			Expect(err).To(BeNil())
+			Expect(res.CurrentContext).To(Equal("kubevirt"))
+			Expect(res.Contexts).To(HaveLen(1))
+			Expect(res.Contexts[0].Context.Cluster).To(Equal("kubevirt"))
+			Expect(res.Contexts[0].Context.User).To(Equal("kubevirt-admin"))
+			Expect(res.Clusters).To(HaveLen(1))
+			Expect(res.Clusters[0].Cluster.Server).To(Equal("https://127.0.0.1:32768"))
+			Expect(res.Clusters[0].Cluster.CertificateAuthorityData).To(Equal("Q0FEQVRB"))
+			Expect(res.Clusters[0].Cluster.InsecureSkipTLSVerify).To(BeFalse())
+			Expect(res.Users).To(HaveLen(1))
+			Expect(res.Users[0].Name).To(Equal("kubevirt-admin"))
+			Expect(res.Users[0].User).To(HaveKeyWithValue("client-key-data", "S0VZREFUQQ=="))
+		})
+
+		It("Should skip the TLS verification only if asked to", func() {
+			conf, err := kubeconfig.Parse([]byte(adminConf))
+			Expect(err).To(BeNil())
+
+			res, err := conf.ForCluster("kubevirt", "https://127.0.0.1:32768")
+			Expect(err).To(BeNil())
+			res.SkipTLSVerify()
+			Expect(res.Clusters[0].Cluster.CertificateAuthorityData).To(BeEmpty())
+			Expect(res.Clusters[0].Cluster.InsecureSkipTLSVerify).To(BeTrue())
+		})
+
+		It("Should verify the server certificate against the cluster CA", func() {
+			srv := httptest.NewTLSServer(http.NotFoundHandler())
+			defer srv.Close()
+			caData := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: srv.Certificate().Raw,
+			}))
+			port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+			cluster := kubeconfig.Cluster{
+				Server:                   srv.URL,
+				CertificateAuthorityData: caData,
+			}
+			Expect(kubeconfig.VerifyServer(cluster, 5*time.Second)).To(Succeed())
+
+			// the certificate is not issued for this name
+			cluster.Server = fmt.Sprintf("https://localhost:%d", port)
+			Expect(kubeconfig.VerifyServer(cluster, 5*time.Second)).NotTo(Succeed())
+		})
+
+		It("Should fail if the context is missing", func() {
+			conf, err := kubeconfig.Parse([]byte(adminConf))
+			Expect(err).To(BeNil())
+			conf.CurrentContext = "missing"
+
+			_, err = conf.ForCluster("kubevirt", "https://127.0.0.1:32768")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("merge", func() {
+		It("Should keep clusters side by side", func() {
+			conf, err := kubeconfig.Parse([]byte(adminConf))
+			Expect(err).To(BeNil())
+
+			first, err := conf.ForCluster("first", "https://127.0.0.1:32768")
+			Expect(err).To(BeNil())
+			second, err := conf.ForCluster("second", "https://127.0.0.1:32769")
+			Expect(err).To(BeNil())
+
+			merged := kubeconfig.New()
+			merged.Merge(first)
+			merged.Merge(second)
+			Expect(merged.CurrentContext).To(Equal("second"))
+			Expect(merged.Contexts).To(HaveLen(2))
+			Expect(merged.Clusters).To(HaveLen(2))
+			Expect(merged.Users).To(HaveLen(2))
+
+			updated, err := conf.ForCluster("first", "https://127.0.0.1:40000")
+			Expect(err).To(BeNil())
+			merged.Merge(updated)
+			Expect(merged.CurrentContext).To(Equal("first"))
+			Expect(merged.Clusters).To(HaveLen(2))
+			Expect(merged.Clusters[0].Cluster.Server).To(Equal("https://127.0.0.1:40000"))
+		})
+
+		It("Should preserve unknown fields", func() {
+			tmpDir, err := ioutil.TempDir("", "pack8s-kubeconfig")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(tmpDir)
+
+			path := filepath.Join(tmpDir, "config")
+			conf, err := kubeconfig.Parse([]byte(adminConf))
+			Expect(err).To(BeNil())
+			conf.Extra["preferences"] = map[string]interface{}{"colors": true}
+			Expect(conf.Save(path)).To(BeNil())
+
+			loaded, err := kubeconfig.Load(path)
+			Expect(err).To(BeNil())
+			Expect(loaded.Extra).To(HaveKey("preferences"))
+			Expect(loaded.Clusters[0].Cluster.CertificateAuthorityData).To(Equal("Q0FEQVRB"))
+		})
+
+		It("Should load a missing file as empty", func() {
+			conf, err := kubeconfig.Load("/nonexistent/pack8s/kubeconfig")
+			Expect(err).To(BeNil())
+			Expect(conf.Contexts).To(BeEmpty())
+		})
+	})
+})
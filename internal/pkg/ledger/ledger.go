@@ -1,8 +1,10 @@
 package ledger
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"sync"
 
 	logger "github.com/apsdehal/go-logger"
 
@@ -10,86 +12,240 @@ import (
 	"github.com/fromanirh/pack8s/iopodman"
 )
 
+const (
+	// StopTimeout is the grace period, in seconds, given to containers on rollback
+	StopTimeout int64 = 5
+)
+
+// Ledger keeps track of all the resources created while bringing up a cluster,
+// and removes them all if the process fails or is interrupted.
+// A Ledger can be used concurrently by more goroutines: the creations are serialized,
+// so the resources are tracked in the order they are created.
 type Ledger struct {
-	hnd        *podman.Handle
-	containers chan string
+	hnd        podman.Runtime
+	hndLock    *sync.Mutex
+	containers chan iopodman.Container
 	volumes    chan string
-	Done       chan error
+	pods       chan string
+	done       chan error
+	finished   chan struct{}
+	errWriter  io.Writer
 	log        *logger.Logger
 }
 
-func NewLedger(hnd *podman.Handle, errWriter io.Writer, log *logger.Logger) Ledger {
-	containers := make(chan string)
-	volumes := make(chan string)
-	done := make(chan error)
+func NewLedger(hnd podman.Runtime, errWriter io.Writer, log *logger.Logger) Ledger {
+	ld := Ledger{
+		hnd:        hnd,
+		hndLock:    &sync.Mutex{},
+		containers: make(chan iopodman.Container),
+		volumes:    make(chan string),
+		pods:       make(chan string),
+		done:       make(chan error),
+		finished:   make(chan struct{}),
+		errWriter:  errWriter,
+		log:        log,
+	}
 
 	go func() {
-		createdContainers := []string{}
+		defer close(ld.finished)
+
+		createdContainers := []iopodman.Container{}
 		createdVolumes := []string{}
+		createdPods := []string{}
 
 		for {
 			select {
-			case container := <-containers:
+			case container := <-ld.containers:
 				createdContainers = append(createdContainers, container)
-			case volume := <-volumes:
+			case volume := <-ld.volumes:
 				createdVolumes = append(createdVolumes, volume)
-			case err := <-done:
+			case pod := <-ld.pods:
+				createdPods = append(createdPods, pod)
+			case err := <-ld.done:
 				if err != nil {
-					/*
-						for _, c := range createdContainers {
-							name, err := hnd.RemoveContainer(c, true, false)
-							if err == nil {
-								fmt.Printf("removed container: %v (%v)\n", name, c)
-							} else {
-								fmt.Fprintf(errWriter, "error removing container %v: %v\n", c, err)
-							}
-						}
-					*/
-					for _, v := range createdVolumes {
-						//err := conn.VolumeRemove(ctx, v, true)
-						fmt.Printf("volume: %v - can't do it yet", v)
-						if err != nil {
-							fmt.Fprintf(errWriter, "%v\n", err)
-						}
-					}
+					ld.log.Warningf("rolling back: %v", err)
+					ld.rollback(createdContainers, createdPods, createdVolumes)
 				}
+				return
 			}
 		}
 	}()
 
 	defer log.Infof("ledger ready")
-	return Ledger{
-		hnd:        hnd,
-		log:        log,
-		containers: containers,
-		volumes:    volumes,
-		Done:       done,
+	return ld
+}
+
+// Close stops the tracking. If err is not nil, all the tracked resources are removed,
+// newest first. Close blocks until the cleanup, if any, is finished.
+// It is safe to call Close more than once, and from more than one goroutine:
+// only the first call matters, all the others just wait for the cleanup to finish.
+func (ld Ledger) Close(err error) {
+	select {
+	case ld.done <- err:
+	case <-ld.finished:
 	}
+	<-ld.finished
+}
+
+// Finished returns a channel which is closed once the ledger stopped tracking resources,
+// and the cleanup, if any, is finished.
+func (ld Ledger) Finished() <-chan struct{} {
+	return ld.finished
 }
 
 func (ld Ledger) MakeVolume(name string) (string, error) {
+	if ld.isClosed() {
+		return "", fmt.Errorf("ledger closed: refusing to create volume %s", name)
+	}
+
+	ld.hndLock.Lock()
+	defer ld.hndLock.Unlock()
+
 	volName, err := ld.hnd.CreateNamedVolume(name)
 	if err != nil {
 		return volName, err
 	}
 
-	ld.volumes <- volName
+	select {
+	case ld.volumes <- volName:
+	case <-ld.finished:
+		ld.discard("volume "+volName, func(hnd podman.Runtime) error {
+			return hnd.RemoveVolumes([]iopodman.Volume{{Name: volName}})
+		})
+		return volName, fmt.Errorf("ledger closed: volume %s is not tracked, removed", volName)
+	}
 	ld.log.Infof("tracked volume %s", volName)
 	return volName, err
 }
 
+func (ld Ledger) MakePod(conf iopodman.PodCreate) (string, error) {
+	if ld.isClosed() {
+		return "", fmt.Errorf("ledger closed: refusing to create pod %s", conf.Name)
+	}
+
+	ld.hndLock.Lock()
+	defer ld.hndLock.Unlock()
+
+	podID, err := ld.hnd.CreatePod(conf)
+	if err != nil {
+		return podID, err
+	}
+
+	select {
+	case ld.pods <- podID:
+	case <-ld.finished:
+		ld.discard("pod "+podID, func(hnd podman.Runtime) error {
+			_, err := hnd.RemovePod(podID, true)
+			return err
+		})
+		return podID, fmt.Errorf("ledger closed: pod %s is not tracked, removed", podID)
+	}
+	ld.log.Infof("tracked pod %s", podID)
+	return podID, err
+}
+
 func (ld Ledger) RunContainer(conf iopodman.Create) (string, error) {
-	ld.log.Debugf("running container...")
-	contID, err := ld.hnd.CreateContainer(conf)
+	name := ""
+	if conf.Name != nil {
+		name = *conf.Name
+	}
+	if ld.isClosed() {
+		return "", fmt.Errorf("ledger closed: refusing to run container %s", name)
+	}
+
+	contID, err := ld.createContainer(conf, name)
 	if err != nil {
 		return contID, err
 	}
 
-	ld.containers <- contID
-	ld.log.Infof("tracked container %s", contID)
+	// once tracked, a container failing to start is removed by the rollback
 	if _, err := ld.hnd.StartContainer(contID); err != nil {
 		return contID, err
 	}
 	ld.log.Infof("started container %s", contID)
 	return contID, nil
 }
+
+// createContainer creates and tracks a container. Starting it may take long, so it is left
+// to the caller, not to hold the lock in the meantime.
+func (ld Ledger) createContainer(conf iopodman.Create, name string) (string, error) {
+	ld.hndLock.Lock()
+	defer ld.hndLock.Unlock()
+
+	ld.log.Debugf("running container...")
+	contID, err := ld.hnd.CreateContainer(conf)
+	if err != nil {
+		return contID, err
+	}
+
+	select {
+	case ld.containers <- iopodman.Container{Id: contID, Names: name}:
+	case <-ld.finished:
+		ld.discard("container "+contID, func(hnd podman.Runtime) error {
+			_, err := hnd.RemoveContainer(iopodman.Container{Id: contID, Names: name}, true, false)
+			return err
+		})
+		return contID, fmt.Errorf("ledger closed: container %s is not tracked, removed", contID)
+	}
+	ld.log.Infof("tracked container %s", contID)
+	return contID, nil
+}
+
+// discard removes a resource created while the ledger was closing, which the rollback doesn't know about.
+func (ld Ledger) discard(what string, remove func(hnd podman.Runtime) error) {
+	// like in rollback, the context of the main handle may be already canceled
+	hnd := ld.hnd.Clone(context.Background())
+	defer hnd.Close()
+
+	ld.log.Noticef("removing untracked %s", what)
+	if err := remove(hnd); err != nil {
+		fmt.Fprintf(ld.errWriter, "error removing untracked %s: %v\n", what, err)
+	}
+}
+
+func (ld Ledger) isClosed() bool {
+	select {
+	case <-ld.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ld Ledger) rollback(containers []iopodman.Container, pods, volumes []string) {
+	// the context of the main handle may be already canceled (e.g. on interrupt), so we
+	// need our own.
+	hnd := ld.hnd.Clone(context.Background())
+	defer hnd.Close()
+
+	for idx := len(containers) - 1; idx >= 0; idx-- {
+		cont := containers[idx]
+		ld.log.Noticef("stopping container: %s (%s)", cont.Names, cont.Id)
+		if _, err := hnd.StopContainer(cont.Id, StopTimeout); err != nil {
+			fmt.Fprintf(ld.errWriter, "error stopping container %v (%v): %v\n", cont.Names, cont.Id, err)
+			// keep going: we will force the removal anyway
+		}
+
+		ld.log.Noticef("removing container: %s (%s)", cont.Names, cont.Id)
+		if _, err := hnd.RemoveContainer(cont, true, false); err != nil {
+			fmt.Fprintf(ld.errWriter, "error removing container %v (%v): %v\n", cont.Names, cont.Id, err)
+		}
+	}
+
+	// the containers are already gone, the pods hold only their infra containers
+	for idx := len(pods) - 1; idx >= 0; idx-- {
+		pod := pods[idx]
+		ld.log.Noticef("removing pod: %s", pod)
+		if _, err := hnd.RemovePod(pod, true); err != nil {
+			fmt.Fprintf(ld.errWriter, "error removing pod %v: %v\n", pod, err)
+		}
+	}
+
+	for idx := len(volumes) - 1; idx >= 0; idx-- {
+		vol := volumes[idx]
+		if err := hnd.RemoveVolumes([]iopodman.Volume{{Name: vol}}); err != nil {
+			fmt.Fprintf(ld.errWriter, "error removing volume %v: %v\n", vol, err)
+		}
+	}
+	ld.log.Noticef("rollback done: containers=%d pods=%d volumes=%d", len(containers), len(pods), len(volumes))
+}
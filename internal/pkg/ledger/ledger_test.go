@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"strings"
 
 	logger "github.com/apsdehal/go-logger"
 
+	"github.com/fromanirh/pack8s/internal/pkg/fakeruntime"
 	"github.com/fromanirh/pack8s/internal/pkg/images"
 	"github.com/fromanirh/pack8s/internal/pkg/ledger"
 	"github.com/fromanirh/pack8s/internal/pkg/podman"
@@ -30,12 +34,22 @@ var _ = Describe("ledger", func() {
 	hnd, _ := podman.NewHandle(ctx, "", log)
 
 	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-	ldgr := ledger.NewLedger(hnd, w, log)
+	var ldgr ledger.Ledger
 
-	defer func() {
-		ldgr.Done <- nil
-	}()
+	BeforeEach(func() {
+		if _, err := os.Stat(strings.TrimPrefix(podman.DefaultSocket, "unix:")); err != nil {
+			Skip("podman socket not available: " + podman.DefaultSocket)
+		}
+		buf.Reset()
+		ldgr = ledger.NewLedger(hnd, bufio.NewWriter(&buf), log)
+	})
+
+	AfterEach(func() {
+		// skipped specs have no ledger
+		if ldgr.Finished() != nil {
+			ldgr.Close(nil)
+		}
+	})
 
 	Context("create new volume", func() {
 		It("Should create new volume without any error", func() {
@@ -85,4 +99,222 @@ var _ = Describe("ledger", func() {
 
 	})
 
+	Context("rollback", func() {
+		It("Should remove all the tracked resources on failure", func() {
+			volume, err := ldgr.MakeVolume("pack8s-test-rollback")
+			Expect(err).To(BeNil())
+			Expect(volume).NotTo(Equal(""))
+
+			name := "pack8s-test-rollback"
+			id, err := ldgr.RunContainer(iopodman.Create{
+				Args: []string{images.DockerRegistryImage},
+				Name: &name,
+			})
+			Expect(err).To(BeNil())
+			Expect(id).NotTo(Equal(""))
+
+			ldgr.Close(fmt.Errorf("simulated failure"))
+
+			containers, err := hnd.GetPrefixedContainers(name)
+			Expect(err).To(BeNil())
+			Expect(len(containers)).To(Equal(0))
+
+			volumes, err := hnd.GetPrefixedVolumes("pack8s-test-rollback")
+			Expect(err).To(BeNil())
+			Expect(len(volumes)).To(Equal(0))
+		})
+
+		It("Should refuse to track resources once closed", func() {
+			ldgr.Close(nil)
+
+			_, err := ldgr.MakeVolume("pack8s-test-closed")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+})
+
+// closingRuntime closes the ledger right after each creation, like an interrupt landing
+// while the resource is being created.
+type closingRuntime struct {
+	*fakeruntime.Runtime
+	ldgr *ledger.Ledger
+}
+
+func (cr closingRuntime) CreateNamedVolume(name string) (string, error) {
+	defer cr.ldgr.Close(fmt.Errorf("interrupted"))
+	return cr.Runtime.CreateNamedVolume(name)
+}
+
+func (cr closingRuntime) CreatePod(conf iopodman.PodCreate) (string, error) {
+	defer cr.ldgr.Close(fmt.Errorf("interrupted"))
+	return cr.Runtime.CreatePod(conf)
+}
+
+func (cr closingRuntime) CreateContainer(conf iopodman.Create) (string, error) {
+	defer cr.ldgr.Close(fmt.Errorf("interrupted"))
+	return cr.Runtime.CreateContainer(conf)
+}
+
+// slowRuntime blocks starting the given container until release is closed
+type slowRuntime struct {
+	*fakeruntime.Runtime
+	name    string
+	release chan struct{}
+}
+
+func (sr slowRuntime) StartContainer(id string) (string, error) {
+	for _, cont := range sr.Containers() {
+		if cont.Id == id && cont.Names == sr.name {
+			<-sr.release
+		}
+	}
+	return sr.Runtime.StartContainer(id)
+}
+
+var _ = Describe("ledger on a fake runtime", func() {
+	log := NewLogger()
+
+	var rt *fakeruntime.Runtime
+	var buf bytes.Buffer
+	var ldgr ledger.Ledger
+
+	BeforeEach(func() {
+		rt = fakeruntime.New()
+		buf.Reset()
+		ldgr = ledger.NewLedger(rt, &buf, log)
+	})
+
+	AfterEach(func() {
+		ldgr.Close(nil)
+	})
+
+	It("Should keep the resources on success", func() {
+		_, err := ldgr.MakeVolume("pack8s-test")
+		Expect(err).To(BeNil())
+		name := "pack8s-test"
+		_, err = ldgr.RunContainer(iopodman.Create{
+			Args: []string{images.DockerRegistryImage},
+			Name: &name,
+		})
+		Expect(err).To(BeNil())
+
+		ldgr.Close(nil)
+
+		Expect(len(rt.Containers())).To(Equal(1))
+		Expect(len(rt.Volumes())).To(Equal(1))
+	})
+
+	It("Should remove all the tracked resources on failure", func() {
+		_, err := ldgr.MakePod(iopodman.PodCreate{
+			Name:  "pack8s-test",
+			Infra: true,
+		})
+		Expect(err).To(BeNil())
+		_, err = ldgr.MakeVolume("pack8s-test")
+		Expect(err).To(BeNil())
+		pod := "pack8s-test"
+		for _, name := range []string{"pack8s-test-dnsmasq", "pack8s-test-node01"} {
+			name := name
+			_, err = ldgr.RunContainer(iopodman.Create{
+				Args: []string{images.DockerRegistryImage},
+				Name: &name,
+				Pod:  &pod,
+			})
+			Expect(err).To(BeNil())
+		}
+
+		ldgr.Close(fmt.Errorf("simulated failure"))
+
+		Expect(rt.Containers()).To(BeEmpty())
+		Expect(rt.Pods()).To(BeEmpty())
+		Expect(rt.Volumes()).To(BeEmpty())
+		Expect(buf.String()).To(BeEmpty())
+	})
+
+	It("Should remove the containers which failed to start", func() {
+		rt.Fail("StartContainer", "pack8s-test", fmt.Errorf("simulated failure"))
+		name := "pack8s-test"
+		_, err := ldgr.RunContainer(iopodman.Create{
+			Args: []string{images.DockerRegistryImage},
+			Name: &name,
+		})
+		Expect(err).NotTo(BeNil())
+
+		ldgr.Close(err)
+
+		Expect(rt.Containers()).To(BeEmpty())
+	})
+
+	It("Should keep rolling back past the failures", func() {
+		_, err := ldgr.MakeVolume("pack8s-test")
+		Expect(err).To(BeNil())
+		name := "pack8s-test"
+		_, err = ldgr.RunContainer(iopodman.Create{
+			Args: []string{images.DockerRegistryImage},
+			Name: &name,
+		})
+		Expect(err).To(BeNil())
+		rt.Fail("RemoveContainer", "pack8s-test", fmt.Errorf("simulated removal failure"))
+
+		ldgr.Close(fmt.Errorf("simulated failure"))
+
+		Expect(len(rt.Containers())).To(Equal(1))
+		Expect(rt.Volumes()).To(BeEmpty())
+		Expect(buf.String()).To(ContainSubstring("simulated removal failure"))
+	})
+
+	It("Should remove the resources created while closing", func() {
+		// each closingRuntime closes the ledger using it
+		useClosingRuntime := func() {
+			ldgr.Close(nil)
+			ldgr = ledger.NewLedger(closingRuntime{Runtime: rt, ldgr: &ldgr}, &buf, log)
+		}
+
+		useClosingRuntime()
+		_, err := ldgr.MakeVolume("pack8s-test")
+		Expect(err).NotTo(BeNil())
+		Expect(rt.Volumes()).To(BeEmpty())
+
+		useClosingRuntime()
+		_, err = ldgr.MakePod(iopodman.PodCreate{Name: "pack8s-test", Infra: true})
+		Expect(err).NotTo(BeNil())
+		Expect(rt.Pods()).To(BeEmpty())
+
+		useClosingRuntime()
+		name := "pack8s-test"
+		_, err = ldgr.RunContainer(iopodman.Create{
+			Args: []string{images.DockerRegistryImage},
+			Name: &name,
+		})
+		Expect(err).NotTo(BeNil())
+		Expect(rt.Containers()).To(BeEmpty())
+		Expect(buf.String()).To(BeEmpty())
+	})
+
+	It("Should not wait for the containers being started to create the others", func() {
+		ldgr.Close(nil)
+		slowName := "pack8s-test-slow"
+		release := make(chan struct{})
+		ldgr = ledger.NewLedger(slowRuntime{Runtime: rt, name: slowName, release: release}, &buf, log)
+
+		slowErr := make(chan error, 1)
+		go func() {
+			_, err := ldgr.RunContainer(iopodman.Create{Args: []string{images.DockerRegistryImage}, Name: &slowName})
+			slowErr <- err
+		}()
+		Eventually(rt.Containers).Should(HaveLen(1))
+
+		name := "pack8s-test"
+		fastErr := make(chan error, 1)
+		go func() {
+			_, err := ldgr.RunContainer(iopodman.Create{Args: []string{images.DockerRegistryImage}, Name: &name})
+			fastErr <- err
+		}()
+		Eventually(fastErr).Should(Receive(BeNil()))
+		Consistently(slowErr).ShouldNot(Receive())
+
+		close(release)
+		Eventually(slowErr).Should(Receive(BeNil()))
+	})
 })
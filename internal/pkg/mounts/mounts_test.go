@@ -31,12 +31,16 @@ var _ = Describe("mounts", func() {
 	hnd, _ := podman.NewHandle(ctx, "", log)
 
 	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-	ldgr := ledger.NewLedger(hnd, w, log)
+	var ldgr ledger.Ledger
 
-	defer func() {
-		ldgr.Done <- nil
-	}()
+	BeforeEach(func() {
+		buf.Reset()
+		ldgr = ledger.NewLedger(hnd, bufio.NewWriter(&buf), log)
+	})
+
+	AfterEach(func() {
+		ldgr.Close(nil)
+	})
 
 	Context("mounts", func() {
 		It("Should mount volume to container", func() {
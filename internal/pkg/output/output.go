@@ -0,0 +1,51 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatText is the default, human-friendly, output format. Each command has its own text output.
+	FormatText = "text"
+	// FormatJSON is the JSON output format
+	FormatJSON = "json"
+	// FormatYAML is the YAML output format
+	FormatYAML = "yaml"
+)
+
+func IsKnownFormat(format string) bool {
+	switch format {
+	case FormatText, FormatJSON, FormatYAML:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsStructured tells if the format is a machine-readable one
+func IsStructured(format string) bool {
+	return format == FormatJSON || format == FormatYAML
+}
+
+// Print writes the given value to the writer using the given structured format.
+func Print(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case FormatYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unsupported structured output format: %s", format)
+	}
+}
@@ -0,0 +1,48 @@
+package output_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/output"
+)
+
+type result struct {
+	Name  string `json:"name" yaml:"name"`
+	Value int    `json:"value" yaml:"value"`
+}
+
+var _ = Describe("output", func() {
+	Context("formats", func() {
+		It("Should know the supported formats", func() {
+			for _, format := range []string{output.FormatText, output.FormatJSON, output.FormatYAML} {
+				Expect(output.IsKnownFormat(format)).To(Equal(true))
+			}
+			Expect(output.IsKnownFormat("xml")).To(Equal(false))
+			Expect(output.IsStructured(output.FormatText)).To(Equal(false))
+		})
+	})
+
+	Context("print", func() {
+		It("Should print JSON", func() {
+			var buf bytes.Buffer
+			err := output.Print(&buf, output.FormatJSON, map[string]int{"ssh": 49153})
+			Expect(err).To(BeNil())
+			Expect(buf.String()).To(MatchJSON(`{"ssh": 49153}`))
+		})
+
+		It("Should print YAML", func() {
+			var buf bytes.Buffer
+			err := output.Print(&buf, output.FormatYAML, result{Name: "ssh", Value: 2201})
+			Expect(err).To(BeNil())
+			Expect(buf.String()).To(Equal("name: ssh\nvalue: 2201\n"))
+		})
+
+		It("Should reject the text format", func() {
+			var buf bytes.Buffer
+			Expect(output.Print(&buf, output.FormatText, result{})).NotTo(BeNil())
+		})
+	})
+})
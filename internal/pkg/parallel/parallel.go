@@ -0,0 +1,64 @@
+package parallel
+
+import (
+	"context"
+	"sync"
+)
+
+// Task is a unit of work which can run concurrently with others.
+// A Task should return as soon as possible once its context is canceled.
+type Task func(ctx context.Context) error
+
+// Run runs all the given tasks concurrently, and waits for all of them to finish.
+// As soon as one task fails, the context of all the others is canceled.
+// Returns the error of the first task which failed, if any.
+func Run(ctx context.Context, tasks ...Task) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	wg.Add(len(tasks))
+	for _, task := range tasks {
+		go func(task Task) {
+			defer wg.Done()
+			if err := task(ctx); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(task)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// RunLimited is like Run, but at most limit tasks run at the same time.
+// A limit lower than one means no limit.
+func RunLimited(ctx context.Context, limit int, tasks ...Task) error {
+	if limit < 1 || limit >= len(tasks) {
+		return Run(ctx, tasks...)
+	}
+
+	slots := make(chan struct{}, limit)
+	limited := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		task := task
+		limited = append(limited, func(ctx context.Context) error {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-slots }()
+			// the slot may have been freed by a failed task
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return task(ctx)
+		})
+	}
+	return Run(ctx, limited...)
+}
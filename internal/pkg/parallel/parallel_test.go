@@ -0,0 +1,103 @@
+package parallel_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/parallel"
+)
+
+var _ = Describe("parallel", func() {
+	Context("run", func() {
+		It("Should run all the tasks", func() {
+			var count int32
+			tasks := []parallel.Task{}
+			for i := 0; i < 8; i++ {
+				tasks = append(tasks, func(ctx context.Context) error {
+					atomic.AddInt32(&count, 1)
+					return nil
+				})
+			}
+			err := parallel.Run(context.Background(), tasks...)
+			Expect(err).To(BeNil())
+			Expect(atomic.LoadInt32(&count)).To(Equal(int32(8)))
+		})
+
+		It("Should cancel the other tasks on failure", func() {
+			failure := fmt.Errorf("task failed")
+			var canceled int32
+			err := parallel.Run(context.Background(),
+				func(ctx context.Context) error {
+					return failure
+				},
+				func(ctx context.Context) error {
+					select {
+					case <-ctx.Done():
+						atomic.AddInt32(&canceled, 1)
+						return ctx.Err()
+					case <-time.After(5 * time.Second):
+						return nil
+					}
+				},
+			)
+			Expect(err).To(Equal(failure))
+			Expect(atomic.LoadInt32(&canceled)).To(Equal(int32(1)))
+		})
+
+		It("Should succeed with no tasks", func() {
+			Expect(parallel.Run(context.Background())).To(BeNil())
+		})
+	})
+
+	Context("run limited", func() {
+		It("Should run at most limit tasks at the same time", func() {
+			var running, maxRunning, count int32
+			tasks := []parallel.Task{}
+			for i := 0; i < 8; i++ {
+				tasks = append(tasks, func(ctx context.Context) error {
+					cur := atomic.AddInt32(&running, 1)
+					for {
+						prev := atomic.LoadInt32(&maxRunning)
+						if cur <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, cur) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+					atomic.AddInt32(&running, -1)
+					atomic.AddInt32(&count, 1)
+					return nil
+				})
+			}
+			err := parallel.RunLimited(context.Background(), 3, tasks...)
+			Expect(err).To(BeNil())
+			Expect(atomic.LoadInt32(&count)).To(Equal(int32(8)))
+			Expect(atomic.LoadInt32(&maxRunning)).To(BeNumerically("<=", 3))
+		})
+
+		It("Should return the failure of a task", func() {
+			failure := fmt.Errorf("task failed")
+			tasks := []parallel.Task{
+				func(ctx context.Context) error {
+					return failure
+				},
+			}
+			for i := 0; i < 4; i++ {
+				tasks = append(tasks, func(ctx context.Context) error {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(10 * time.Millisecond):
+						return nil
+					}
+				})
+			}
+			err := parallel.RunLimited(context.Background(), 1, tasks...)
+			Expect(err).To(Equal(failure))
+		})
+	})
+})
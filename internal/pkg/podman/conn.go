@@ -0,0 +1,222 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+)
+
+const (
+	// maxIdleConns is the number of connections kept open for the next calls
+	maxIdleConns = 4
+)
+
+// DefaultReconnect returns how the Handle reconnects to podman, unless configured otherwise
+func DefaultReconnect() images.Retry {
+	return images.Retry{
+		Attempts: 6,
+		Delay:    250 * time.Millisecond,
+		Backoff:  2,
+		MaxDelay: 4 * time.Second,
+	}
+}
+
+// ConnectionError is returned when the connection to podman broke during a call which can't be safely
+// repeated: the call may, or may not, have been carried out.
+type ConnectionError struct {
+	Method string
+	Err    error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("connection to podman lost during %s, the outcome is unknown: %v", e.Method, e.Err)
+}
+
+// callKind tells how a call can be recovered if the connection breaks
+type callKind int
+
+const (
+	// idempotent calls can be repeated, so they are retried on a new connection
+	idempotent callKind = iota
+	// once calls change the state of podman, so they are retried only if podman can't have received them
+	once
+	// upgrade calls are once calls taking over the connection, which can't be reused
+	upgrade
+)
+
+// sendError wraps the errors occurred before podman could receive the call
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+// unsent marks the given error as occurred before podman could receive the call, so the call can be retried
+func unsent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &sendError{err: err}
+}
+
+// isBrokenConnection tells if the error means the connection to podman is gone, e.g. because podman restarted
+func isBrokenConnection(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	switch err {
+	case syscall.EPIPE, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED:
+		return true
+	}
+	return false
+}
+
+// connPool holds the idle connections to podman. A varlink connection carries one call at a time,
+// so each call takes a connection of its own, and gives it back once done.
+type connPool struct {
+	lock sync.Mutex
+	idle []*varlink.Connection
+}
+
+func newConnPool() *connPool {
+	return &connPool{}
+}
+
+// get returns an idle connection, or nil if there are none
+func (p *connPool) get() *varlink.Connection {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+func (p *connPool) put(conn *varlink.Connection) {
+	p.lock.Lock()
+	if len(p.idle) < maxIdleConns {
+		p.idle = append(p.idle, conn)
+		conn = nil
+	}
+	p.lock.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// close closes all the idle connections. The pool can be used again later.
+func (p *connPool) close() error {
+	p.lock.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.lock.Unlock()
+
+	var err error
+	for _, conn := range idle {
+		if closeErr := conn.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// stringReceiver receives the reply of the calls returning a string
+type stringReceiver func(ctx context.Context) (string, uint64, error)
+
+// callOnce runs the given once call, replying with a string, sent by send
+func (hnd *Handle) callOnce(method string, send func(conn *varlink.Connection) (stringReceiver, error)) (string, error) {
+	var ret string
+	err := hnd.call(method, once, func(conn *varlink.Connection) error {
+		receive, err := send(conn)
+		if err != nil {
+			return unsent(err)
+		}
+		ret, _, err = receive(hnd.ctx)
+		return err
+	})
+	return ret, err
+}
+
+// connect returns an idle connection, or a new one. Upgrade calls always get a new one.
+func (hnd *Handle) connect(kind callKind) (*varlink.Connection, error) {
+	if kind != upgrade {
+		if conn := hnd.pool.get(); conn != nil {
+			return conn, nil
+		}
+	}
+	conn, err := varlink.NewConnection(hnd.ctx, hnd.socket)
+	if err != nil {
+		return nil, unsent(err)
+	}
+	hnd.log.Debugf("connected to %s", hnd.socket)
+	return conn, nil
+}
+
+// call runs fn on a connection of its own, so the Handle can be used concurrently. If the connection breaks,
+// fn runs again on a new connection, waiting more and more between the attempts, as long as the call
+// is idempotent, or podman can't have received it: fn must mark these errors using unsent.
+// The calls which broke the connection after being sent fail with a *ConnectionError.
+func (hnd *Handle) call(method string, kind callKind, fn func(conn *varlink.Connection) error) error {
+	var err error
+	for idx, delay := range hnd.reconnectRetry.Delays() {
+		select {
+		case <-time.After(delay):
+		case <-hnd.ctx.Done():
+			return hnd.ctx.Err()
+		}
+		if idx > 0 {
+			hnd.log.Infof("attempt #%d to call %s", idx, method)
+		}
+
+		var conn *varlink.Connection
+		conn, err = hnd.connect(kind)
+		if err == nil {
+			err = fn(conn)
+			if err == nil && kind != upgrade {
+				hnd.pool.put(conn)
+				return nil
+			}
+			conn.Close()
+			if err == nil {
+				return nil
+			}
+		}
+
+		if hnd.ctx.Err() != nil {
+			return hnd.ctx.Err()
+		}
+		sendErr, isUnsent := err.(*sendError)
+		if isUnsent {
+			err = sendErr.err
+		}
+		if !isBrokenConnection(err) {
+			return err
+		}
+		if kind != idempotent && !isUnsent {
+			return &ConnectionError{Method: method, Err: err}
+		}
+		// podman went away: the idle connections are broken as well
+		hnd.pool.close()
+		hnd.log.Warningf("connection to %s broken calling %s: %v", hnd.socket, method, err)
+	}
+	return fmt.Errorf("cannot reach podman at %s calling %s: %v", hnd.socket, method, err)
+}
@@ -0,0 +1,294 @@
+package podman_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+// fakePodman serves the varlink calls on a unix socket. The replies are made by handle: a nil reply
+// drops the connection without replying, like podman dying in the middle of the call, while a
+// []interface{} reply is streamed, one item per reply.
+type fakePodman struct {
+	path   string
+	handle func(method string) interface{}
+
+	lock  sync.Mutex
+	ln    *net.UnixListener
+	conns map[net.Conn]bool
+	calls []string
+	peers map[net.Conn]bool
+}
+
+func newFakePodman(path string, handle func(method string) interface{}) *fakePodman {
+	fp := &fakePodman{
+		path:   path,
+		handle: handle,
+		peers:  make(map[net.Conn]bool),
+	}
+	fp.start()
+	return fp
+}
+
+func (fp *fakePodman) address() string {
+	return "unix:" + fp.path
+}
+
+func (fp *fakePodman) start() {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: fp.path, Net: "unix"})
+	Expect(err).To(BeNil())
+	// like a socket activated service, the socket outlives the service
+	ln.SetUnlinkOnClose(false)
+
+	fp.lock.Lock()
+	fp.ln = ln
+	fp.conns = make(map[net.Conn]bool)
+	fp.lock.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fp.lock.Lock()
+			fp.conns[conn] = true
+			fp.lock.Unlock()
+			go fp.serve(conn)
+		}
+	}()
+}
+
+// stop closes the socket and all the connections, like podman exiting
+func (fp *fakePodman) stop() {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	fp.ln.Close()
+	for conn := range fp.conns {
+		conn.Close()
+	}
+}
+
+func (fp *fakePodman) restart() {
+	fp.stop()
+	os.Remove(fp.path)
+	fp.start()
+}
+
+func (fp *fakePodman) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		data, err := rd.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal(data[:len(data)-1], &req); err != nil {
+			return
+		}
+		fp.lock.Lock()
+		fp.calls = append(fp.calls, req.Method)
+		fp.peers[conn] = true
+		fp.lock.Unlock()
+
+		params := fp.handle(req.Method)
+		if params == nil {
+			return
+		}
+		stream, ok := params.([]interface{})
+		if !ok {
+			stream = []interface{}{params}
+		}
+		for idx, item := range stream {
+			reply, err := json.Marshal(map[string]interface{}{
+				"parameters": item,
+				"continues":  idx < len(stream)-1,
+			})
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(append(reply, 0)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (fp *fakePodman) getCalls() []string {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	return append([]string{}, fp.calls...)
+}
+
+func (fp *fakePodman) getPeers() int {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	return len(fp.peers)
+}
+
+var _ = Describe("connection", func() {
+	var tmpDir string
+	var fp *fakePodman
+	var hnd *podman.Handle
+
+	var lock sync.Mutex
+	var drops map[string]int
+	// waiters, if set, holds back the replies to WaitContainer until all the waiters called
+	var waiters *sync.WaitGroup
+
+	containers := map[string]interface{}{
+		"containers": []iopodman.Container{
+			{Id: "0123", Names: "pack8s-test"},
+		},
+	}
+
+	// dropNext makes the next count calls to method drop their connection
+	dropNext := func(method string, count int) {
+		lock.Lock()
+		defer lock.Unlock()
+		drops[method] = count
+	}
+
+	dropped := func(method string) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if drops[method] > 0 {
+			drops[method]--
+			return true
+		}
+		return false
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-conn")
+		Expect(err).To(BeNil())
+		drops = make(map[string]int)
+		waiters = nil
+
+		fp = newFakePodman(filepath.Join(tmpDir, "io.podman"), func(method string) interface{} {
+			if dropped(method) {
+				return nil
+			}
+			switch method {
+			case "io.podman.ListContainers":
+				return containers
+			case "io.podman.CreateContainer":
+				return map[string]string{"container": "0123"}
+			case "io.podman.WaitContainer":
+				if waiters != nil {
+					waiters.Done()
+					waiters.Wait()
+				}
+				return map[string]int64{"exitcode": 7}
+			}
+			return map[string]string{}
+		})
+
+		hnd, err = podman.NewHandle(context.Background(), fp.address(), NewLogger())
+		Expect(err).To(BeNil())
+		hnd.SetReconnect(images.Retry{
+			Attempts: 4,
+			Delay:    10 * time.Millisecond,
+			Backoff:  2,
+			MaxDelay: 50 * time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		hnd.Close()
+		fp.stop()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("Should retry the idempotent calls on a new connection", func() {
+		dropNext("io.podman.ListContainers", 2)
+
+		conts, err := hnd.GetPrefixedContainers("pack8s")
+		Expect(err).To(BeNil())
+		Expect(len(conts)).To(Equal(1))
+		Expect(fp.getCalls()).To(Equal([]string{
+			"io.podman.ListContainers",
+			"io.podman.ListContainers",
+			"io.podman.ListContainers",
+		}))
+	})
+
+	It("Should not retry the calls podman may have carried out", func() {
+		dropNext("io.podman.CreateContainer", 1)
+
+		_, err := hnd.CreateContainer(iopodman.Create{Args: []string{images.DockerRegistryImage}})
+		connErr, ok := err.(*podman.ConnectionError)
+		Expect(ok).To(BeTrue())
+		Expect(connErr.Method).To(Equal("CreateContainer"))
+		Expect(fp.getCalls()).To(Equal([]string{"io.podman.CreateContainer"}))
+	})
+
+	It("Should reconnect once podman restarted", func() {
+		_, err := hnd.GetPrefixedContainers("pack8s")
+		Expect(err).To(BeNil())
+
+		fp.restart()
+
+		id, err := hnd.CreateContainer(iopodman.Create{Args: []string{images.DockerRegistryImage}})
+		Expect(err).To(BeNil())
+		Expect(id).To(Equal("0123"))
+		Expect(fp.getCalls()).To(Equal([]string{"io.podman.ListContainers", "io.podman.CreateContainer"}))
+	})
+
+	It("Should give up if podman doesn't come back", func() {
+		fp.stop()
+
+		_, err := hnd.GetPrefixedContainers("pack8s")
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("cannot reach podman"))
+	})
+
+	It("Should use a connection per concurrent call", func() {
+		// the first call is served on the connection established by NewHandle
+		_, err := hnd.GetPrefixedContainers("pack8s")
+		Expect(err).To(BeNil())
+
+		waiters = &sync.WaitGroup{}
+		waiters.Add(3)
+		var wg sync.WaitGroup
+		rcs := make(chan int64, 3)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rc, err := hnd.WaitContainer("pack8s-test", 100)
+				if err == nil {
+					rcs <- rc
+				}
+			}()
+		}
+		wg.Wait()
+		close(rcs)
+
+		count := 0
+		for rc := range rcs {
+			Expect(rc).To(Equal(int64(7)))
+			count++
+		}
+		Expect(count).To(Equal(3))
+		Expect(fp.getPeers()).To(Equal(3))
+	})
+})
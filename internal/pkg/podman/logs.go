@@ -0,0 +1,84 @@
+package podman
+
+import (
+	"errors"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	// allLogLines asks for all the log lines, not just the last ones
+	allLogLines = -1
+
+	// LogStdout and LogStderr are the devices of the log lines
+	LogStdout = "stdout"
+	LogStderr = "stderr"
+)
+
+// LogOptions tells which lines of the container logs to get
+type LogOptions struct {
+	// Follow keeps sending the new lines, until the context of the Runtime is done
+	Follow bool
+	// Since, if not zero, skips the lines logged before
+	Since time.Time
+}
+
+// LogHandler gets the log lines of the containers. Returning an error stops the logs.
+type LogHandler func(line iopodman.LogLine) error
+
+// errLogsStopped tells the log handler stopped the logs
+var errLogsStopped = errors.New("logs stopped")
+
+// GetContainerLogs returns all the lines logged by the given container
+func (hnd *Handle) GetContainerLogs(name string) ([]string, error) {
+	var lines []string
+	err := hnd.call("GetContainerLogs", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		lines, err = iopodman.GetContainerLogs().Call(hnd.ctx, conn, name)
+		return err
+	})
+	return lines, err
+}
+
+// GetContainersLogs sends the lines logged by the given containers to handle, as podman interleaves them.
+func (hnd *Handle) GetContainersLogs(names []string, opts LogOptions, handle LogHandler) error {
+	// podman wants a valid time: the zero time means all the lines
+	since := opts.Since.Format(time.RFC3339Nano)
+
+	var handleErr error
+	delivered := false
+	err := hnd.call("GetContainersLogs", once, func(conn *varlink.Connection) error {
+		receive, err := iopodman.GetContainersLogs().Send(hnd.ctx, conn, varlink.More, names, opts.Follow, false, since, allLogLines, false)
+		if err != nil {
+			return unsent(err)
+		}
+		for {
+			line, flags, err := receive(hnd.ctx)
+			if err != nil {
+				// getting the logs changes nothing: they can be asked again, as long as no line was handled
+				if !delivered {
+					return unsent(err)
+				}
+				return err
+			}
+			// the last reply carries no line
+			if line.Cid != "" || line.Msg != "" {
+				delivered = true
+				if handleErr = handle(line); handleErr != nil {
+					// the stream is discarded along with its connection
+					return errLogsStopped
+				}
+			}
+			if flags&varlink.Continues == 0 {
+				return nil
+			}
+		}
+	})
+	if err == errLogsStopped {
+		return handleErr
+	}
+	return err
+}
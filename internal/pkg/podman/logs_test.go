@@ -0,0 +1,89 @@
+package podman_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+var _ = Describe("logs", func() {
+	var tmpDir string
+	var fp *fakePodman
+	var hnd *podman.Handle
+
+	logLines := []iopodman.LogLine{
+		{Device: "stdout", ParseLogType: "F", Time: "2020-01-14T14:50:00Z", Msg: "dnsmasq started", Cid: "0123"},
+		{Device: "stderr", ParseLogType: "F", Time: "2020-01-14T14:50:01Z", Msg: "registry started", Cid: "4567"},
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-logs")
+		Expect(err).To(BeNil())
+
+		fp = newFakePodman(filepath.Join(tmpDir, "io.podman"), func(method string) interface{} {
+			switch method {
+			case "io.podman.GetContainerLogs":
+				return map[string][]string{"container": {"dnsmasq started"}}
+			case "io.podman.GetContainersLogs":
+				stream := []interface{}{}
+				for _, line := range logLines {
+					stream = append(stream, map[string]iopodman.LogLine{"log": line})
+				}
+				// podman closes the stream with an empty line
+				return append(stream, map[string]iopodman.LogLine{"log": {}})
+			}
+			return map[string]string{}
+		})
+
+		hnd, err = podman.NewHandle(context.Background(), fp.address(), NewLogger())
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		hnd.Close()
+		fp.stop()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("Should get the logs of a container", func() {
+		lines, err := hnd.GetContainerLogs("pack8s-test-dnsmasq")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"dnsmasq started"}))
+	})
+
+	It("Should stream the logs of the containers", func() {
+		lines := []iopodman.LogLine{}
+		err := hnd.GetContainersLogs([]string{"pack8s-test-dnsmasq", "pack8s-test-registry"}, podman.LogOptions{}, func(line iopodman.LogLine) error {
+			lines = append(lines, line)
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal(logLines))
+
+		// the connection is still usable
+		_, err = hnd.GetContainerLogs("pack8s-test-dnsmasq")
+		Expect(err).To(BeNil())
+	})
+
+	It("Should stop once the handler fails", func() {
+		stop := errors.New("enough")
+		count := 0
+		err := hnd.GetContainersLogs([]string{"pack8s-test-dnsmasq"}, podman.LogOptions{}, func(line iopodman.LogLine) error {
+			count++
+			return stop
+		})
+		Expect(err).To(Equal(stop))
+		Expect(count).To(Equal(1))
+		Expect(fp.getCalls()).To(Equal([]string{"io.podman.GetContainersLogs"}))
+	})
+})
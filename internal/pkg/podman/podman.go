@@ -1,18 +1,22 @@
 package podman
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	logger "github.com/apsdehal/go-logger"
 	"github.com/varlink/go/varlink"
 
 	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/pullprogress"
 	"github.com/fromanirh/pack8s/pkg/varlinkapi/virtwriter"
 
 	"github.com/fromanirh/pack8s/iopodman"
@@ -59,6 +63,9 @@ func SprintError(methodname string, err error) string {
 		//error RuntimeError (reason: string)
 		fmt.Fprintf(buf, "'%v' reason='%s'\n", e, e.Reason)
 
+	case *ConnectionError:
+		fmt.Fprintf(buf, "'%v' method='%s'\n", e, e.Method)
+
 	case *varlink.InvalidParameter:
 		fmt.Fprintf(buf, "'%v' parameter='%s'\n", e, e.Parameter)
 
@@ -86,54 +93,77 @@ func SprintError(methodname string, err error) string {
 	return buf.String()
 }
 
+// PullProgressReporter is told about the progress of the image pulls, at most once per interval,
+// and always when a pull completes or fails.
 type PullProgressReporter interface {
 	GetInterval() time.Duration
-	Report(ref string, elapsed, completed uint64, err error) error
+	Report(progress pullprogress.Progress, err error) error
 }
 
+// Handle is the Runtime talking to the io.podman varlink interface.
+// A Handle can be used concurrently by more goroutines: each call uses a connection of its own.
+// The broken connections are transparently replaced, see call.
 type Handle struct {
-	PullReporter PullProgressReporter
-	socket       string
-	ctx          context.Context
-	conn         *varlink.Connection
-	log          *logger.Logger
+	puller
+	socket         string
+	ctx            context.Context
+	pool           *connPool
+	reconnectRetry images.Retry
+	log            *logger.Logger
 }
 
 func NewHandle(ctx context.Context, socket string, log *logger.Logger) (*Handle, error) {
 	if socket == "" {
 		socket = DefaultSocket
 	}
-	conn, err := varlink.NewConnection(ctx, socket)
-	log.Infof("connected to %s", socket)
+	socket, err := localSocket(socket, log)
+	if err != nil {
+		return nil, err
+	}
 	hnd := Handle{
-		PullReporter: pullProgressReporter{Log: log},
-		socket:       socket,
-		ctx:          ctx,
-		conn:         conn,
-		log:          log,
+		puller:         newPuller(log),
+		socket:         socket,
+		ctx:            ctx,
+		pool:           newConnPool(),
+		reconnectRetry: DefaultReconnect(),
+		log:            log,
+	}
+	// fail early if podman is not there
+	conn, err := varlink.NewConnection(ctx, socket)
+	if err != nil {
+		return &hnd, err
 	}
-	return &hnd, err
+	hnd.pool.put(conn)
+	log.Infof("connected to %s", socket)
+	return &hnd, nil
 }
 
-func (hnd *Handle) reconnect() (bool, error) {
-	if hnd.conn == nil {
-		var err error
-		hnd.conn, err = varlink.NewConnection(hnd.ctx, hnd.socket)
-		hnd.log.Noticef("reconnected to %s (err=%v)", hnd.socket, err)
-		return true, err
-	}
-	hnd.log.Debugf("already connected to %s", hnd.socket)
-	return false, nil
+// SetReconnect sets how to reconnect to podman once the connection breaks
+func (hnd *Handle) SetReconnect(retry images.Retry) {
+	hnd.reconnectRetry = retry
 }
 
-func (hnd *Handle) disconnect() error {
-	var err error
-	if hnd.conn != nil {
-		err = hnd.conn.Close()
-		hnd.conn = nil
-		hnd.log.Infof("disconnected from %s (err=%v)", hnd.socket, err)
+// Clone returns a new Handle talking to the same socket, bound to the given context.
+// The Handles share the idle connections.
+func (hnd *Handle) Clone(ctx context.Context) Runtime {
+	return hnd.clone(ctx)
+}
+
+func (hnd *Handle) clone(ctx context.Context) *Handle {
+	return &Handle{
+		puller:         hnd.puller,
+		socket:         hnd.socket,
+		ctx:            ctx,
+		pool:           hnd.pool,
+		reconnectRetry: hnd.reconnectRetry,
+		log:            hnd.log,
 	}
-	return err
+}
+
+// Close releases the idle connections. The Handle can be used again later,
+// and it will transparently reconnect.
+func (hnd *Handle) Close() error {
+	return hnd.pool.close()
 }
 
 type ReaderContext interface {
@@ -149,56 +179,198 @@ func (r readerCtx) Read(in []byte) (int, error) {
 	return r.ReaderContext.Read(r.ctx, in)
 }
 
-func (hnd *Handle) Exec(container string, args []string, out io.Writer) error {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return err
+type writerCtx struct {
+	varlink.ReadWriterContext
+	ctx context.Context
+}
+
+func (w writerCtx) Write(out []byte) (int, error) {
+	return w.ReadWriterContext.Write(w.ctx, out)
+}
+
+// TerminalSize is the size of the terminal an exec session is attached to
+type TerminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// ExecOptions connect an exec session to the caller, and set up the environment of the command
+type ExecOptions struct {
+	// Stdin, if not nil, is forwarded to the command
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Tty allocates a pseudo terminal to the command. The command output and error are merged.
+	Tty bool
+	// Resize, if not nil, delivers the size changes of the local terminal. Only meaningful with Tty.
+	Resize <-chan TerminalSize
+	// Env holds additional environment variables for the command, in the KEY=VALUE form
+	Env []string
+	// Workdir, if not empty, is the directory the command runs in
+	Workdir string
+}
+
+// ExitError is returned when the command run in the container exits with a non-zero code
+type ExitError struct {
+	Container string
+	Code      int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exec failed: rc=%d", e.Code)
+}
+
+// muxWriter multiplexes the input streams of an exec session on the upgraded connection.
+type muxWriter struct {
+	lock sync.Mutex
+	buf  *bufio.Writer
+}
+
+func (mw *muxWriter) send(dest virtwriter.SocketDest, data []byte) error {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	_, err := virtwriter.NewVirtWriteCloser(mw.buf, dest).Write(data)
+	return err
+}
+
+type stdinWriter struct {
+	mw *muxWriter
+}
+
+func (sw stdinWriter) Write(data []byte) (int, error) {
+	if err := sw.mw.send(virtwriter.ToStdin, data); err != nil {
+		return 0, err
 	}
-	defer hnd.disconnect()
+	return len(data), nil
+}
+
+func (hnd *Handle) Exec(container string, args []string, out io.Writer) error {
+	return hnd.ExecWithOptions(container, args, ExecOptions{
+		Stdout: out,
+		Stderr: os.Stderr,
+		Tty:    true,
+	})
+}
 
-	rwc, err := ExecContainer().Call(hnd.ctx, hnd.conn, iopodman.ExecOpts{
+// ExecWithOptions runs the command in the container, connected to the given streams.
+// If the command exits with a non-zero code, the returned error is an *ExitError.
+func (hnd *Handle) ExecWithOptions(container string, args []string, opts ExecOptions) error {
+	execOpts := iopodman.ExecOpts{
 		Name:       container,
-		Tty:        true,
+		Tty:        opts.Tty,
 		Privileged: true,
 		Cmd:        args,
-	})
-
-	if err != nil {
-		return err
 	}
+	if len(opts.Env) > 0 {
+		execOpts.Env = &opts.Env
+	}
+	if opts.Workdir != "" {
+		execOpts.Workdir = &opts.Workdir
+	}
+	// the session takes over the connection until the command exits
+	return hnd.call("ExecContainer", upgrade, func(conn *varlink.Connection) error {
+		receive, err := ExecContainer().Upgrade(hnd.ctx, conn, execOpts)
+		if err != nil {
+			return unsent(err)
+		}
+		rwc, err := receive(hnd.ctx)
+		if err != nil {
+			return err
+		}
+		return hnd.runExecSession(container, rwc, opts)
+	})
+}
 
+// runExecSession connects the exec session to the given streams, until the command exits
+func (hnd *Handle) runExecSession(container string, rwc varlink.ReadWriterContext, opts ExecOptions) error {
 	rd := readerCtx{
 		ReaderContext: rwc,
-		ctx:           context.TODO(),
+		ctx:           hnd.ctx,
+	}
+	mw := &muxWriter{
+		buf: bufio.NewWriter(writerCtx{
+			ReadWriterContext: rwc,
+			ctx:               hnd.ctx,
+		}),
 	}
+	done := make(chan struct{})
+	defer close(done)
 
 	ecChan := make(chan int, 1)
 	errChan := make(chan error, 1)
 	go func() {
 		// Read from the wire and direct to stdout or stderr
-		err := virtwriter.Reader(rd, out, os.Stderr, nil, ecChan)
+		err := virtwriter.Reader(rd, opts.Stdout, opts.Stderr, nil, ecChan)
 		errChan <- err
 	}()
 
-	err = <-errChan
+	if opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case size, ok := <-opts.Resize:
+					if !ok {
+						return
+					}
+					data, err := json.Marshal(size)
+					if err == nil {
+						err = mw.send(virtwriter.TerminalResize, data)
+					}
+					if err != nil {
+						hnd.log.Warningf("cannot resize the terminal: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	if opts.Stdin != nil {
+		// we can't interrupt a blocked read, so this goroutine may outlive the exec session.
+		go func() {
+			_, err := io.Copy(stdinWriter{mw: mw}, opts.Stdin)
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err != nil {
+				hnd.log.Warningf("cannot forward the input: %v", err)
+				return
+			}
+			// let the command know the input is over
+			if err := mw.send(virtwriter.HangUpFromClient, []byte{}); err != nil {
+				hnd.log.Warningf("cannot close the input: %v", err)
+			}
+		}()
+	}
+
+	err := <-errChan
 	if err != nil {
 		return err
 	}
 	rc := <-ecChan
 	if rc != 0 {
-		return fmt.Errorf("exec failed: rc=%d", rc)
+		return &ExitError{Container: container, Code: rc}
 	}
 	return nil
 }
 
-func (hnd *Handle) GetPrefixedContainers(prefix string) ([]iopodman.Container, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return nil, err
-	}
+func (hnd *Handle) listContainers() ([]iopodman.Container, error) {
+	var containers []iopodman.Container
+	err := hnd.call("ListContainers", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		containers, err = iopodman.ListContainers().Call(hnd.ctx, conn)
+		return err
+	})
+	return containers, err
+}
 
+func (hnd *Handle) GetPrefixedContainers(prefix string) ([]iopodman.Container, error) {
 	ret := []iopodman.Container{}
-	containers, err := iopodman.ListContainers().Call(hnd.ctx, hnd.conn)
+	containers, err := hnd.listContainers()
 	if err != nil {
 		return ret, err
 	}
@@ -215,15 +387,8 @@ func (hnd *Handle) GetPrefixedContainers(prefix string) ([]iopodman.Container, e
 }
 
 func (hnd *Handle) GetPrefixedVolumes(prefix string) ([]iopodman.Volume, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return nil, err
-	}
-
 	ret := []iopodman.Volume{}
-	args := []string{}
-	all := true
-	volumes, err := iopodman.GetVolumes().Call(hnd.ctx, hnd.conn, args, all)
+	volumes, err := hnd.GetAllVolumes()
 	if err != nil {
 		return ret, err
 	}
@@ -239,14 +404,7 @@ func (hnd *Handle) GetPrefixedVolumes(prefix string) ([]iopodman.Volume, error)
 }
 
 func (hnd *Handle) FindPrefixedContainer(prefixedName string) (iopodman.Container, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return iopodman.Container{}, err
-	}
-
-	containers := []iopodman.Container{}
-
-	containers, err = hnd.GetPrefixedContainers(prefixedName)
+	containers, err := hnd.GetPrefixedContainers(prefixedName)
 	if err != nil {
 		return iopodman.Container{}, err
 	}
@@ -259,208 +417,443 @@ func (hnd *Handle) FindPrefixedContainer(prefixedName string) (iopodman.Containe
 
 //PruneVolumes removes all unused volumes on the host.
 func (hnd *Handle) PruneVolumes() error {
-	_, err := hnd.reconnect()
-	if err != nil {
+	return hnd.call("VolumesPrune", once, func(conn *varlink.Connection) error {
+		receive, err := iopodman.VolumesPrune().Send(hnd.ctx, conn, 0)
+		if err != nil {
+			return unsent(err)
+		}
+		_, _, _, err = receive(hnd.ctx)
 		return err
-	}
-
-	_, _, err = iopodman.VolumesPrune().Call(hnd.ctx, hnd.conn)
-	return err
+	})
 }
 
 //GetAllVolumes returns all volumes
 func (hnd *Handle) GetAllVolumes() ([]iopodman.Volume, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return nil, err
-	}
-
-	return iopodman.GetVolumes().Call(hnd.ctx, hnd.conn, []string{}, true)
+	var volumes []iopodman.Volume
+	err := hnd.call("GetVolumes", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		volumes, err = iopodman.GetVolumes().Call(hnd.ctx, conn, []string{}, true)
+		return err
+	})
+	return volumes, err
 }
 
 func (hnd *Handle) RemoveVolumes(volumes []iopodman.Volume) error {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return err
-	}
-
 	volumeNames := []string{}
 	for _, vol := range volumes {
 		hnd.log.Infof("removing volume %s @%s", vol.Name, vol.MountPoint)
 		volumeNames = append(volumeNames, vol.Name)
 	}
-	_, _, err = iopodman.VolumeRemove().Call(hnd.ctx, hnd.conn, iopodman.VolumeRemoveOpts{
-		Volumes: volumeNames,
-		Force:   true,
+	return hnd.call("VolumeRemove", once, func(conn *varlink.Connection) error {
+		receive, err := iopodman.VolumeRemove().Send(hnd.ctx, conn, 0, iopodman.VolumeRemoveOpts{
+			Volumes: volumeNames,
+			Force:   true,
+		})
+		if err != nil {
+			return unsent(err)
+		}
+		_, _, _, err = receive(hnd.ctx)
+		return err
 	})
-	return err
 }
 
 func (hnd *Handle) RemoveContainer(cont iopodman.Container, force, removeVolumes bool) (string, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return "", err
-	}
-
 	hnd.log.Infof("trying to remove: %s (%s) force=%v removeVolumes=%v\n", cont.Names, cont.Id, force, removeVolumes)
-	return iopodman.RemoveContainer().Call(hnd.ctx, hnd.conn, cont.Id, force, removeVolumes)
+	return hnd.callOnce("RemoveContainer", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.RemoveContainer().Send(hnd.ctx, conn, 0, cont.Id, force, removeVolumes)
+	})
 }
 
 func (hnd *Handle) CreateNamedVolume(name string) (string, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return "", err
-	}
-
-	return iopodman.VolumeCreate().Call(hnd.ctx, hnd.conn, iopodman.VolumeCreateOpts{
-		VolumeName: name,
+	return hnd.callOnce("VolumeCreate", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.VolumeCreate().Send(hnd.ctx, conn, 0, iopodman.VolumeCreateOpts{
+			VolumeName: name,
+		})
 	})
 }
 
 func (hnd *Handle) CreateContainer(conf iopodman.Create) (string, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return "", err
-	}
-
-	return iopodman.CreateContainer().Call(hnd.ctx, hnd.conn, conf)
+	return hnd.callOnce("CreateContainer", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.CreateContainer().Send(hnd.ctx, conn, 0, conf)
+	})
 }
 
 func (hnd *Handle) StopContainer(name string, timeout int64) (string, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return "", err
-	}
-
-	return iopodman.StopContainer().Call(hnd.ctx, hnd.conn, name, timeout)
+	return hnd.callOnce("StopContainer", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.StopContainer().Send(hnd.ctx, conn, 0, name, timeout)
+	})
 }
 
 func (hnd *Handle) StartContainer(contID string) (string, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return "", err
-	}
+	return hnd.callOnce("StartContainer", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.StartContainer().Send(hnd.ctx, conn, 0, contID)
+	})
+}
 
-	return iopodman.StartContainer().Call(hnd.ctx, hnd.conn, contID)
+func (hnd *Handle) CreatePod(conf iopodman.PodCreate) (string, error) {
+	return hnd.callOnce("CreatePod", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.CreatePod().Send(hnd.ctx, conn, 0, conf)
+	})
 }
 
-func (hnd *Handle) WaitContainer(name string, interval int64) (int64, error) {
-	_, err := hnd.reconnect()
-	if err != nil {
-		return 0, err
-	}
+func (hnd *Handle) StopPod(name string, timeout int64) (string, error) {
+	return hnd.callOnce("StopPod", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.StopPod().Send(hnd.ctx, conn, 0, name, timeout)
+	})
+}
 
-	return iopodman.WaitContainer().Call(hnd.ctx, hnd.conn, name, interval)
+// RemovePod removes the given pod. With force, the containers of the pod are removed too.
+func (hnd *Handle) RemovePod(name string, force bool) (string, error) {
+	hnd.log.Infof("trying to remove pod: %s force=%v\n", name, force)
+	return hnd.callOnce("RemovePod", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.RemovePod().Send(hnd.ctx, conn, 0, name, force)
+	})
 }
 
-//ListImages returns all images on host
-func (hnd *Handle) ListImages() ([]iopodman.Image, error) {
-	_, err := hnd.reconnect()
+// FindPod returns the pod with the given name, and tells if it was found.
+func (hnd *Handle) FindPod(name string) (iopodman.ListPodData, bool, error) {
+	var pod iopodman.ListPodData
+	err := hnd.call("GetPod", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		pod, err = iopodman.GetPod().Call(hnd.ctx, conn, name)
+		return err
+	})
+	if _, ok := err.(*iopodman.PodNotFound); ok {
+		return pod, false, nil
+	}
+	return pod, err == nil, err
+}
+
+// GetPodPorts returns the ports published by the given pod. The ports of a pod are held by its infra container.
+func (hnd *Handle) GetPodPorts(pod iopodman.ListPodData) ([]iopodman.ContainerPortMappings, error) {
+	infraID := ""
+	for _, info := range pod.Containersinfo {
+		if strings.HasSuffix(info.Name, "-infra") {
+			infraID = info.Id
+		}
+	}
+	if infraID == "" {
+		return nil, fmt.Errorf("pod %s has no infra container", pod.Name)
+	}
+
+	containers, err := hnd.listContainers()
 	if err != nil {
 		return nil, err
 	}
+	for _, cont := range containers {
+		if cont.Id == infraID {
+			return cont.Ports, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find the infra container %s of pod %s", infraID, pod.Name)
+}
 
-	return iopodman.ListImages().Call(hnd.ctx, hnd.conn)
+// GenerateKube returns the Kubernetes v1 Pod description of the given container or pod, and optionally
+// the Service description exposing its ports.
+func (hnd *Handle) GenerateKube(name string, service bool) (iopodman.KubePodService, error) {
+	var kube iopodman.KubePodService
+	err := hnd.call("GenerateKube", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		kube, err = iopodman.GenerateKube().Call(hnd.ctx, conn, name, service)
+		return err
+	})
+	return kube, err
 }
 
-func (hnd *Handle) PullImageFromRegistry(registry, image string) error {
-	imageRef := registry + "/" + image // TODO: is that a path? an URL? something else?
-	return hnd.PullImage(imageRef)
+// ContainerState is the runtime state of a container, as reported by InspectContainer
+type ContainerState struct {
+	Status     string    `json:"Status"`
+	Running    bool      `json:"Running"`
+	ExitCode   int32     `json:"ExitCode"`
+	StartedAt  time.Time `json:"StartedAt"`
+	FinishedAt time.Time `json:"FinishedAt"`
 }
 
-func (hnd *Handle) PullImage(ref string) error {
-	_, err := hnd.reconnect()
+// GetContainerState returns the runtime state of the given container
+func (hnd *Handle) GetContainerState(name string) (ContainerState, error) {
+	data, err := hnd.inspectContainer(name)
 	if err != nil {
-		return err
+		return ContainerState{}, err
 	}
 
-	hnd.log.Noticef("pulling image: %s", ref)
+	var inspect struct {
+		State ContainerState `json:"State"`
+	}
+	err = json.Unmarshal([]byte(data), &inspect)
+	return inspect.State, err
+}
 
-	tries := []int{0, 1, 2, 6}
-	interval := hnd.PullReporter.GetInterval() * time.Second
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// WaitContainer waits for the given container to exit, and returns its exit code. Waiting is idempotent,
+// so the wait goes on even if podman restarts meanwhile.
+func (hnd *Handle) WaitContainer(name string, interval int64) (int64, error) {
+	var rc int64
+	err := hnd.call("WaitContainer", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		rc, err = iopodman.WaitContainer().Call(hnd.ctx, conn, name, interval)
+		return err
+	})
+	return rc, err
+}
 
-	for idx, i := range tries {
-		time.Sleep(time.Duration(i) * time.Second)
+// ContainerMount is a mount of a container, as reported by InspectContainer
+type ContainerMount struct {
+	Type        string `json:"Type"`
+	Name        string `json:"Name"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	RW          bool   `json:"RW"`
+}
 
-		prefix := fmt.Sprintf("attempt #%d", idx)
-		hnd.log.Infof("%s to download '%s' - progress every %v\n", prefix, ref, interval)
-		err := hnd.pullImage(ticker, prefix, ref)
-		if err == nil {
-			return nil
-		}
-	}
-	return fmt.Errorf("failed to download %s %d times, giving up.", ref, len(tries))
+// ContainerInspect holds the settings of a container needed to recreate it, as reported by InspectContainer
+type ContainerInspect struct {
+	ImageName string           `json:"ImageName"`
+	Mounts    []ContainerMount `json:"Mounts"`
+	Config    struct {
+		Env        []string          `json:"Env"`
+		Cmd        []string          `json:"Cmd"`
+		Entrypoint string            `json:"Entrypoint"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Privileged  bool     `json:"Privileged"`
+		NetworkMode string   `json:"NetworkMode"`
+		ExtraHosts  []string `json:"ExtraHosts"`
+	} `json:"HostConfig"`
 }
 
-func (hnd *Handle) PullClusterImages(reqs images.Requests, clusterRegistry, clusterImage string) error {
-	var err error
-	err = hnd.PullImageFromRegistry(clusterRegistry, clusterImage)
+// InspectContainer returns the settings of the given container
+func (hnd *Handle) InspectContainer(name string) (ContainerInspect, error) {
+	data, err := hnd.inspectContainer(name)
 	if err != nil {
-		return err
+		return ContainerInspect{}, err
 	}
-	err = hnd.PullImage(images.DockerRegistryImage)
-	if err != nil {
+
+	var inspect ContainerInspect
+	err = json.Unmarshal([]byte(data), &inspect)
+	return inspect, err
+}
+
+// inspectContainer returns the JSON description of the given container
+func (hnd *Handle) inspectContainer(name string) (string, error) {
+	var data string
+	err := hnd.call("InspectContainer", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		data, err = iopodman.InspectContainer().Call(hnd.ctx, conn, name)
 		return err
-	}
-	if reqs.WantsNFS() {
-		err = hnd.PullImage(images.NFSGaneshaImage)
+	})
+	return data, err
+}
+
+// CheckpointContainer saves the state of the given running container, then stops it.
+// The state is kept by podman, and it is used by RestoreContainer.
+func (hnd *Handle) CheckpointContainer(name string) (string, error) {
+	keep := false
+	leaveRunning := false
+	tcpEstablished := true
+	return hnd.callOnce("ContainerCheckpoint", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.ContainerCheckpoint().Send(hnd.ctx, conn, 0, name, keep, leaveRunning, tcpEstablished)
+	})
+}
+
+// RestoreContainer resumes the given container from the state saved by CheckpointContainer
+func (hnd *Handle) RestoreContainer(name string) (string, error) {
+	keep := false
+	tcpEstablished := true
+	return hnd.callOnce("ContainerRestore", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.ContainerRestore().Send(hnd.ctx, conn, 0, name, keep, tcpEstablished)
+	})
+}
+
+// ExportContainer writes a tarball of the filesystem of the given container at path, on the podman host.
+// Volumes are not included.
+func (hnd *Handle) ExportContainer(name, path string) (string, error) {
+	return hnd.callOnce("ExportContainer", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.ExportContainer().Send(hnd.ctx, conn, 0, name, path)
+	})
+}
+
+// ImportImage creates the image reference from the filesystem tarball at source, on the podman host.
+// The tarball is removed once imported.
+func (hnd *Handle) ImportImage(source, reference, message string) (string, error) {
+	remove := true
+	return hnd.callOnce("ImportImage", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.ImportImage().Send(hnd.ctx, conn, 0, source, reference, message, []string{}, remove)
+	})
+}
+
+// GetImage returns the local image with the given name or ID
+func (hnd *Handle) GetImage(name string) (iopodman.Image, error) {
+	var img iopodman.Image
+	err := hnd.call("GetImage", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		img, err = iopodman.GetImage().Call(hnd.ctx, conn, name)
+		return err
+	})
+	return img, err
+}
+
+// ExportImage writes the given image as docker archive at path, on the podman host, tagged with tags.
+// The ID of the image is returned.
+func (hnd *Handle) ExportImage(name, path string, tags []string) (string, error) {
+	compress := false
+	return hnd.callOnce("ExportImage", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.ExportImage().Send(hnd.ctx, conn, 0, name, "docker-archive:"+path, compress, tags)
+	})
+}
+
+// LoadImage loads the images stored in the archive at path, on the podman host, into the local storage.
+// The names of the loaded images are returned.
+func (hnd *Handle) LoadImage(path string) (string, error) {
+	quiet := true
+	deleteFile := false
+	var reply iopodman.MoreResponse
+	err := hnd.call("LoadImage", once, func(conn *varlink.Connection) error {
+		receive, err := iopodman.LoadImage().Send(hnd.ctx, conn, 0, "", path, quiet, deleteFile)
 		if err != nil {
-			return err
+			return unsent(err)
 		}
+		reply, _, err = receive(hnd.ctx)
+		return err
+	})
+	return reply.Id, err
+}
+
+// RemoveImage removes the given image. Images still used by containers are kept, and an error is returned.
+func (hnd *Handle) RemoveImage(name string) error {
+	hnd.log.Infof("trying to remove image: %s\n", name)
+	force := false
+	_, err := hnd.callOnce("RemoveImage", func(conn *varlink.Connection) (stringReceiver, error) {
+		return iopodman.RemoveImage().Send(hnd.ctx, conn, 0, name, force)
+	})
+	return err
+}
+
+//ListImages returns all images on host
+func (hnd *Handle) ListImages() ([]iopodman.Image, error) {
+	var imgs []iopodman.Image
+	err := hnd.call("ListImages", idempotent, func(conn *varlink.Connection) error {
+		var err error
+		imgs, err = iopodman.ListImages().Call(hnd.ctx, conn)
+		return err
+	})
+	return imgs, err
+}
+
+// PullImage pulls the given image, retrying as configured with SetPullConfig.
+func (hnd *Handle) PullImage(ref string) error {
+	return hnd.pullImageWithRetry(ref, hnd.config.Retry)
+}
+
+// PullImages pulls the given images concurrently. The pulls still running are canceled once a required one fails.
+func (hnd *Handle) PullImages(reqs []PullRequest) error {
+	return hnd.pullAll(hnd.ctx, reqs, func(ctx context.Context, req PullRequest) error {
+		pullHnd := hnd.clone(ctx)
+		defer pullHnd.Close()
+		return pullHnd.pullImageWithRetry(req.Ref, req.Policy.Retry)
+	})
+}
+
+func (hnd *Handle) PullClusterImages(reqs images.Requests, clusterRegistry, clusterImage string) error {
+	return hnd.PullImages(hnd.clusterRequests(reqs, clusterRegistry, clusterImage))
+}
+
+func (hnd *Handle) pullImageWithRetry(ref string, retry images.Retry) error {
+	return hnd.pullWithRetry(hnd.ctx, ref, retry, hnd.pullImage, func(ref string) (string, error) {
+		img, err := hnd.GetImage(ref)
+		return img.Id, err
+	})
+}
+
+// pullImage pulls the image asking podman to stream its output, which is parsed to track the download.
+// Servers not supporting streaming just send the final reply.
+func (hnd *Handle) pullImage(interval time.Duration, ref string) error {
+	started := time.Now()
+	tracker := pullprogress.NewTracker(ref, started)
+	report := func(done bool, err error) error {
+		progress := tracker.Progress(time.Now())
+		progress.Done = done
+		return hnd.reporter.Report(progress, err)
 	}
-	if reqs.WantsCeph() {
-		err = hnd.PullImage(images.CephImage)
+
+	// pulling again is harmless: podman skips the layers already downloaded
+	err := hnd.call("PullImage", idempotent, func(conn *varlink.Connection) error {
+		receive, err := iopodman.PullImage().Send(hnd.ctx, conn, varlink.More, ref)
 		if err != nil {
 			return err
 		}
-	}
-	if reqs.WantsFluentd() {
-		err = hnd.PullImage(images.FluentdImage)
-		if err != nil {
-			return err
+
+		report(false, nil)
+		lastReport := started
+		for {
+			reply, flags, err := receive(hnd.ctx)
+			if err != nil {
+				// the broken stream is discarded along with its connection
+				return err
+			}
+			tracker.Update(reply.Logs)
+			if flags&varlink.Continues == 0 {
+				return nil
+			}
+			// podman replies as soon as new output is available, which may be very often
+			if now := time.Now(); now.Sub(lastReport) >= interval {
+				report(false, nil)
+				lastReport = now
+			}
 		}
-	}
-	return nil
+	})
+	return report(err == nil, err)
 }
 
-func (hnd *Handle) pullImage(ticker *time.Ticker, prefix, ref string) error {
-	var err error
-	errChan := make(chan error)
-	go func() {
-		_, err := iopodman.PullImage().Call(hnd.ctx, hnd.conn, ref)
-		errChan <- err
-	}()
+// pullProgressReporter logs the progress of the image pulls every 10%, if the image sizes are known.
+// The progress of concurrent pulls is merged.
+type pullProgressReporter struct {
+	Log       *logger.Logger
+	board     *pullprogress.Board
+	lock      sync.Mutex
+	milestone int
+}
 
-	hnd.PullReporter.Report(ref, 0, 0, nil)
-	for {
-		select {
-		case <-ticker.C:
-			hnd.PullReporter.Report(ref, 0, 1, nil)
-		case err = <-errChan:
-			return hnd.PullReporter.Report(ref, 1, 1, err)
-		}
+func newPullProgressReporter(log *logger.Logger) *pullProgressReporter {
+	return &pullProgressReporter{
+		Log:   log,
+		board: pullprogress.NewBoard(),
 	}
-
-	return fmt.Errorf("pull failed - internal error") // can't be reached
 }
 
-type pullProgressReporter struct {
-	Log *logger.Logger
+func (ppr *pullProgressReporter) GetInterval() time.Duration {
+	return 1 // assuming NOT-interactive report: only the milestones are logged
 }
 
-func (ppr pullProgressReporter) GetInterval() time.Duration {
-	return 10 // assuming NOT-interactive report
-}
+func (ppr *pullProgressReporter) Report(progress pullprogress.Progress, err error) error {
+	ppr.lock.Lock()
+	defer ppr.lock.Unlock()
 
-func (ppr pullProgressReporter) Report(ref string, elapsed, completed uint64, err error) error {
+	ref := progress.Ref
 	if err != nil {
+		ppr.board.Remove(ref)
 		ppr.Log.Warningf("download failed for %s: %v\n", ref, err)
-	} else if completed != 0 && elapsed == completed {
-		ppr.Log.Noticef("downloaded completed for %s", ref)
-	} else {
+		return err
+	}
+	if !ppr.board.Has(ref) {
 		ppr.Log.Infof("downloading %s...", ref)
 	}
-	return err
+	merged := ppr.board.Update(progress)
+	if progress.Done {
+		ppr.Log.Noticef("download completed for %s in %v", ref, progress.Elapsed.Round(time.Second))
+	}
+	if merged.Done {
+		ppr.milestone = 0
+		return nil
+	}
+
+	percent := merged.Percent()
+	if milestone := percent - percent%10; milestone > ppr.milestone {
+		ppr.milestone = milestone
+		current, total := merged.Bytes()
+		ppr.Log.Noticef("downloading %s: %d%% (%s/%s, %d/%d layers, ETA %v)", merged.Ref, percent,
+			pullprogress.FormatSize(current), pullprogress.FormatSize(total),
+			merged.LayersDone(), len(merged.Layers), merged.ETA())
+	}
+	return nil
 }
@@ -0,0 +1,1099 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/parallel"
+	"github.com/fromanirh/pack8s/internal/pkg/pullprogress"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	DefaultRESTSocket string = "unix:/run/podman/podman.sock"
+
+	// restAPIVersion is the version of the Docker API podman implements
+	restAPIVersion = "v1.40"
+)
+
+// ErrPodsUnsupported is returned by the pod operations of the runtimes without pods
+var ErrPodsUnsupported = errors.New("pods are not supported by the docker runtime: run the cluster without pod")
+
+// RESTError is an error reported by the REST API
+type RESTError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *RESTError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound tells if the given error reports a missing object
+func IsNotFound(err error) bool {
+	switch e := err.(type) {
+	case *RESTError:
+		return e.StatusCode == http.StatusNotFound
+	case *iopodman.ContainerNotFound, *iopodman.ImageNotFound, *iopodman.PodNotFound, *iopodman.VolumeNotFound:
+		return true
+	}
+	return false
+}
+
+// RESTClient is the Runtime talking to the Docker-compatible REST API of podman, over a unix socket.
+// The Docker API has no pods, so the pod operations fail with ErrPodsUnsupported.
+type RESTClient struct {
+	puller
+	socket string
+	ctx    context.Context
+	client *http.Client
+	log    *logger.Logger
+}
+
+var _ Runtime = &RESTClient{}
+
+func NewRESTClient(ctx context.Context, socket string, log *logger.Logger) (*RESTClient, error) {
+	if socket == "" {
+		socket = DefaultRESTSocket
+	}
+	socket, err := localSocket(socket, log)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(socket, "unix:")
+	if strings.Contains(path, ":") {
+		return nil, fmt.Errorf("unsupported socket for the docker runtime: %s", socket)
+	}
+
+	rc := &RESTClient{
+		puller: newPuller(log),
+		socket: socket,
+		ctx:    ctx,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+		log: log,
+	}
+	// like the varlink connection, fail early if nobody is listening
+	if err := rc.do(http.MethodGet, "/_ping", nil, nil, nil); err != nil {
+		return nil, err
+	}
+	log.Infof("connected to %s", socket)
+	return rc, nil
+}
+
+// Clone returns a new RESTClient talking to the same socket, bound to the given context.
+// HTTP requests don't share streams, so the clients can share the connection pool.
+func (rc *RESTClient) Clone(ctx context.Context) Runtime {
+	return &RESTClient{
+		puller: rc.puller,
+		socket: rc.socket,
+		ctx:    ctx,
+		client: rc.client,
+		log:    rc.log,
+	}
+}
+
+// Close releases the idle connections. The RESTClient can be used again later.
+func (rc *RESTClient) Close() error {
+	rc.client.CloseIdleConnections()
+	return nil
+}
+
+func (rc *RESTClient) newRequest(method, path string, query url.Values, in interface{}) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	// the host is ignored: all the requests go to the socket
+	reqURL := "http://podman/" + restAPIVersion + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req.WithContext(rc.ctx), nil
+}
+
+// send sends the request, returning the response only if successful. The caller must close its body.
+func (rc *RESTClient) send(method, path string, query url.Values, in interface{}) (*http.Response, error) {
+	req, err := rc.newRequest(method, path, query, in)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		restErr := &RESTError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(restErr); err != nil || restErr.Message == "" {
+			restErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, restErr
+	}
+	return resp, nil
+}
+
+// do sends the request, and decodes the response in out, if not nil
+func (rc *RESTClient) do(method, path string, query url.Values, in, out interface{}) error {
+	resp, err := rc.send(method, path, query, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func containerPath(name string, items ...string) string {
+	return "/containers/" + url.PathEscape(name) + strings.Join(append([]string{""}, items...), "/")
+}
+
+type restPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type restMount struct {
+	Type     string `json:"Type"`
+	Source   string `json:"Source"`
+	Target   string `json:"Target"`
+	ReadOnly bool   `json:"ReadOnly"`
+}
+
+type restCreate struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Hostname     string              `json:"Hostname,omitempty"`
+	Tty          bool                `json:"Tty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   struct {
+		Privileged      bool                         `json:"Privileged"`
+		NetworkMode     string                       `json:"NetworkMode,omitempty"`
+		PortBindings    map[string][]restPortBinding `json:"PortBindings,omitempty"`
+		PublishAllPorts bool                         `json:"PublishAllPorts"`
+		ExtraHosts      []string                     `json:"ExtraHosts,omitempty"`
+		Mounts          []restMount                  `json:"Mounts,omitempty"`
+		Binds           []string                     `json:"Binds,omitempty"`
+	} `json:"HostConfig"`
+}
+
+// toRESTCreate translates the podman container settings to the Docker ones. Only the settings pack8s uses are supported.
+func toRESTCreate(conf iopodman.Create) (restCreate, error) {
+	rcr := restCreate{}
+	if len(conf.Args) == 0 {
+		return rcr, fmt.Errorf("missing container image")
+	}
+	if conf.Pod != nil && *conf.Pod != "" {
+		return rcr, ErrPodsUnsupported
+	}
+
+	rcr.Image = conf.Args[0]
+	rcr.Cmd = conf.Args[1:]
+	if conf.Command != nil {
+		rcr.Cmd = *conf.Command
+	}
+	if conf.Entrypoint != nil && *conf.Entrypoint != "" {
+		rcr.Entrypoint = strings.Fields(*conf.Entrypoint)
+	}
+	if conf.Env != nil {
+		rcr.Env = *conf.Env
+	}
+	if conf.Label != nil {
+		rcr.Labels = make(map[string]string)
+		for _, label := range *conf.Label {
+			items := strings.SplitN(label, "=", 2)
+			rcr.Labels[items[0]] = ""
+			if len(items) == 2 {
+				rcr.Labels[items[0]] = items[1]
+			}
+		}
+	}
+	if conf.WorkDir != nil {
+		rcr.WorkingDir = *conf.WorkDir
+	}
+	if conf.Hostname != nil {
+		rcr.Hostname = *conf.Hostname
+	}
+	if conf.Tty != nil {
+		rcr.Tty = *conf.Tty
+	}
+
+	hc := &rcr.HostConfig
+	if conf.Privileged != nil {
+		hc.Privileged = *conf.Privileged
+	}
+	if conf.Network != nil {
+		hc.NetworkMode = *conf.Network
+	}
+	if conf.PublishAll != nil {
+		hc.PublishAllPorts = *conf.PublishAll
+	}
+	if conf.AddHost != nil {
+		hc.ExtraHosts = *conf.AddHost
+	}
+	if conf.Volume != nil {
+		hc.Binds = *conf.Volume
+	}
+	if conf.Mount != nil {
+		for _, spec := range *conf.Mount {
+			mnt, err := parseMount(spec)
+			if err != nil {
+				return rcr, err
+			}
+			hc.Mounts = append(hc.Mounts, mnt)
+		}
+	}
+
+	rcr.ExposedPorts = make(map[string]struct{})
+	if conf.Expose != nil {
+		for _, port := range *conf.Expose {
+			rcr.ExposedPorts[withProtocol(port)] = struct{}{}
+		}
+	}
+	if conf.Publish != nil {
+		hc.PortBindings = make(map[string][]restPortBinding)
+		for _, spec := range *conf.Publish {
+			port, binding, err := parsePublish(spec)
+			if err != nil {
+				return rcr, err
+			}
+			rcr.ExposedPorts[port] = struct{}{}
+			hc.PortBindings[port] = append(hc.PortBindings[port], binding)
+		}
+	}
+	return rcr, nil
+}
+
+// parseMount parses mounts like "type=bind,source=/data,destination=/data/nfs"
+func parseMount(spec string) (restMount, error) {
+	mnt := restMount{}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		val := ""
+		if len(kv) == 2 {
+			val = kv[1]
+		}
+		switch kv[0] {
+		case "type":
+			mnt.Type = val
+		case "source", "src":
+			mnt.Source = val
+		case "destination", "dst", "target":
+			mnt.Target = val
+		case "ro", "readonly":
+			mnt.ReadOnly = val == "" || val == "true"
+		}
+	}
+	if mnt.Type == "" || mnt.Target == "" {
+		return mnt, fmt.Errorf("malformed mount: %s", spec)
+	}
+	return mnt, nil
+}
+
+// parsePublish parses published ports like "[ip:][hostPort]:containerPort[/protocol]"
+func parsePublish(spec string) (string, restPortBinding, error) {
+	binding := restPortBinding{}
+	items := strings.Split(spec, ":")
+	switch len(items) {
+	case 1:
+	case 2:
+		binding.HostPort = items[0]
+	case 3:
+		binding.HostIP, binding.HostPort = items[0], items[1]
+	default:
+		return "", binding, fmt.Errorf("malformed published port: %s", spec)
+	}
+	return withProtocol(items[len(items)-1]), binding, nil
+}
+
+func withProtocol(port string) string {
+	if strings.Contains(port, "/") {
+		return port
+	}
+	return port + "/tcp"
+}
+
+func (rc *RESTClient) CreateContainer(conf iopodman.Create) (string, error) {
+	rcr, err := toRESTCreate(conf)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	if conf.Name != nil {
+		query.Set("name", *conf.Name)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = rc.do(http.MethodPost, "/containers/create", query, rcr, &created)
+	return created.ID, err
+}
+
+func (rc *RESTClient) StartContainer(contID string) (string, error) {
+	return contID, rc.do(http.MethodPost, containerPath(contID, "start"), nil, nil, nil)
+}
+
+func (rc *RESTClient) StopContainer(name string, timeout int64) (string, error) {
+	query := url.Values{}
+	query.Set("t", strconv.FormatInt(timeout, 10))
+	return name, rc.do(http.MethodPost, containerPath(name, "stop"), query, nil, nil)
+}
+
+func (rc *RESTClient) RemoveContainer(cont iopodman.Container, force, removeVolumes bool) (string, error) {
+	rc.log.Infof("trying to remove: %s (%s) force=%v removeVolumes=%v\n", cont.Names, cont.Id, force, removeVolumes)
+	query := url.Values{}
+	query.Set("force", strconv.FormatBool(force))
+	query.Set("v", strconv.FormatBool(removeVolumes))
+	return cont.Id, rc.do(http.MethodDelete, containerPath(cont.Id), query, nil, nil)
+}
+
+type restContainer struct {
+	ID      string   `json:"Id"`
+	Names   []string `json:"Names"`
+	Image   string   `json:"Image"`
+	ImageID string   `json:"ImageID"`
+	Command string   `json:"Command"`
+	Created int64    `json:"Created"`
+	State   string   `json:"State"`
+	Ports   []struct {
+		IP          string `json:"IP"`
+		PrivatePort uint16 `json:"PrivatePort"`
+		PublicPort  uint16 `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	Labels map[string]string `json:"Labels"`
+	Mounts []struct {
+		Type        string `json:"Type"`
+		Source      string `json:"Source"`
+		Destination string `json:"Destination"`
+	} `json:"Mounts"`
+}
+
+func (rcont restContainer) toContainer() iopodman.Container {
+	cont := iopodman.Container{
+		Id:               rcont.ID,
+		Image:            rcont.Image,
+		Imageid:          rcont.ImageID,
+		Command:          strings.Fields(rcont.Command),
+		Createdat:        time.Unix(rcont.Created, 0).Format(time.RFC3339),
+		Status:           rcont.State,
+		Labels:           rcont.Labels,
+		Containerrunning: rcont.State == "running",
+	}
+	if len(rcont.Names) > 0 {
+		cont.Names = strings.TrimPrefix(rcont.Names[0], "/")
+	}
+	for _, port := range rcont.Ports {
+		if port.PublicPort == 0 {
+			continue
+		}
+		cont.Ports = append(cont.Ports, iopodman.ContainerPortMappings{
+			Host_port:      strconv.Itoa(int(port.PublicPort)),
+			Host_ip:        port.IP,
+			Protocol:       port.Type,
+			Container_port: strconv.Itoa(int(port.PrivatePort)),
+		})
+	}
+	for _, mnt := range rcont.Mounts {
+		cont.Mounts = append(cont.Mounts, iopodman.ContainerMount{
+			Destination: mnt.Destination,
+			Type:        mnt.Type,
+			Source:      mnt.Source,
+		})
+	}
+	return cont
+}
+
+func (rc *RESTClient) GetPrefixedContainers(prefix string) ([]iopodman.Container, error) {
+	query := url.Values{}
+	query.Set("all", "true")
+	var rconts []restContainer
+	if err := rc.do(http.MethodGet, "/containers/json", query, nil, &rconts); err != nil {
+		return []iopodman.Container{}, err
+	}
+
+	rc.log.Infof("found %d containers in the system - prefix=[%s]", len(rconts), prefix)
+	ret := []iopodman.Container{}
+	for _, rcont := range rconts {
+		cont := rcont.toContainer()
+		if strings.HasPrefix(cont.Names, prefix) {
+			rc.log.Debugf("matching container: %s (%s)\n", cont.Names, cont.Id)
+			ret = append(ret, cont)
+		}
+	}
+	return ret, nil
+}
+
+func (rc *RESTClient) FindPrefixedContainer(prefixedName string) (iopodman.Container, error) {
+	containers, err := rc.GetPrefixedContainers(prefixedName)
+	if err != nil {
+		return iopodman.Container{}, err
+	}
+	if len(containers) != 1 {
+		return iopodman.Container{}, fmt.Errorf("failed to found the container with name %s", prefixedName)
+	}
+	return containers[0], nil
+}
+
+// WaitContainer waits for the container to exit, and returns its exit code. The interval is not needed.
+func (rc *RESTClient) WaitContainer(name string, interval int64) (int64, error) {
+	var reply struct {
+		StatusCode int64 `json:"StatusCode"`
+	}
+	err := rc.do(http.MethodPost, containerPath(name, "wait"), nil, nil, &reply)
+	return reply.StatusCode, err
+}
+
+type restInspect struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Image      string            `json:"Image"`
+		Tty        bool              `json:"Tty"`
+		Env        []string          `json:"Env"`
+		Cmd        []string          `json:"Cmd"`
+		Entrypoint []string          `json:"Entrypoint"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+	HostConfig struct {
+		Privileged  bool     `json:"Privileged"`
+		NetworkMode string   `json:"NetworkMode"`
+		ExtraHosts  []string `json:"ExtraHosts"`
+	} `json:"HostConfig"`
+	Mounts []ContainerMount `json:"Mounts"`
+	State  ContainerState   `json:"State"`
+}
+
+func (rc *RESTClient) inspect(name string) (restInspect, error) {
+	var ri restInspect
+	err := rc.do(http.MethodGet, containerPath(name, "json"), nil, nil, &ri)
+	return ri, err
+}
+
+func (rc *RESTClient) InspectContainer(name string) (ContainerInspect, error) {
+	ri, err := rc.inspect(name)
+	if err != nil {
+		return ContainerInspect{}, err
+	}
+
+	inspect := ContainerInspect{
+		ImageName: ri.Config.Image,
+		Mounts:    ri.Mounts,
+	}
+	inspect.Config.Env = ri.Config.Env
+	inspect.Config.Cmd = ri.Config.Cmd
+	inspect.Config.Entrypoint = strings.Join(ri.Config.Entrypoint, " ")
+	inspect.Config.WorkingDir = ri.Config.WorkingDir
+	inspect.Config.Labels = ri.Config.Labels
+	inspect.HostConfig.Privileged = ri.HostConfig.Privileged
+	inspect.HostConfig.NetworkMode = ri.HostConfig.NetworkMode
+	inspect.HostConfig.ExtraHosts = ri.HostConfig.ExtraHosts
+	return inspect, nil
+}
+
+func (rc *RESTClient) GetContainerState(name string) (ContainerState, error) {
+	ri, err := rc.inspect(name)
+	return ri.State, err
+}
+
+func (rc *RESTClient) Exec(container string, args []string, out io.Writer) error {
+	return rc.ExecWithOptions(container, args, ExecOptions{
+		Stdout: out,
+		Stderr: os.Stderr,
+		Tty:    true,
+	})
+}
+
+type restExecConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Tty          bool     `json:"Tty"`
+	Cmd          []string `json:"Cmd"`
+	Env          []string `json:"Env,omitempty"`
+	WorkingDir   string   `json:"WorkingDir,omitempty"`
+	Privileged   bool     `json:"Privileged"`
+}
+
+// ExecWithOptions runs the command in the container, connected to the given streams.
+// If the command exits with a non-zero code, the returned error is an *ExitError.
+func (rc *RESTClient) ExecWithOptions(container string, args []string, opts ExecOptions) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := rc.do(http.MethodPost, containerPath(container, "exec"), nil, restExecConfig{
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          opts.Tty,
+		Cmd:          args,
+		Env:          opts.Env,
+		WorkingDir:   opts.Workdir,
+		Privileged:   true,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	conn, rd, err := rc.hijack("/exec/"+created.ID+"/start", map[string]bool{
+		"Detach": false,
+		"Tty":    opts.Tty,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-rc.ctx.Done():
+			// unblocks the reads below
+			conn.Close()
+		}
+	}()
+
+	if opts.Resize != nil {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case size, ok := <-opts.Resize:
+					if !ok {
+						return
+					}
+					query := url.Values{}
+					query.Set("h", strconv.Itoa(int(size.Height)))
+					query.Set("w", strconv.Itoa(int(size.Width)))
+					if err := rc.do(http.MethodPost, "/exec/"+created.ID+"/resize", query, nil, nil); err != nil {
+						rc.log.Warningf("cannot resize the terminal: %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	if opts.Stdin != nil {
+		// we can't interrupt a blocked read, so this goroutine may outlive the exec session.
+		go func() {
+			_, err := io.Copy(conn, opts.Stdin)
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err != nil {
+				rc.log.Warningf("cannot forward the input: %v", err)
+				return
+			}
+			// let the command know the input is over
+			if uc, ok := conn.(*net.UnixConn); ok {
+				if err := uc.CloseWrite(); err != nil {
+					rc.log.Warningf("cannot close the input: %v", err)
+				}
+			}
+		}()
+	}
+
+	stdout, stderr := orDiscard(opts.Stdout), orDiscard(opts.Stderr)
+	if opts.Tty {
+		_, err = io.Copy(stdout, rd)
+	} else {
+		err = demuxStreams(rd, stdout, stderr)
+	}
+	if err != nil && rc.ctx.Err() == nil {
+		return err
+	}
+	if rc.ctx.Err() != nil {
+		return rc.ctx.Err()
+	}
+
+	rcode, err := rc.execExitCode(created.ID)
+	if err != nil {
+		return err
+	}
+	if rcode != 0 {
+		return &ExitError{Container: container, Code: rcode}
+	}
+	return nil
+}
+
+// hijack sends the request and takes over the connection, to stream the input and the output of an exec session
+func (rc *RESTClient) hijack(path string, in interface{}) (net.Conn, *bufio.Reader, error) {
+	req, err := rc.newRequest(http.MethodPost, path, nil, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := rc.client.Transport.(*http.Transport).DialContext(rc.ctx, "unix", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		restErr := &RESTError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(restErr); err != nil || restErr.Message == "" {
+			restErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, nil, restErr
+	}
+	return conn, rd, nil
+}
+
+// execExitCode returns the exit code of the given exec session, waiting for it to be recorded
+func (rc *RESTClient) execExitCode(execID string) (int, error) {
+	for {
+		var reply struct {
+			Running  bool `json:"Running"`
+			ExitCode int  `json:"ExitCode"`
+		}
+		if err := rc.do(http.MethodGet, "/exec/"+execID+"/json", nil, nil, &reply); err != nil {
+			return 0, err
+		}
+		if !reply.Running {
+			return reply.ExitCode, nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-rc.ctx.Done():
+			return 0, rc.ctx.Err()
+		}
+	}
+}
+
+// demuxStreams splits the output of an exec session without a terminal: each frame is preceded by
+// a header telling the stream it belongs to, and its size.
+func demuxStreams(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		dest := stdout
+		if header[0] == 2 {
+			dest = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dest, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return ioutil.Discard
+	}
+	return w
+}
+
+// lineWriter splits what is written in lines, which are emitted without the line terminators
+type lineWriter struct {
+	buf  []byte
+	emit func(line string) error
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(w.buf[:idx]), "\r")
+		w.buf = w.buf[idx+1:]
+		if err := w.emit(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flush emits the last line, if it has no terminator
+func (w *lineWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.emit(line)
+}
+
+// containerLogs sends the lines logged by the given container to handle. With timestamps, the lines
+// are prefixed by the time they were logged at, which is moved in the LogLine.
+func (rc *RESTClient) containerLogs(name string, opts LogOptions, timestamps bool, handle LogHandler) error {
+	ri, err := rc.inspect(name)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if timestamps {
+		query.Set("timestamps", "1")
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(opts.Since.Unix(), 10))
+	}
+	resp, err := rc.send(http.MethodGet, containerPath(name, "logs"), query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	emitter := func(device string) *lineWriter {
+		return &lineWriter{
+			emit: func(msg string) error {
+				line := iopodman.LogLine{
+					Device:       device,
+					ParseLogType: "F",
+					Msg:          msg,
+					Cid:          ri.ID,
+				}
+				if timestamps {
+					items := strings.SplitN(msg, " ", 2)
+					line.Time = items[0]
+					line.Msg = ""
+					if len(items) == 2 {
+						line.Msg = items[1]
+					}
+				}
+				return handle(line)
+			},
+		}
+	}
+	stdout, stderr := emitter(LogStdout), emitter(LogStderr)
+	if ri.Config.Tty {
+		_, err = io.Copy(stdout, resp.Body)
+	} else {
+		err = demuxStreams(resp.Body, stdout, stderr)
+	}
+	if rc.ctx.Err() != nil {
+		return rc.ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if err := stdout.flush(); err != nil {
+		return err
+	}
+	return stderr.flush()
+}
+
+// GetContainerLogs returns all the lines logged by the given container
+func (rc *RESTClient) GetContainerLogs(name string) ([]string, error) {
+	lines := []string{}
+	err := rc.containerLogs(name, LogOptions{}, false, func(line iopodman.LogLine) error {
+		lines = append(lines, line.Msg)
+		return nil
+	})
+	return lines, err
+}
+
+// GetContainersLogs sends the lines logged by the given containers to handle, as soon as they are read.
+// The Docker API has no logs of more containers, so the logs of each container are read concurrently.
+func (rc *RESTClient) GetContainersLogs(names []string, opts LogOptions, handle LogHandler) error {
+	var lock sync.Mutex
+	tasks := []parallel.Task{}
+	for _, name := range names {
+		name := name
+		tasks = append(tasks, func(ctx context.Context) error {
+			crc := rc.Clone(ctx).(*RESTClient)
+			return crc.containerLogs(name, opts, true, func(line iopodman.LogLine) error {
+				lock.Lock()
+				defer lock.Unlock()
+				return handle(line)
+			})
+		})
+	}
+	return parallel.Run(rc.ctx, tasks...)
+}
+
+type restVolume struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	Mountpoint string            `json:"Mountpoint"`
+	Labels     map[string]string `json:"Labels"`
+	Options    map[string]string `json:"Options"`
+}
+
+func (rc *RESTClient) CreateNamedVolume(name string) (string, error) {
+	var vol restVolume
+	err := rc.do(http.MethodPost, "/volumes/create", nil, map[string]string{"Name": name}, &vol)
+	return vol.Name, err
+}
+
+func (rc *RESTClient) GetAllVolumes() ([]iopodman.Volume, error) {
+	var reply struct {
+		Volumes []restVolume `json:"Volumes"`
+	}
+	if err := rc.do(http.MethodGet, "/volumes", nil, nil, &reply); err != nil {
+		return nil, err
+	}
+	volumes := []iopodman.Volume{}
+	for _, vol := range reply.Volumes {
+		volumes = append(volumes, iopodman.Volume{
+			Name:       vol.Name,
+			Labels:     vol.Labels,
+			MountPoint: vol.Mountpoint,
+			Driver:     vol.Driver,
+			Options:    vol.Options,
+		})
+	}
+	return volumes, nil
+}
+
+func (rc *RESTClient) GetPrefixedVolumes(prefix string) ([]iopodman.Volume, error) {
+	ret := []iopodman.Volume{}
+	volumes, err := rc.GetAllVolumes()
+	if err != nil {
+		return ret, err
+	}
+
+	rc.log.Infof("found %d volumes in the system", len(volumes))
+	for _, vol := range volumes {
+		if strings.HasPrefix(vol.Name, prefix) {
+			rc.log.Debugf("matching volume: %s @(%s)\n", vol.Name, vol.MountPoint)
+			ret = append(ret, vol)
+		}
+	}
+	return ret, nil
+}
+
+func (rc *RESTClient) RemoveVolumes(volumes []iopodman.Volume) error {
+	query := url.Values{}
+	query.Set("force", "true")
+	for _, vol := range volumes {
+		rc.log.Infof("removing volume %s @%s", vol.Name, vol.MountPoint)
+		if err := rc.do(http.MethodDelete, "/volumes/"+url.PathEscape(vol.Name), query, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneVolumes removes all unused volumes on the host.
+func (rc *RESTClient) PruneVolumes() error {
+	return rc.do(http.MethodPost, "/volumes/prune", nil, nil, nil)
+}
+
+func (rc *RESTClient) CreatePod(conf iopodman.PodCreate) (string, error) {
+	return "", ErrPodsUnsupported
+}
+
+func (rc *RESTClient) StopPod(name string, timeout int64) (string, error) {
+	return "", ErrPodsUnsupported
+}
+
+func (rc *RESTClient) RemovePod(name string, force bool) (string, error) {
+	return "", ErrPodsUnsupported
+}
+
+// FindPod never finds pods, so the callers look for the containers instead
+func (rc *RESTClient) FindPod(name string) (iopodman.ListPodData, bool, error) {
+	return iopodman.ListPodData{}, false, nil
+}
+
+func (rc *RESTClient) GetPodPorts(pod iopodman.ListPodData) ([]iopodman.ContainerPortMappings, error) {
+	return nil, ErrPodsUnsupported
+}
+
+// PullImage pulls the given image, retrying as configured with SetPullConfig.
+func (rc *RESTClient) PullImage(ref string) error {
+	return rc.pullImageWithRetry(ref, rc.config.Retry)
+}
+
+// PullImages pulls the given images concurrently.
+func (rc *RESTClient) PullImages(reqs []PullRequest) error {
+	return rc.pullAll(rc.ctx, reqs, func(ctx context.Context, req PullRequest) error {
+		return rc.Clone(ctx).(*RESTClient).pullImageWithRetry(req.Ref, req.Policy.Retry)
+	})
+}
+
+func (rc *RESTClient) PullClusterImages(reqs images.Requests, clusterRegistry, clusterImage string) error {
+	return rc.PullImages(rc.clusterRequests(reqs, clusterRegistry, clusterImage))
+}
+
+// RemoveImage removes the given image. Images still used by containers are kept, and an error is returned.
+func (rc *RESTClient) RemoveImage(name string) error {
+	rc.log.Infof("trying to remove image: %s\n", name)
+	return rc.do(http.MethodDelete, "/images/"+url.PathEscape(name), nil, nil, nil)
+}
+
+func (rc *RESTClient) pullImageWithRetry(ref string, retry images.Retry) error {
+	return rc.pullWithRetry(rc.ctx, ref, retry, rc.pullImage, func(ref string) (string, error) {
+		var img struct {
+			ID string `json:"Id"`
+		}
+		err := rc.do(http.MethodGet, "/images/"+url.PathEscape(ref)+"/json", nil, nil, &img)
+		return img.ID, err
+	})
+}
+
+// pullStatus is an item of the stream of messages sent while pulling an image
+type pullStatus struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Error          string `json:"error"`
+	ProgressDetail struct {
+		Current uint64 `json:"current"`
+		Total   uint64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+// layerStatus translates the status of a layer sent while pulling, and tells if it is about a layer
+func layerStatus(status string) (string, bool) {
+	switch {
+	case status == "Pulling fs layer" || status == "Waiting":
+		return pullprogress.StatusPending, true
+	case status == "Downloading":
+		return pullprogress.StatusDownloading, true
+	case status == "Download complete" || status == "Verifying Checksum" || status == "Extracting" || status == "Pull complete":
+		return pullprogress.StatusDone, true
+	case status == "Already exists":
+		return pullprogress.StatusSkipped, true
+	}
+	return "", false
+}
+
+// splitReference splits the image reference in the image name and the tag, or digest, as the API wants them
+func splitReference(ref string) (string, string) {
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		return ref[:idx], ref[idx+1:]
+	}
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return ref[:idx], ref[idx+1:]
+	}
+	return ref, "latest"
+}
+
+// pullImage pulls the image, tracking the download through the stream of messages the server sends meanwhile.
+func (rc *RESTClient) pullImage(interval time.Duration, ref string) error {
+	started := time.Now()
+	layers := []pullprogress.Layer{}
+	index := make(map[string]int)
+	report := func(done bool, err error) error {
+		progress := pullprogress.Progress{
+			Ref:     ref,
+			Layers:  make([]pullprogress.Layer, len(layers)),
+			Elapsed: time.Since(started),
+			Done:    done,
+		}
+		copy(progress.Layers, layers)
+		return rc.reporter.Report(progress, err)
+	}
+
+	name, tag := splitReference(ref)
+	query := url.Values{}
+	query.Set("fromImage", name)
+	query.Set("tag", tag)
+	resp, err := rc.send(http.MethodPost, "/images/create", query, nil)
+	if err != nil {
+		return report(false, err)
+	}
+	defer resp.Body.Close()
+
+	report(false, nil)
+	lastReport := started
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var st pullStatus
+		err := dec.Decode(&st)
+		if err == io.EOF {
+			return report(true, nil)
+		}
+		if err != nil {
+			return report(false, err)
+		}
+		if st.Error != "" {
+			return report(false, errors.New(st.Error))
+		}
+
+		if status, ok := layerStatus(st.Status); ok && st.ID != "" {
+			idx, found := index[st.ID]
+			if !found {
+				idx = len(layers)
+				index[st.ID] = idx
+				layers = append(layers, pullprogress.Layer{ID: st.ID})
+			}
+			layer := &layers[idx]
+			layer.Status = status
+			switch status {
+			case pullprogress.StatusDownloading:
+				layer.Current, layer.Total = st.ProgressDetail.Current, st.ProgressDetail.Total
+			case pullprogress.StatusDone, pullprogress.StatusSkipped:
+				layer.Current = layer.Total
+			}
+		}
+
+		if now := time.Now(); now.Sub(lastReport) >= interval {
+			report(false, nil)
+			lastReport = now
+		}
+	}
+}
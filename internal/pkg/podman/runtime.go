@@ -0,0 +1,196 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/parallel"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+const (
+	// RuntimeVarlink talks to the io.podman varlink interface
+	RuntimeVarlink string = "varlink"
+	// RuntimeDocker talks to the Docker-compatible REST API podman serves
+	RuntimeDocker string = "docker"
+
+	DefaultRuntime string = RuntimeVarlink
+)
+
+// Runtimes lists the supported container runtimes
+var Runtimes = []string{RuntimeVarlink, RuntimeDocker}
+
+// Runtime is the container engine pack8s runs the clusters on. The podman data types are used
+// regardless of the implementation.
+type Runtime interface {
+	CreateContainer(conf iopodman.Create) (string, error)
+	StartContainer(contID string) (string, error)
+	StopContainer(name string, timeout int64) (string, error)
+	RemoveContainer(cont iopodman.Container, force, removeVolumes bool) (string, error)
+	GetPrefixedContainers(prefix string) ([]iopodman.Container, error)
+	FindPrefixedContainer(prefixedName string) (iopodman.Container, error)
+	WaitContainer(name string, interval int64) (int64, error)
+	InspectContainer(name string) (ContainerInspect, error)
+	GetContainerState(name string) (ContainerState, error)
+
+	Exec(container string, args []string, out io.Writer) error
+	ExecWithOptions(container string, args []string, opts ExecOptions) error
+
+	GetContainerLogs(name string) ([]string, error)
+	GetContainersLogs(names []string, opts LogOptions, handle LogHandler) error
+
+	CreateNamedVolume(name string) (string, error)
+	GetPrefixedVolumes(prefix string) ([]iopodman.Volume, error)
+	GetAllVolumes() ([]iopodman.Volume, error)
+	RemoveVolumes(volumes []iopodman.Volume) error
+	PruneVolumes() error
+
+	CreatePod(conf iopodman.PodCreate) (string, error)
+	StopPod(name string, timeout int64) (string, error)
+	RemovePod(name string, force bool) (string, error)
+	FindPod(name string) (iopodman.ListPodData, bool, error)
+	GetPodPorts(pod iopodman.ListPodData) ([]iopodman.ContainerPortMappings, error)
+
+	PullImage(ref string) error
+	PullClusterImages(reqs images.Requests, clusterRegistry, clusterImage string) error
+	RemoveImage(name string) error
+	SetPullConfig(conf images.PullConfig)
+	SetPullReporter(reporter PullProgressReporter)
+
+	// Clone returns a new Runtime bound to the given context. The calls of the Runtime and of its clones
+	// can run concurrently.
+	Clone(ctx context.Context) Runtime
+	Close() error
+}
+
+var _ Runtime = &Handle{}
+
+// IsKnownRuntime tells if the given runtime is supported
+func IsKnownRuntime(name string) bool {
+	for _, rt := range Runtimes {
+		if rt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRuntime connects to the given runtime through the given socket. An empty socket selects
+// the default socket of the runtime.
+func NewRuntime(ctx context.Context, name, socket string, log *logger.Logger) (Runtime, error) {
+	switch name {
+	case RuntimeVarlink, "":
+		return NewHandle(ctx, socket, log)
+	case RuntimeDocker:
+		return NewRESTClient(ctx, socket, log)
+	}
+	return nil, fmt.Errorf("unknown runtime: %s (supported: %v)", name, Runtimes)
+}
+
+// puller implements the image pulls of all the runtimes, on top of their single pull attempts
+type puller struct {
+	reporter PullProgressReporter
+	config   images.PullConfig
+	log      *logger.Logger
+}
+
+// pullAttempt tries once to pull the given image, reporting its progress at most once per interval
+type pullAttempt func(interval time.Duration, ref string) error
+
+func newPuller(log *logger.Logger) puller {
+	return puller{
+		reporter: newPullProgressReporter(log),
+		config:   images.DefaultPullConfig(),
+		log:      log,
+	}
+}
+
+// SetPullConfig sets how the images are pulled
+func (p *puller) SetPullConfig(conf images.PullConfig) {
+	p.config = conf
+}
+
+// SetPullReporter sets who is told about the progress of the pulls
+func (p *puller) SetPullReporter(reporter PullProgressReporter) {
+	p.reporter = reporter
+}
+
+// PullRequest is an image to pull, along with how to pull it
+type PullRequest struct {
+	Ref    string
+	Policy images.PullPolicy
+}
+
+// clusterRequests returns the requests to pull the provider image and the auxiliary images the cluster wants
+func (p *puller) clusterRequests(reqs images.Requests, clusterRegistry, clusterImage string) []PullRequest {
+	pullReqs := []PullRequest{
+		PullRequest{
+			Ref:    clusterRegistry + "/" + clusterImage,
+			Policy: p.config.Policy(images.RoleProvider),
+		},
+	}
+	auxImages := reqs.AuxImages()
+	for _, role := range images.WantedRoles(reqs) {
+		pullReqs = append(pullReqs, PullRequest{
+			Ref:    auxImages.Get(role),
+			Policy: p.config.Policy(role),
+		})
+	}
+	return pullReqs
+}
+
+// pullAll runs the given pull for all the requests concurrently, at most config.Parallelism at the same time.
+// If a required image can't be pulled, the other pulls are canceled, while optional images only cause a warning.
+func (p *puller) pullAll(ctx context.Context, reqs []PullRequest, pull func(ctx context.Context, req PullRequest) error) error {
+	tasks := []parallel.Task{}
+	for _, req := range reqs {
+		req := req
+		tasks = append(tasks, func(ctx context.Context) error {
+			err := pull(ctx, req)
+			if err != nil && req.Policy.Optional && ctx.Err() == nil {
+				p.log.Warningf("cannot download the optional image %s: %v", req.Ref, err)
+				return nil
+			}
+			return err
+		})
+	}
+	return parallel.RunLimited(ctx, p.config.Parallelism, tasks...)
+}
+
+// pullWithRetry runs attempt until it succeeds, or the retries are exhausted. Then it falls back
+// to the local image, if localImageID finds one.
+func (p *puller) pullWithRetry(ctx context.Context, ref string, retry images.Retry, attempt pullAttempt, localImageID func(ref string) (string, error)) error {
+	p.log.Noticef("pulling image: %s", ref)
+
+	interval := p.reporter.GetInterval() * time.Second
+	delays := retry.Delays()
+	var err error
+	for idx, delay := range delays {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		p.log.Infof("attempt #%d to download '%s' - progress every %v\n", idx, ref, interval)
+		err = attempt(interval, ref)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	// hosts without registry access may have the image loaded from a bundle
+	if id, localErr := localImageID(ref); localErr == nil {
+		p.log.Warningf("cannot download %s, using the local image %s", ref, id)
+		return nil
+	}
+	return fmt.Errorf("failed to download %s %d times, giving up: %v", ref, len(delays), err)
+}
@@ -0,0 +1,174 @@
+package podman_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+// runtimeConformance describes the behaviour all the runtimes must share. The specs are skipped
+// if the socket of the runtime is not available.
+func runtimeConformance(name, socket string) bool {
+	return Describe("runtime "+name, func() {
+		var rt podman.Runtime
+
+		BeforeEach(func() {
+			if _, err := os.Stat(strings.TrimPrefix(socket, "unix:")); err != nil {
+				Skip("socket not available: " + socket)
+			}
+			var err error
+			rt, err = podman.NewRuntime(context.Background(), name, socket, NewLogger())
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			if rt != nil {
+				rt.Close()
+			}
+		})
+
+		createContainer := func(name string, args ...string) string {
+			labels := []string{podman.LabelGeneration + "=042"}
+			publish := []string{"127.0.0.1:15000:5000"}
+			id, err := rt.CreateContainer(iopodman.Create{
+				Args:    append([]string{images.DockerRegistryImage}, args...),
+				Name:    &name,
+				Label:   &labels,
+				Publish: &publish,
+			})
+			Expect(err).To(BeNil())
+			return id
+		}
+
+		It("Should create, list and remove volumes", func() {
+			_, err := rt.CreateNamedVolume("pack8s-conformance-1")
+			Expect(err).To(BeNil())
+			_, err = rt.CreateNamedVolume("pack8s-conformance-2")
+			Expect(err).To(BeNil())
+
+			volumes, err := rt.GetPrefixedVolumes("pack8s-conformance")
+			Expect(err).To(BeNil())
+			Expect(len(volumes)).To(Equal(2))
+
+			err = rt.RemoveVolumes(volumes)
+			Expect(err).To(BeNil())
+
+			volumes, err = rt.GetPrefixedVolumes("pack8s-conformance")
+			Expect(err).To(BeNil())
+			Expect(volumes).To(BeEmpty())
+		})
+
+		It("Should pull images", func() {
+			err := rt.PullImage(images.DockerRegistryImage)
+			Expect(err).To(BeNil())
+		})
+
+		It("Should run containers", func() {
+			err := rt.PullImage(images.DockerRegistryImage)
+			Expect(err).To(BeNil())
+
+			id := createContainer("pack8s-conformance", "sleep", "300")
+			_, err = rt.StartContainer(id)
+			Expect(err).To(BeNil())
+
+			cont, err := rt.FindPrefixedContainer("pack8s-conformance")
+			Expect(err).To(BeNil())
+			Expect(cont.Id).To(Equal(id))
+			Expect(cont.Names).To(Equal("pack8s-conformance"))
+			Expect(cont.Containerrunning).To(BeTrue())
+			Expect(cont.Labels[podman.LabelGeneration]).To(Equal("042"))
+			Expect(cont.Ports).To(ContainElement(iopodman.ContainerPortMappings{
+				Host_port:      "15000",
+				Host_ip:        "127.0.0.1",
+				Protocol:       "tcp",
+				Container_port: "5000",
+			}))
+
+			state, err := rt.GetContainerState(id)
+			Expect(err).To(BeNil())
+			Expect(state.Running).To(BeTrue())
+
+			inspect, err := rt.InspectContainer(id)
+			Expect(err).To(BeNil())
+			Expect(inspect.Config.Cmd).To(Equal([]string{"sleep", "300"}))
+			Expect(inspect.Config.Labels[podman.LabelGeneration]).To(Equal("042"))
+
+			_, err = rt.StopContainer(id, 1)
+			Expect(err).To(BeNil())
+			state, err = rt.GetContainerState(id)
+			Expect(err).To(BeNil())
+			Expect(state.Running).To(BeFalse())
+
+			_, err = rt.RemoveContainer(cont, true, true)
+			Expect(err).To(BeNil())
+			_, err = rt.GetContainerState(id)
+			Expect(podman.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Should exec commands", func() {
+			err := rt.PullImage(images.DockerRegistryImage)
+			Expect(err).To(BeNil())
+
+			id := createContainer("pack8s-conformance", "sleep", "300")
+			defer rt.RemoveContainer(iopodman.Container{Id: id}, true, true)
+			_, err = rt.StartContainer(id)
+			Expect(err).To(BeNil())
+
+			var stdout, stderr bytes.Buffer
+			err = rt.ExecWithOptions("pack8s-conformance", []string{"sh", "-c", "echo $GREETING; echo oops >&2"}, podman.ExecOptions{
+				Stdout: &stdout,
+				Stderr: &stderr,
+				Env:    []string{"GREETING=hello"},
+			})
+			Expect(err).To(BeNil())
+			Expect(stdout.String()).To(Equal("hello\n"))
+			Expect(stderr.String()).To(Equal("oops\n"))
+
+			stdout.Reset()
+			err = rt.ExecWithOptions("pack8s-conformance", []string{"cat"}, podman.ExecOptions{
+				Stdin:  strings.NewReader("from stdin"),
+				Stdout: &stdout,
+			})
+			Expect(err).To(BeNil())
+			Expect(stdout.String()).To(Equal("from stdin"))
+
+			err = rt.ExecWithOptions("pack8s-conformance", []string{"sh", "-c", "exit 3"}, podman.ExecOptions{})
+			exitErr, ok := err.(*podman.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(exitErr.Code).To(Equal(3))
+		})
+
+		It("Should wait for containers to exit", func() {
+			err := rt.PullImage(images.DockerRegistryImage)
+			Expect(err).To(BeNil())
+
+			id := createContainer("pack8s-conformance", "sh", "-c", "exit 7")
+			defer rt.RemoveContainer(iopodman.Container{Id: id}, true, true)
+			_, err = rt.StartContainer(id)
+			Expect(err).To(BeNil())
+
+			rc, err := rt.WaitContainer(id, 100)
+			Expect(err).To(BeNil())
+			Expect(rc).To(Equal(int64(7)))
+		})
+
+		It("Should not find missing pods", func() {
+			_, found, err := rt.FindPod("pack8s-conformance-missing")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+		})
+	})
+}
+
+var _ = runtimeConformance(podman.RuntimeVarlink, podman.DefaultSocket)
+
+var _ = runtimeConformance(podman.RuntimeDocker, podman.DefaultRESTSocket)
@@ -0,0 +1,52 @@
+package podman
+
+import (
+	"sync"
+
+	logger "github.com/apsdehal/go-logger"
+
+	"github.com/fromanirh/pack8s/internal/pkg/sshtunnel"
+)
+
+// tunnels holds the tunnels to the remote sockets. They are opened once, and last as long as pack8s runs.
+var tunnels = struct {
+	lock sync.Mutex
+	open map[string]*sshtunnel.Tunnel
+}{
+	open: make(map[string]*sshtunnel.Tunnel),
+}
+
+// localSocket returns the socket to connect to in order to reach the given one:
+// the sockets on remote hosts are reached through a tunnel over SSH.
+func localSocket(socket string, log *logger.Logger) (string, error) {
+	if !sshtunnel.IsRemote(socket) {
+		return socket, nil
+	}
+
+	tunnels.lock.Lock()
+	defer tunnels.lock.Unlock()
+	if t, ok := tunnels.open[socket]; ok {
+		return t.Socket(), nil
+	}
+	addr, err := sshtunnel.Parse(socket)
+	if err != nil {
+		return "", err
+	}
+	t, err := sshtunnel.Open(addr, sshtunnel.DefaultTimeout, log)
+	if err != nil {
+		return "", err
+	}
+	tunnels.open[socket] = t
+	return t.Socket(), nil
+}
+
+// CloseTunnels closes the tunnels to the remote sockets. Runtimes using them stop working.
+// Must be called before exiting: only on Linux the tunnels stop along with pack8s.
+func CloseTunnels() {
+	tunnels.lock.Lock()
+	defer tunnels.lock.Unlock()
+	for socket, t := range tunnels.open {
+		t.Close()
+		delete(tunnels.open, socket)
+	}
+}
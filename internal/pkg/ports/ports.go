@@ -72,6 +72,27 @@ func NameToNumber(name string) (int, error) {
 	}
 }
 
+func NumberToName(port int) (string, error) {
+	switch port {
+	case PortSSH:
+		return PortNameSSH, nil
+	case PortSSHWorker:
+		return PortNameSSHWorker, nil
+	case PortAPI:
+		return PortNameAPI, nil
+	case PortRegistry:
+		return PortNameRegistry, nil
+	case PortOCP:
+		return PortNameOCP, nil
+	case PortOCPConsole:
+		return PortNameOCPConsole, nil
+	case PortVNC:
+		return PortNameVNC, nil
+	default:
+		return "", fmt.Errorf("unknown port: %d", port)
+	}
+}
+
 func ToStrings(ports ...int) []string {
 	res := []string{}
 	for _, port := range ports {
@@ -83,22 +104,38 @@ func ToStrings(ports ...int) []string {
 type PortInfo struct {
 	ExposedPort int
 	Name        string
+	PublicPort  uint
 }
 
 type PortMapping struct {
 	data []iopodman.ContainerPortMappings
 }
 
+// NewMapping creates a PortMapping publishing all the given ports which have a non-zero PublicPort.
+func NewMapping(portInfos []PortInfo) PortMapping {
+	pm := PortMapping{}
+	for _, info := range portInfos {
+		if info.PublicPort != 0 {
+			pm.appendPort(info.ExposedPort, info.PublicPort)
+		}
+	}
+	return pm
+}
+
 func NewMappingFromFlags(flagSet *pflag.FlagSet, portInfos []PortInfo) (PortMapping, error) {
 	pm := PortMapping{}
-	var err error
 	for _, info := range portInfos {
-		err = pm.appendPort(info.ExposedPort, flagSet, info.Name)
+		flag := flagSet.Lookup(info.Name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		publicPort, err := flagSet.GetUint(info.Name)
 		if err != nil {
-			break
+			return pm, err
 		}
+		pm.appendPort(info.ExposedPort, publicPort)
 	}
-	return pm, err
+	return pm, nil
 }
 
 func (pm PortMapping) ToStrings() []string {
@@ -109,22 +146,40 @@ func (pm PortMapping) ToStrings() []string {
 	return res
 }
 
-func (pm PortMapping) appendPort(exposedPort int, flagSet *pflag.FlagSet, flagName string) error {
-	flag := flagSet.Lookup(flagName)
-	if flag != nil && flag.Changed {
-		publicPort, err := flagSet.GetUint(flagName)
-		if err != nil {
-			return err
+// ToStringsWithRandom returns the same strings as ToStrings, plus the given exposed ports not
+// explicitely published, which are published on random host ports.
+func (pm PortMapping) ToStringsWithRandom(exposedPorts ...int) []string {
+	published := make(map[string]bool)
+	for _, pmItem := range pm.data {
+		published[pmItem.Container_port] = true
+	}
+	res := pm.ToStrings()
+	for _, port := range ToStrings(exposedPorts...) {
+		if !published[port] {
+			res = append(res, port)
 		}
+	}
+	return res
+}
 
-		pm.data = append(pm.data, iopodman.ContainerPortMappings{
-			Host_port:      strconv.Itoa(int(publicPort)),
-			Host_ip:        "127.0.0.1",
-			Protocol:       "tcp",
-			Container_port: strconv.Itoa(exposedPort),
-		})
+// HostPorts returns the ports explicitely published on the host
+func (pm PortMapping) HostPorts() []uint {
+	res := []uint{}
+	for _, pmItem := range pm.data {
+		if port, err := strconv.Atoi(pmItem.Host_port); err == nil {
+			res = append(res, uint(port))
+		}
 	}
-	return nil
+	return res
+}
+
+func (pm *PortMapping) appendPort(exposedPort int, publicPort uint) {
+	pm.data = append(pm.data, iopodman.ContainerPortMappings{
+		Host_port:      strconv.Itoa(int(publicPort)),
+		Host_ip:        "127.0.0.1",
+		Protocol:       "tcp",
+		Container_port: strconv.Itoa(exposedPort),
+	})
 }
 
 func GetPublicPort(port int, containerPorts []iopodman.ContainerPortMappings) (int, error) {
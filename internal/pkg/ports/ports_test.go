@@ -40,6 +40,27 @@ var _ = Describe("ports", func() {
 
 		})
 
+		It("Should convert port number to port name", func() {
+			portMap := map[int]string{
+				2201: ports.PortNameSSH,
+				2202: ports.PortNameSSHWorker,
+				5000: ports.PortNameRegistry,
+				8443: ports.PortNameOCP,
+				6443: ports.PortNameAPI,
+				5901: ports.PortNameVNC,
+				443:  ports.PortNameOCPConsole,
+			}
+			for portKey, portValue := range portMap {
+				res, err := ports.NumberToName(portKey)
+				Expect(res).To(Equal(portValue))
+				Expect(err).To(BeNil())
+			}
+
+			res, err := ports.NumberToName(143)
+			Expect(res).To(Equal(""))
+			Expect(err).NotTo(BeNil())
+		})
+
 		It("Should convert port number to string", func() {
 			res := ports.ToStrings(443, 22)
 
@@ -47,5 +68,23 @@ var _ = Describe("ports", func() {
 			Expect(res[0]).To(Equal("443"))
 			Expect(res[1]).To(Equal("22"))
 		})
+
+		It("Should report only the ports published on the host", func() {
+			pm := ports.NewMapping([]ports.PortInfo{
+				ports.PortInfo{ExposedPort: ports.PortSSH, Name: "ssh-port", PublicPort: 2201},
+				ports.PortInfo{ExposedPort: ports.PortAPI, Name: "k8s-port"},
+				ports.PortInfo{ExposedPort: ports.PortRegistry, Name: "registry-port", PublicPort: 5000},
+			})
+
+			Expect(pm.HostPorts()).To(Equal([]uint{2201, 5000}))
+		})
+
+		It("Should publish on random host ports the ports not explicitely published", func() {
+			pm := ports.NewMapping([]ports.PortInfo{
+				ports.PortInfo{ExposedPort: ports.PortSSH, Name: "ssh-port", PublicPort: 2201},
+			})
+
+			Expect(pm.ToStringsWithRandom(ports.PortSSH, ports.PortAPI)).To(Equal([]string{"2201:2201", "6443"}))
+		})
 	})
 })
@@ -0,0 +1,62 @@
+package prefixwriter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Writer prefixes every line written through it. Lines are written to the underlying
+// writer only once complete, so lines coming from different Writers sharing the same
+// underlying writer don't get mixed up.
+type Writer struct {
+	out    io.Writer
+	lock   *sync.Mutex
+	prefix []byte
+	buf    bytes.Buffer
+}
+
+// New creates a Writer. The lock, if not nil, is held while writing to the underlying writer,
+// and should be shared among all the Writers sharing the same underlying writer.
+func New(out io.Writer, lock *sync.Mutex, prefix string) *Writer {
+	return &Writer{
+		out:    out,
+		lock:   lock,
+		prefix: []byte(prefix),
+	}
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		if err := w.emit(w.buf.Next(idx + 1)); err != nil {
+			return len(data), err
+		}
+	}
+	return len(data), nil
+}
+
+// Close writes the last incomplete line, if any.
+func (w *Writer) Close() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := append(w.buf.Next(w.buf.Len()), '\n')
+	return w.emit(line)
+}
+
+func (w *Writer) emit(line []byte) error {
+	out := make([]byte, 0, len(w.prefix)+len(line))
+	out = append(out, w.prefix...)
+	out = append(out, line...)
+	if w.lock != nil {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+	}
+	_, err := w.out.Write(out)
+	return err
+}
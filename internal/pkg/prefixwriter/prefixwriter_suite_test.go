@@ -0,0 +1,13 @@
+package prefixwriter_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PrefixWriter Suite")
+}
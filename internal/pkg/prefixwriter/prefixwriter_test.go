@@ -0,0 +1,41 @@
+package prefixwriter_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/prefixwriter"
+)
+
+var _ = Describe("prefixwriter", func() {
+	Context("write", func() {
+		It("Should prefix complete lines only", func() {
+			var buf bytes.Buffer
+			w := prefixwriter.New(&buf, nil, "[node01] ")
+
+			fmt.Fprintf(w, "hello ")
+			Expect(buf.String()).To(Equal(""))
+			fmt.Fprintf(w, "world\nsecond line\nthird")
+			Expect(buf.String()).To(Equal("[node01] hello world\n[node01] second line\n"))
+
+			Expect(w.Close()).To(BeNil())
+			Expect(buf.String()).To(Equal("[node01] hello world\n[node01] second line\n[node01] third\n"))
+		})
+
+		It("Should not mix lines from different writers", func() {
+			var buf bytes.Buffer
+			lock := &sync.Mutex{}
+			w1 := prefixwriter.New(&buf, lock, "a: ")
+			w2 := prefixwriter.New(&buf, lock, "b: ")
+
+			fmt.Fprintf(w1, "one ")
+			fmt.Fprintf(w2, "two\n")
+			fmt.Fprintf(w1, "three\n")
+			Expect(buf.String()).To(Equal("b: two\na: one three\n"))
+		})
+	})
+})
@@ -0,0 +1,290 @@
+package pullprogress
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StatusPending marks the layers announced, but not yet downloading
+	StatusPending = "pending"
+	// StatusDownloading marks the layers being downloaded
+	StatusDownloading = "downloading"
+	// StatusDone marks the layers completely downloaded
+	StatusDone = "done"
+	// StatusSkipped marks the layers already in the local storage
+	StatusSkipped = "skipped"
+)
+
+var (
+	ansiRe  = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	blobRe  = regexp.MustCompile(`Copying (blob|config) (?:sha256:)?([0-9a-f]+)(.*)$`)
+	bytesRe = regexp.MustCompile(`([0-9.]+) ?([KMGT]?i?B) / ([0-9.]+) ?([KMGT]?i?B)`)
+)
+
+var units = map[string]float64{
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// Layer is the state of the download of an image layer, or of the image config
+type Layer struct {
+	// ID is the (short) digest of the layer
+	ID     string
+	Status string
+	// Current and Total are the bytes downloaded so far and the layer size. Total is zero while unknown.
+	Current uint64
+	Total   uint64
+}
+
+// Progress is the state of the download of an image
+type Progress struct {
+	Ref     string
+	Layers  []Layer
+	Elapsed time.Duration
+	Done    bool
+}
+
+// Bytes returns the bytes downloaded so far and the total bytes to download, for the layers whose size is known
+func (p Progress) Bytes() (uint64, uint64) {
+	var current, total uint64
+	for _, l := range p.Layers {
+		current += l.Current
+		total += l.Total
+	}
+	return current, total
+}
+
+// LayersDone returns the number of layers completely downloaded, or skipped
+func (p Progress) LayersDone() int {
+	done := 0
+	for _, l := range p.Layers {
+		if l.Status == StatusDone || l.Status == StatusSkipped {
+			done++
+		}
+	}
+	return done
+}
+
+// Percent returns the completion percentage of the download, or -1 if the size of the image is still unknown
+func (p Progress) Percent() int {
+	if p.Done {
+		return 100
+	}
+	current, total := p.Bytes()
+	if total == 0 {
+		return -1
+	}
+	return int(current * 100 / total)
+}
+
+// ETA returns the estimated time to complete the download, or zero if it can't be estimated yet
+func (p Progress) ETA() time.Duration {
+	current, total := p.Bytes()
+	if p.Done || current == 0 || total <= current {
+		return 0
+	}
+	rate := float64(current) / p.Elapsed.Seconds()
+	eta := time.Duration(float64(total-current)/rate) * time.Second
+	return eta.Round(time.Second)
+}
+
+// Board merges the progress of concurrent pulls. The completed pulls are kept,
+// so the merged progress doesn't go back, until all the pulls are completed.
+type Board struct {
+	lock  sync.Mutex
+	refs  []string
+	pulls map[string]Progress
+}
+
+// NewBoard returns an empty Board
+func NewBoard() *Board {
+	return &Board{
+		pulls: make(map[string]Progress),
+	}
+}
+
+// Has tells if the Board holds the pull of the given image
+func (b *Board) Has(ref string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, ok := b.pulls[ref]
+	return ok
+}
+
+// Update records the progress of a pull, and returns the merged progress of all the pulls.
+// Once all the pulls are completed, the Board is emptied.
+func (b *Board) Update(p Progress) Progress {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.pulls[p.Ref]; !ok {
+		b.refs = append(b.refs, p.Ref)
+	}
+	b.pulls[p.Ref] = p
+
+	merged := b.merge()
+	if merged.Done {
+		b.refs = nil
+		b.pulls = make(map[string]Progress)
+	}
+	return merged
+}
+
+// Remove forgets the pull of the given image, e.g. because it failed
+func (b *Board) Remove(ref string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.pulls[ref]; !ok {
+		return
+	}
+	delete(b.pulls, ref)
+	for idx, r := range b.refs {
+		if r == ref {
+			b.refs = append(b.refs[:idx], b.refs[idx+1:]...)
+			break
+		}
+	}
+}
+
+// merge returns the progress of all the pulls, named after the image if there is only one
+func (b *Board) merge() Progress {
+	if len(b.refs) == 1 {
+		return b.pulls[b.refs[0]]
+	}
+	merged := Progress{
+		Ref:  fmt.Sprintf("%d images", len(b.refs)),
+		Done: true,
+	}
+	for _, ref := range b.refs {
+		p := b.pulls[ref]
+		merged.Layers = append(merged.Layers, p.Layers...)
+		if p.Elapsed > merged.Elapsed {
+			merged.Elapsed = p.Elapsed
+		}
+		merged.Done = merged.Done && p.Done
+	}
+	return merged
+}
+
+// Tracker rebuilds the download progress of an image from the output podman emits while pulling it
+type Tracker struct {
+	ref     string
+	started time.Time
+	layers  []Layer
+	index   map[string]int
+}
+
+// NewTracker returns a Tracker for the download of ref, started at the given time
+func NewTracker(ref string, started time.Time) *Tracker {
+	return &Tracker{
+		ref:     ref,
+		started: started,
+		index:   make(map[string]int),
+	}
+}
+
+// Update parses the given output lines, and updates the state of the layers they mention
+func (t *Tracker) Update(lines []string) {
+	for _, line := range lines {
+		// progress bars redraw themselves using carriage returns and escape sequences: the last state wins
+		for _, item := range strings.Split(ansiRe.ReplaceAllString(line, ""), "\r") {
+			t.parse(strings.TrimSpace(item))
+		}
+	}
+}
+
+// Progress returns the state of the download at the given time
+func (t *Tracker) Progress(now time.Time) Progress {
+	layers := make([]Layer, len(t.layers))
+	copy(layers, t.layers)
+	return Progress{
+		Ref:     t.ref,
+		Layers:  layers,
+		Elapsed: now.Sub(t.started),
+	}
+}
+
+func (t *Tracker) parse(item string) {
+	match := blobRe.FindStringSubmatch(item)
+	if match == nil {
+		return
+	}
+	layer := t.layer(match[2])
+	rest := match[3]
+
+	if m := bytesRe.FindStringSubmatch(rest); m != nil {
+		current, errCur := ParseSize(m[1] + m[2])
+		total, errTot := ParseSize(m[3] + m[4])
+		if errCur == nil && errTot == nil {
+			layer.Current, layer.Total = current, total
+			layer.Status = StatusDownloading
+		}
+	}
+	switch {
+	case strings.Contains(rest, "skipped") || strings.Contains(rest, "already exists"):
+		layer.Status = StatusSkipped
+		layer.Current = layer.Total
+	case strings.Contains(rest, "done"):
+		layer.Status = StatusDone
+		layer.Current = layer.Total
+	}
+}
+
+// layer returns the layer with the given digest, adding it if missing.
+// Full and short digests identify the same layer.
+func (t *Tracker) layer(digest string) *Layer {
+	id := digest
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	idx, ok := t.index[id]
+	if !ok {
+		idx = len(t.layers)
+		t.index[id] = idx
+		t.layers = append(t.layers, Layer{ID: id, Status: StatusPending})
+	}
+	return &t.layers[idx]
+}
+
+// ParseSize parses sizes like "12.5MiB" or "300 B"
+func ParseSize(s string) (uint64, error) {
+	s = strings.Replace(s, " ", "", -1)
+	idx := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if idx <= 0 {
+		return 0, fmt.Errorf("malformed size %q", s)
+	}
+	mult, ok := units[s[idx:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit in %q", s)
+	}
+	val, err := strconv.ParseFloat(s[:idx], 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(val * mult), nil
+}
+
+// FormatSize returns the human readable form of the given size, e.g. "12.5MiB"
+func FormatSize(size uint64) string {
+	for _, unit := range []string{"TiB", "GiB", "MiB", "KiB"} {
+		if mult := units[unit]; float64(size) >= mult {
+			return fmt.Sprintf("%.1f%s", float64(size)/mult, unit)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
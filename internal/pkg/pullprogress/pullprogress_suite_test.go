@@ -0,0 +1,13 @@
+package pullprogress_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPullProgress(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PullProgress Suite")
+}
@@ -0,0 +1,119 @@
+package pullprogress_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/pullprogress"
+)
+
+var _ = Describe("pullprogress", func() {
+	started := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	Context("sizes", func() {
+		It("Should parse sizes", func() {
+			Expect(pullprogress.ParseSize("300B")).To(Equal(uint64(300)))
+			Expect(pullprogress.ParseSize("1.5 KiB")).To(Equal(uint64(1536)))
+			Expect(pullprogress.ParseSize("2MB")).To(Equal(uint64(2000000)))
+		})
+
+		It("Should reject malformed sizes", func() {
+			_, err := pullprogress.ParseSize("MiB")
+			Expect(err).NotTo(BeNil())
+			_, err = pullprogress.ParseSize("12parsecs")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("Should format sizes", func() {
+			Expect(pullprogress.FormatSize(512)).To(Equal("512B"))
+			Expect(pullprogress.FormatSize(12*1024*1024 + 512*1024)).To(Equal("12.5MiB"))
+		})
+	})
+
+	Context("tracker", func() {
+		It("Should follow the layers", func() {
+			t := pullprogress.NewTracker("docker.io/library/registry:2.7.1", started)
+			t.Update([]string{
+				"Getting image source signatures\n",
+				"Copying blob sha256:c87736221ed0bcaa60b8e92a19bec2284899ef89226f2a07968677cf59e637a4\n",
+				"Copying blob sha256:1cc8e0bb44df2fa3a3b5ef0c4b0a6fb8f0e2bd0d1b3ac0d4a1f0c6d3c1a2b3c4\n",
+			})
+			p := t.Progress(started.Add(2 * time.Second))
+			Expect(p.Layers).To(HaveLen(2))
+			Expect(p.Percent()).To(Equal(-1))
+
+			t.Update([]string{
+				"\x1b[1ACopying blob c87736221ed0 [===>------] 1.0MiB / 4.0MiB\r\x1b[1ACopying blob c87736221ed0 [=====>----] 2.0MiB / 4.0MiB\n",
+				"Copying blob 1cc8e0bb44df skipped: already exists\n",
+			})
+			p = t.Progress(started.Add(4 * time.Second))
+			Expect(p.Layers).To(HaveLen(2))
+			Expect(p.Layers[0].Status).To(Equal(pullprogress.StatusDownloading))
+			Expect(p.Layers[1].Status).To(Equal(pullprogress.StatusSkipped))
+			Expect(p.LayersDone()).To(Equal(1))
+			Expect(p.Percent()).To(Equal(50))
+			// 2MiB in 4s, 2MiB to go
+			Expect(p.ETA()).To(Equal(4 * time.Second))
+
+			t.Update([]string{
+				"Copying blob c87736221ed0 done\n",
+				"Writing manifest to image destination\n",
+			})
+			p = t.Progress(started.Add(6 * time.Second))
+			Expect(p.Percent()).To(Equal(100))
+			Expect(p.ETA()).To(Equal(time.Duration(0)))
+		})
+
+		It("Should report completion without sizes", func() {
+			t := pullprogress.NewTracker("docker.io/library/registry:2.7.1", started)
+			t.Update([]string{"Copying blob sha256:c87736221ed0\n"})
+			p := t.Progress(started.Add(time.Second))
+			Expect(p.Percent()).To(Equal(-1))
+			p.Done = true
+			Expect(p.Percent()).To(Equal(100))
+		})
+	})
+
+	Context("board", func() {
+		makeProgress := func(ref string, current, total uint64, done bool) pullprogress.Progress {
+			return pullprogress.Progress{
+				Ref:     ref,
+				Layers:  []pullprogress.Layer{{ID: ref, Status: pullprogress.StatusDownloading, Current: current, Total: total}},
+				Elapsed: time.Second,
+				Done:    done,
+			}
+		}
+
+		It("Should merge the pulls", func() {
+			b := pullprogress.NewBoard()
+			p := b.Update(makeProgress("provider", 10, 100, false))
+			Expect(p.Ref).To(Equal("provider"))
+			Expect(p.Percent()).To(Equal(10))
+
+			p = b.Update(makeProgress("registry", 50, 100, false))
+			Expect(p.Ref).To(Equal("2 images"))
+			Expect(p.Percent()).To(Equal(30))
+
+			p = b.Update(makeProgress("registry", 100, 100, true))
+			Expect(p.Done).To(BeFalse())
+			Expect(p.Percent()).To(Equal(55))
+			Expect(b.Has("registry")).To(BeTrue())
+
+			p = b.Update(makeProgress("provider", 100, 100, true))
+			Expect(p.Done).To(BeTrue())
+			Expect(b.Has("provider")).To(BeFalse())
+		})
+
+		It("Should forget the failed pulls", func() {
+			b := pullprogress.NewBoard()
+			b.Update(makeProgress("provider", 10, 100, false))
+			b.Update(makeProgress("registry", 50, 100, false))
+			b.Remove("registry")
+			p := b.Update(makeProgress("provider", 20, 100, false))
+			Expect(p.Ref).To(Equal("provider"))
+			Expect(p.Percent()).To(Equal(20))
+		})
+	})
+})
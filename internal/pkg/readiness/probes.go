@@ -0,0 +1,206 @@
+package readiness
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+)
+
+type execProbe struct {
+	rt        podman.Runtime
+	container string
+	args      []string
+	desc      string
+}
+
+// NewExec returns a Probe which succeeds if the given command succeeds inside the container.
+func NewExec(rt podman.Runtime, container string, args ...string) Probe {
+	return execProbe{
+		rt:        rt,
+		container: container,
+		args:      args,
+		desc:      fmt.Sprintf("command %q in %s", strings.Join(args, " "), container),
+	}
+}
+
+// NewFileExists returns a Probe which succeeds if the given path exists inside the container.
+func NewFileExists(rt podman.Runtime, container, path string) Probe {
+	return execProbe{
+		rt:        rt,
+		container: container,
+		args:      []string{"/bin/bash", "-c", fmt.Sprintf("test -f %s", path)},
+		desc:      fmt.Sprintf("file %s exists in %s", path, container),
+	}
+}
+
+// Probe runs the command on a runtime bound to ctx, so the probe timeout stops the stuck commands
+func (p execProbe) Probe(ctx context.Context) error {
+	rt := p.rt.Clone(ctx)
+	defer rt.Close()
+	return rt.Exec(p.container, p.args, ioutil.Discard)
+}
+
+func (p execProbe) String() string {
+	return p.desc
+}
+
+type tcpProbe struct {
+	address string
+}
+
+// NewTCP returns a Probe which succeeds if a TCP connection to the given address can be established.
+func NewTCP(address string) Probe {
+	return tcpProbe{address: address}
+}
+
+func (p tcpProbe) Probe(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p tcpProbe) String() string {
+	return fmt.Sprintf("TCP connection to %s", p.address)
+}
+
+type sshProbe struct {
+	address string
+	config  *ssh.ClientConfig
+	command string
+}
+
+// NewSSH returns a Probe which succeeds if the given command succeeds running it through SSH on the given address.
+func NewSSH(address string, config *ssh.ClientConfig, command string) Probe {
+	return sshProbe{
+		address: address,
+		config:  config,
+		command: command,
+	}
+}
+
+func (p sshProbe) Probe(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the ssh package is not context-aware
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, p.address, p.config)
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.Run(p.command)
+}
+
+func (p sshProbe) String() string {
+	return fmt.Sprintf("SSH command %q on %s", p.command, p.address)
+}
+
+type httpProbe struct {
+	url    string
+	status int
+	client *http.Client
+}
+
+// NewHTTPGet returns a Probe which succeeds if a GET on the given URL returns the expected status.
+// Certificates are not verified: the probes are meant for throwaway test clusters.
+func NewHTTPGet(url string, status int) Probe {
+	return newHTTPProbe(url, status)
+}
+
+func newHTTPProbe(url string, status int) httpProbe {
+	return httpProbe{
+		url:    url,
+		status: status,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+type kubeAPIProbe struct {
+	readyz  httpProbe
+	healthz httpProbe
+}
+
+// NewKubeAPIReady returns a Probe which succeeds if the Kubernetes API server at the given address is ready.
+// Older API servers (< 1.16) which lack the /readyz endpoint are checked using /healthz.
+func NewKubeAPIReady(address string) Probe {
+	return kubeAPIProbe{
+		readyz:  newHTTPProbe(fmt.Sprintf("https://%s/readyz", address), http.StatusOK),
+		healthz: newHTTPProbe(fmt.Sprintf("https://%s/healthz", address), http.StatusOK),
+	}
+}
+
+func (p kubeAPIProbe) Probe(ctx context.Context) error {
+	status, err := p.readyz.get(ctx)
+	if err == nil && status == http.StatusNotFound {
+		status, err = p.healthz.get(ctx)
+		return p.healthz.check(status, err)
+	}
+	return p.readyz.check(status, err)
+}
+
+func (p kubeAPIProbe) String() string {
+	return p.readyz.String()
+}
+
+func (p httpProbe) Probe(ctx context.Context) error {
+	return p.check(p.get(ctx))
+}
+
+func (p httpProbe) get(ctx context.Context) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (p httpProbe) check(status int, err error) error {
+	if err != nil {
+		return err
+	}
+	if status != p.status {
+		return fmt.Errorf("GET %s: got status %d, expected %d", p.url, status, p.status)
+	}
+	return nil
+}
+
+func (p httpProbe) String() string {
+	return fmt.Sprintf("GET %s returns %d", p.url, p.status)
+}
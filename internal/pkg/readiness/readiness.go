@@ -0,0 +1,109 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Probe checks once if something is ready. Probe returns nil if ready, or an error describing
+// why it isn't (yet).
+type Probe interface {
+	Probe(ctx context.Context) error
+	String() string
+}
+
+// Options controls how a Probe is repeated.
+type Options struct {
+	// Timeout is the maximum time to wait for the Probe to succeed. Zero means wait forever.
+	Timeout time.Duration
+	// ProbeTimeout is the maximum time a single Probe can take. Zero means no limit.
+	ProbeTimeout time.Duration
+	// Interval is the initial wait time between Probes.
+	Interval time.Duration
+	// MaxInterval caps the wait time between Probes.
+	MaxInterval time.Duration
+	// Backoff multiplies the wait time after each failed Probe. Values lower than 1 are treated as 1.
+	Backoff float64
+}
+
+// DefaultOptions returns sensible Options to wait for a node to boot.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:      15 * time.Minute,
+		ProbeTimeout: 30 * time.Second,
+		Interval:     1 * time.Second,
+		MaxInterval:  10 * time.Second,
+		Backoff:      1.5,
+	}
+}
+
+// TimeoutError is returned when a Probe did not succeed in time.
+type TimeoutError struct {
+	Target   string
+	Probe    string
+	Elapsed  time.Duration
+	Attempts int
+	LastErr  error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v waiting for %s: %s did not succeed after %d attempts, last result: %v", e.Elapsed.Round(time.Second), e.Target, e.Probe, e.Attempts, e.LastErr)
+}
+
+// Wait repeats the Probe until it succeeds, the Options.Timeout expires, or the context is canceled.
+// The target is used only to report errors.
+func Wait(ctx context.Context, target string, probe Probe, opts Options) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	interval := opts.Interval
+	attempts := 0
+	for {
+		attempts++
+		lastErr := probeOnce(ctx, probe, opts.ProbeTimeout)
+		if lastErr == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if opts.Timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+				return &TimeoutError{
+					Target:   target,
+					Probe:    probe.String(),
+					Elapsed:  time.Since(start),
+					Attempts: attempts,
+					LastErr:  lastErr,
+				}
+			}
+			return fmt.Errorf("stopped waiting for %s: %v (last result: %v)", target, ctx.Err(), lastErr)
+		case <-time.After(interval):
+		}
+
+		interval = nextInterval(interval, opts)
+	}
+}
+
+func probeOnce(ctx context.Context, probe Probe, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return probe.Probe(ctx)
+}
+
+func nextInterval(interval time.Duration, opts Options) time.Duration {
+	if opts.Backoff > 1 {
+		interval = time.Duration(float64(interval) * opts.Backoff)
+	}
+	if opts.MaxInterval > 0 && interval > opts.MaxInterval {
+		interval = opts.MaxInterval
+	}
+	return interval
+}
@@ -0,0 +1,168 @@
+package readiness_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/fakeruntime"
+	"github.com/fromanirh/pack8s/internal/pkg/podman"
+	"github.com/fromanirh/pack8s/internal/pkg/readiness"
+
+	"github.com/fromanirh/pack8s/iopodman"
+)
+
+type fakeProbe struct {
+	failures int
+	attempts int
+}
+
+func (fp *fakeProbe) Probe(ctx context.Context) error {
+	fp.attempts++
+	if fp.attempts <= fp.failures {
+		return fmt.Errorf("not yet (%d)", fp.attempts)
+	}
+	return nil
+}
+
+func (fp *fakeProbe) String() string {
+	return "fake probe"
+}
+
+// runningContainer returns a fake runtime running a container with the given name
+func runningContainer(name string) *fakeruntime.Runtime {
+	rt := fakeruntime.New()
+	id, err := rt.CreateContainer(iopodman.Create{Args: []string{"registry:2"}, Name: &name})
+	Expect(err).To(BeNil())
+	_, err = rt.StartContainer(id)
+	Expect(err).To(BeNil())
+	return rt
+}
+
+func fastOptions() readiness.Options {
+	return readiness.Options{
+		Timeout:     200 * time.Millisecond,
+		Interval:    time.Millisecond,
+		MaxInterval: 5 * time.Millisecond,
+		Backoff:     2,
+	}
+}
+
+var _ = Describe("readiness", func() {
+	Context("wait", func() {
+		It("Should retry until the probe succeeds", func() {
+			probe := &fakeProbe{failures: 3}
+			err := readiness.Wait(context.Background(), "node01", probe, fastOptions())
+			Expect(err).To(BeNil())
+			Expect(probe.attempts).To(Equal(4))
+		})
+
+		It("Should report the target and the last result on timeout", func() {
+			probe := &fakeProbe{failures: 1 << 30}
+			err := readiness.Wait(context.Background(), "node01", probe, fastOptions())
+			Expect(err).NotTo(BeNil())
+
+			tErr, ok := err.(*readiness.TimeoutError)
+			Expect(ok).To(Equal(true))
+			Expect(tErr.Target).To(Equal("node01"))
+			Expect(tErr.Attempts).To(BeNumerically(">", 1))
+			Expect(err.Error()).To(ContainSubstring("node01"))
+			Expect(err.Error()).To(ContainSubstring("fake probe"))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("not yet (%d)", tErr.Attempts)))
+		})
+
+		It("Should stop when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			opts := fastOptions()
+			opts.Timeout = 0
+			err := readiness.Wait(ctx, "node01", &fakeProbe{failures: 1 << 30}, opts)
+			Expect(err).NotTo(BeNil())
+			_, ok := err.(*readiness.TimeoutError)
+			Expect(ok).To(Equal(false))
+		})
+	})
+
+	Context("probes", func() {
+		It("Should check files in containers", func() {
+			rt := runningContainer("kubevirt-node01")
+			probe := readiness.NewFileExists(rt, "kubevirt-node01", "/ssh_ready")
+			Expect(probe.Probe(context.Background())).To(BeNil())
+			Expect(rt.Execs()).To(HaveLen(1))
+			Expect(rt.Execs()[0].Container).To(Equal("kubevirt-node01"))
+			Expect(strings.Join(rt.Execs()[0].Args, " ")).To(ContainSubstring("test -f /ssh_ready"))
+
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				return &podman.ExitError{Code: 1}
+			})
+			Expect(probe.Probe(context.Background())).NotTo(BeNil())
+		})
+
+		It("Should stop the stuck commands once the probe times out", func() {
+			rt := runningContainer("kubevirt-node01")
+			stuck := make(chan struct{})
+			defer close(stuck)
+			rt.SetExecHandler(func(call fakeruntime.ExecCall, opts podman.ExecOptions) error {
+				<-stuck
+				return nil
+			})
+
+			opts := fastOptions()
+			opts.ProbeTimeout = 10 * time.Millisecond
+			probe := readiness.NewExec(rt, "kubevirt-node01", "ssh.sh", "/bin/true")
+			err := readiness.Wait(context.Background(), "node01", probe, opts)
+			tErr, ok := err.(*readiness.TimeoutError)
+			Expect(ok).To(BeTrue())
+			Expect(tErr.Attempts).To(BeNumerically(">", 1))
+			Expect(tErr.LastErr).To(Equal(context.DeadlineExceeded))
+		})
+
+		It("Should check TCP ports", func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			addr := lis.Addr().String()
+
+			Expect(readiness.NewTCP(addr).Probe(context.Background())).To(BeNil())
+			lis.Close()
+			Expect(readiness.NewTCP(addr).Probe(context.Background())).NotTo(BeNil())
+		})
+
+		It("Should check the HTTP status", func() {
+			ready := false
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/readyz" || !ready {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			probe := readiness.NewKubeAPIReady(strings.TrimPrefix(srv.URL, "https://"))
+			Expect(probe.Probe(context.Background())).NotTo(BeNil())
+			ready = true
+			Expect(probe.Probe(context.Background())).To(BeNil())
+		})
+
+		It("Should fall back to healthz on older API servers", func() {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/healthz" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer srv.Close()
+
+			probe := readiness.NewKubeAPIReady(strings.TrimPrefix(srv.URL, "https://"))
+			Expect(probe.Probe(context.Background())).To(BeNil())
+		})
+	})
+})
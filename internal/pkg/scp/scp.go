@@ -0,0 +1,473 @@
+// Package scp implements both sides of the SCP protocol, so files can be copied to and from
+// any SSH server running the scp binary. We can't use SFTP, because the SFTP subsystem runs
+// with the privileges of the SSH user, while most of the interesting files on the cluster nodes
+// are readable (or writable) only by root: with SCP we can run the remote peer with sudo.
+package scp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	msgOK      = 0
+	msgWarning = 1
+	msgError   = 2
+)
+
+// ProgressFunc is called while a file is copied, every time a chunk is transferred.
+type ProgressFunc func(name string, copied, size int64)
+
+// Options control how files are copied
+type Options struct {
+	// Recursive enables the copy of directories
+	Recursive bool
+	// Preserve keeps the modes and the modification times of the original files
+	Preserve bool
+	// Progress, if not nil, is called while copying files
+	Progress ProgressFunc
+}
+
+// RemoteError is an error reported by the remote peer
+type RemoteError struct {
+	Fatal   bool
+	Message string
+}
+
+func (e RemoteError) Error() string {
+	return strings.TrimPrefix(e.Message, "scp: ")
+}
+
+// Source sends the given local paths to the remote sink (scp -t), which writes to w and reads from r.
+func Source(w io.Writer, r io.Reader, paths []string, opts Options) error {
+	src := source{
+		w:    w,
+		r:    bufio.NewReader(r),
+		opts: opts,
+	}
+	// the sink is ready when it sends the first ack
+	if err := src.readAck(); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if !opts.Recursive {
+				return fmt.Errorf("%s is a directory: recursive copy required", path)
+			}
+			err = src.sendDir(path, fi)
+		} else {
+			err = src.sendFile(path, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type source struct {
+	w    io.Writer
+	r    *bufio.Reader
+	opts Options
+}
+
+func (src source) sendFile(path string, fi os.FileInfo) error {
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := src.sendTimes(fi); err != nil {
+		return err
+	}
+	if err := src.sendRecord("C%04o %d %s\n", fi.Mode().Perm(), fi.Size(), fi.Name()); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(newProgressWriter(src.w, path, fi.Size(), src.opts.Progress), f, fi.Size()); err != nil {
+		return err
+	}
+	if _, err := src.w.Write([]byte{msgOK}); err != nil {
+		return err
+	}
+	return src.readAck()
+}
+
+func (src source) sendDir(path string, fi os.FileInfo) error {
+	if err := src.sendTimes(fi); err != nil {
+		return err
+	}
+	if err := src.sendRecord("D%04o 0 %s\n", fi.Mode().Perm(), fi.Name()); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		// follow the symlinks, like scp does
+		entryInfo, err := os.Stat(entryPath)
+		if err != nil {
+			return err
+		}
+		if entryInfo.IsDir() {
+			err = src.sendDir(entryPath, entryInfo)
+		} else {
+			err = src.sendFile(entryPath, entryInfo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return src.sendRecord("E\n")
+}
+
+func (src source) sendTimes(fi os.FileInfo) error {
+	if !src.opts.Preserve {
+		return nil
+	}
+	mtime := fi.ModTime().Unix()
+	return src.sendRecord("T%d 0 %d 0\n", mtime, mtime)
+}
+
+func (src source) sendRecord(format string, args ...interface{}) error {
+	if _, err := fmt.Fprintf(src.w, format, args...); err != nil {
+		return err
+	}
+	return src.readAck()
+}
+
+func (src source) readAck() error {
+	return readAck(src.r)
+}
+
+// Sink receives the files sent by the remote source (scp -f), which writes to w and reads from r,
+// and stores them in the local target. If target is an existing directory, the files are stored inside it.
+func Sink(w io.Writer, r io.Reader, target string, opts Options) error {
+	targetIsDir := false
+	if fi, err := os.Stat(target); err == nil && fi.IsDir() {
+		targetIsDir = true
+	}
+
+	snk := sink{
+		w:    w,
+		r:    bufio.NewReader(r),
+		opts: opts,
+	}
+	dirs := []dirEntry{}
+	// where the next entry goes, given its name
+	destPath := func(name string) string {
+		if len(dirs) > 0 {
+			return filepath.Join(dirs[len(dirs)-1].path, name)
+		}
+		if targetIsDir {
+			return filepath.Join(target, name)
+		}
+		return target
+	}
+
+	if err := snk.ack(); err != nil {
+		return err
+	}
+	var times *fileTimes
+	for {
+		rec, err := snk.readRecord()
+		if err == io.EOF {
+			if len(dirs) > 0 {
+				return fmt.Errorf("unexpected end of transfer in directory %s", dirs[len(dirs)-1].path)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch rec.kind {
+		case 'T':
+			times = &rec.times
+		case 'C':
+			path := destPath(rec.name)
+			if err := snk.receiveFile(path, rec, times); err != nil {
+				return err
+			}
+			times = nil
+		case 'D':
+			if !opts.Recursive {
+				return fmt.Errorf("received directory %s: recursive copy required", rec.name)
+			}
+			path := destPath(rec.name)
+			if err := makeDir(path, rec.mode); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirEntry{path: path, mode: rec.mode, times: times})
+			times = nil
+		case 'E':
+			if len(dirs) == 0 {
+				return fmt.Errorf("protocol error: unexpected end of directory")
+			}
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if err := snk.restoreAttrs(dir.path, dir.mode, dir.times); err != nil {
+				return err
+			}
+		}
+
+		if err := snk.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// SinkTo receives the single file sent by the remote source (scp -f), and writes its content to out.
+func SinkTo(w io.Writer, r io.Reader, out io.Writer, opts Options) error {
+	snk := sink{
+		w:    w,
+		r:    bufio.NewReader(r),
+		opts: opts,
+	}
+
+	if err := snk.ack(); err != nil {
+		return err
+	}
+	for {
+		rec, err := snk.readRecord()
+		if err == io.EOF {
+			return fmt.Errorf("no file received")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch rec.kind {
+		case 'T':
+			// nothing to preserve
+		case 'C':
+			if err := snk.receiveData(out, rec); err != nil {
+				return err
+			}
+			return snk.ack()
+		default:
+			return fmt.Errorf("received directory %s: only a single file can be copied", rec.name)
+		}
+
+		if err := snk.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+type fileTimes struct {
+	mtime time.Time
+	atime time.Time
+}
+
+type record struct {
+	kind  byte
+	mode  os.FileMode
+	size  int64
+	name  string
+	times fileTimes
+}
+
+type dirEntry struct {
+	path  string
+	mode  os.FileMode
+	times *fileTimes
+}
+
+type sink struct {
+	w    io.Writer
+	r    *bufio.Reader
+	opts Options
+}
+
+func (snk sink) ack() error {
+	_, err := snk.w.Write([]byte{msgOK})
+	return err
+}
+
+func (snk sink) readRecord() (record, error) {
+	line, err := snk.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return record{}, io.EOF
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("protocol error: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line == "" {
+		return record{}, fmt.Errorf("protocol error: empty record")
+	}
+
+	switch line[0] {
+	case msgWarning, msgError:
+		return record{}, RemoteError{Fatal: line[0] == msgError, Message: line[1:]}
+	case 'T':
+		return parseTimes(line)
+	case 'C', 'D':
+		return parseEntry(line)
+	case 'E':
+		return record{kind: 'E'}, nil
+	default:
+		return record{}, fmt.Errorf("protocol error: unexpected record %q", line)
+	}
+}
+
+func (snk sink) receiveFile(path string, rec record, times *fileTimes) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, rec.mode)
+	if err != nil {
+		return err
+	}
+	err = snk.receiveData(f, rec)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return snk.restoreAttrs(path, rec.mode, times)
+}
+
+func (snk sink) receiveData(out io.Writer, rec record) error {
+	if err := snk.ack(); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(newProgressWriter(out, rec.name, rec.size, snk.opts.Progress), snk.r, rec.size); err != nil {
+		return err
+	}
+	// the source tells if the file was read entirely
+	return readAck(snk.r)
+}
+
+func (snk sink) restoreAttrs(path string, mode os.FileMode, times *fileTimes) error {
+	if !snk.opts.Preserve {
+		return nil
+	}
+	// the mode requested at creation time is subject to the umask
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if times != nil {
+		return os.Chtimes(path, times.atime, times.mtime)
+	}
+	return nil
+}
+
+func makeDir(path string, mode os.FileMode) error {
+	fi, err := os.Stat(path)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("%s exists and it is not a directory", path)
+		}
+		return nil
+	}
+	return os.Mkdir(path, mode|0700)
+}
+
+// parseTimes parses the T record: "Tmtime 0 atime 0"
+func parseTimes(line string) (record, error) {
+	fields := strings.Fields(line[1:])
+	if len(fields) != 4 {
+		return record{}, fmt.Errorf("protocol error: malformed times %q", line)
+	}
+	mtime, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return record{}, fmt.Errorf("protocol error: malformed times %q", line)
+	}
+	atime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return record{}, fmt.Errorf("protocol error: malformed times %q", line)
+	}
+	return record{
+		kind: 'T',
+		times: fileTimes{
+			mtime: time.Unix(mtime, 0),
+			atime: time.Unix(atime, 0),
+		},
+	}, nil
+}
+
+// parseEntry parses the C and D records: "Cmode size name"
+func parseEntry(line string) (record, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return record{}, fmt.Errorf("protocol error: malformed entry %q", line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return record{}, fmt.Errorf("protocol error: malformed mode in %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return record{}, fmt.Errorf("protocol error: malformed size in %q", line)
+	}
+	name := fields[2]
+	// never let the remote peer write outside the target
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return record{}, fmt.Errorf("protocol error: invalid name %q", name)
+	}
+	return record{
+		kind: line[0],
+		mode: os.FileMode(mode).Perm(),
+		size: size,
+		name: name,
+	}, nil
+}
+
+func readAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("protocol error: %v", err)
+	}
+	if b == msgOK {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return RemoteError{Fatal: b != msgWarning, Message: strings.TrimSuffix(msg, "\n")}
+}
+
+type progressWriter struct {
+	w        io.Writer
+	name     string
+	size     int64
+	copied   int64
+	progress ProgressFunc
+}
+
+func newProgressWriter(w io.Writer, name string, size int64, progress ProgressFunc) io.Writer {
+	if progress == nil {
+		return w
+	}
+	progress(name, 0, size)
+	return &progressWriter{
+		w:        w,
+		name:     name,
+		size:     size,
+		progress: progress,
+	}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.copied += int64(n)
+	pw.progress(pw.name, pw.copied, pw.size)
+	return n, err
+}
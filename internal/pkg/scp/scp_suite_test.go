@@ -0,0 +1,13 @@
+package scp_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSCP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SCP Suite")
+}
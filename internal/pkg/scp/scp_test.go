@@ -0,0 +1,182 @@
+package scp_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/scp"
+)
+
+// transfer connects a source and a sink, like a SSH session would do
+func transfer(paths []string, target string, srcOpts, snkOpts scp.Options) (error, error) {
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+
+	snkErr := make(chan error, 1)
+	go func() {
+		err := scp.Sink(fromSink, toSink, target, snkOpts)
+		// unblock the source, if still waiting
+		fromSink.CloseWithError(io.EOF)
+		toSink.Close()
+		snkErr <- err
+	}()
+
+	srcErr := scp.Source(fromSource, toSource, paths, srcOpts)
+	fromSource.Close()
+	return srcErr, <-snkErr
+}
+
+func writeFile(path, content string, mode os.FileMode) {
+	Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(path, []byte(content), mode)).To(Succeed())
+	Expect(os.Chmod(path, mode)).To(Succeed())
+}
+
+var _ = Describe("scp", func() {
+	var srcDir, dstDir string
+
+	BeforeEach(func() {
+		var err error
+		srcDir, err = ioutil.TempDir("", "pack8s-scp-src")
+		Expect(err).To(BeNil())
+		dstDir, err = ioutil.TempDir("", "pack8s-scp-dst")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(srcDir)
+		os.RemoveAll(dstDir)
+	})
+
+	Context("single files", func() {
+		It("Should copy a file into a directory", func() {
+			writeFile(filepath.Join(srcDir, "hello.txt"), "hello world\n", 0644)
+
+			srcErr, snkErr := transfer([]string{filepath.Join(srcDir, "hello.txt")}, dstDir, scp.Options{}, scp.Options{})
+			Expect(srcErr).To(BeNil())
+			Expect(snkErr).To(BeNil())
+
+			data, err := ioutil.ReadFile(filepath.Join(dstDir, "hello.txt"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("hello world\n"))
+		})
+
+		It("Should copy a file to a new path", func() {
+			writeFile(filepath.Join(srcDir, "hello.txt"), "hello world\n", 0644)
+			target := filepath.Join(dstDir, "renamed.txt")
+
+			srcErr, snkErr := transfer([]string{filepath.Join(srcDir, "hello.txt")}, target, scp.Options{}, scp.Options{})
+			Expect(srcErr).To(BeNil())
+			Expect(snkErr).To(BeNil())
+
+			data, err := ioutil.ReadFile(target)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("hello world\n"))
+		})
+
+		It("Should preserve modes and times", func() {
+			path := filepath.Join(srcDir, "run.sh")
+			writeFile(path, "#!/bin/sh\n", 0751)
+			mtime := time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC)
+			Expect(os.Chtimes(path, mtime, mtime)).To(Succeed())
+
+			opts := scp.Options{Preserve: true}
+			srcErr, snkErr := transfer([]string{path}, dstDir, opts, opts)
+			Expect(srcErr).To(BeNil())
+			Expect(snkErr).To(BeNil())
+
+			fi, err := os.Stat(filepath.Join(dstDir, "run.sh"))
+			Expect(err).To(BeNil())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0751)))
+			Expect(fi.ModTime().Equal(mtime)).To(BeTrue())
+		})
+
+		It("Should report the progress", func() {
+			writeFile(filepath.Join(srcDir, "data"), "0123456789", 0644)
+
+			var last int64
+			opts := scp.Options{
+				Progress: func(name string, copied, size int64) {
+					Expect(size).To(Equal(int64(10)))
+					last = copied
+				},
+			}
+			srcErr, snkErr := transfer([]string{filepath.Join(srcDir, "data")}, dstDir, scp.Options{}, opts)
+			Expect(srcErr).To(BeNil())
+			Expect(snkErr).To(BeNil())
+			Expect(last).To(Equal(int64(10)))
+		})
+
+		It("Should write the file content to a stream", func() {
+			writeFile(filepath.Join(srcDir, "hello.txt"), "hello world\n", 0644)
+
+			toSink, fromSource := io.Pipe()
+			toSource, fromSink := io.Pipe()
+			var out bytes.Buffer
+			snkErr := make(chan error, 1)
+			go func() {
+				err := scp.SinkTo(fromSink, toSink, &out, scp.Options{})
+				fromSink.Close()
+				snkErr <- err
+			}()
+
+			Expect(scp.Source(fromSource, toSource, []string{filepath.Join(srcDir, "hello.txt")}, scp.Options{})).To(Succeed())
+			fromSource.Close()
+			Expect(<-snkErr).To(BeNil())
+			Expect(out.String()).To(Equal("hello world\n"))
+		})
+	})
+
+	Context("directories", func() {
+		It("Should copy directories recursively", func() {
+			tree := filepath.Join(srcDir, "manifests")
+			writeFile(filepath.Join(tree, "a.yaml"), "a", 0644)
+			writeFile(filepath.Join(tree, "sub", "b.yaml"), "bb", 0600)
+			writeFile(filepath.Join(tree, "sub", "deeper", "c.yaml"), "ccc", 0644)
+
+			opts := scp.Options{Recursive: true, Preserve: true}
+			srcErr, snkErr := transfer([]string{tree}, dstDir, opts, opts)
+			Expect(srcErr).To(BeNil())
+			Expect(snkErr).To(BeNil())
+
+			data, err := ioutil.ReadFile(filepath.Join(dstDir, "manifests", "sub", "deeper", "c.yaml"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("ccc"))
+			fi, err := os.Stat(filepath.Join(dstDir, "manifests", "sub", "b.yaml"))
+			Expect(err).To(BeNil())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("Should refuse directories without recursion", func() {
+			Expect(os.Mkdir(filepath.Join(srcDir, "dir"), 0755)).To(Succeed())
+
+			srcErr, _ := transfer([]string{filepath.Join(srcDir, "dir")}, dstDir, scp.Options{}, scp.Options{})
+			Expect(srcErr).NotTo(BeNil())
+		})
+	})
+
+	Context("errors", func() {
+		It("Should report remote errors", func() {
+			var acks bytes.Buffer
+			remote := bytes.NewBufferString("\x01scp: /etc/missing: No such file or directory\n")
+			err := scp.Sink(&acks, remote, dstDir, scp.Options{})
+			Expect(err).To(MatchError("/etc/missing: No such file or directory"))
+		})
+
+		It("Should refuse names escaping the target", func() {
+			var acks bytes.Buffer
+			remote := bytes.NewBufferString("C0644 4 ../evil\nevil\x00")
+			err := scp.Sink(&acks, remote, dstDir, scp.Options{})
+			Expect(err).NotTo(BeNil())
+			_, err = os.Stat(filepath.Join(filepath.Dir(dstDir), "evil"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
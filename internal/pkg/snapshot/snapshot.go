@@ -0,0 +1,469 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvSnapshotsDir overrides the directory holding the snapshot archives
+	EnvSnapshotsDir = "PACK8S_SNAPSHOTS"
+
+	// ManifestVersion is the current version of the snapshot manifest format
+	ManifestVersion = 1
+
+	// Extension is the file extension of the snapshot archives
+	Extension = ".tar.gz"
+
+	manifestName  = "manifest.yaml"
+	containersDir = "containers"
+	volumesDir    = "volumes"
+)
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Mount is a mount of a snapshotted container
+type Mount struct {
+	// Type is either "volume" or "bind"
+	Type string `yaml:"type"`
+	// Source is the name of the volume, without the cluster prefix, or the host path for bind mounts
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+}
+
+// Container describes how to recreate a snapshotted container.
+// The container filesystem is stored in the archive, and it is imported as a new image on restore.
+type Container struct {
+	// Name is the name of the container without the cluster prefix, e.g. "node01"
+	Name       string `yaml:"name"`
+	Generation string `yaml:"generation"`
+	// Image is the image the container was originally created from
+	Image      string            `yaml:"image"`
+	Entrypoint string            `yaml:"entrypoint,omitempty"`
+	Command    []string          `yaml:"command,omitempty"`
+	Env        []string          `yaml:"env,omitempty"`
+	WorkDir    string            `yaml:"workDir,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+	Privileged bool              `yaml:"privileged"`
+	// Network is the name, without the cluster prefix, of the container whose network is joined, if any
+	Network string   `yaml:"network,omitempty"`
+	Publish []string `yaml:"publish,omitempty"`
+	AddHost []string `yaml:"addHost,omitempty"`
+	Mounts  []Mount  `yaml:"mounts,omitempty"`
+}
+
+// Volume is a snapshotted volume
+type Volume struct {
+	// Name is the name of the volume without the cluster prefix, e.g. "node01"
+	Name string `yaml:"name"`
+}
+
+// Manifest describes the content of a snapshot archive.
+// Containers are listed in generation order, so they can be recreated in the same order.
+type Manifest struct {
+	Version    int         `yaml:"version"`
+	Name       string      `yaml:"name"`
+	Prefix     string      `yaml:"prefix"`
+	Provider   string      `yaml:"provider,omitempty"`
+	MACRange   uint        `yaml:"macRange"`
+	HostPorts  []uint      `yaml:"hostPorts,omitempty"`
+	Created    time.Time   `yaml:"created"`
+	Containers []Container `yaml:"containers"`
+	Volumes    []Volume    `yaml:"volumes,omitempty"`
+}
+
+// ValidateName verifies the given snapshot name can be safely used as file name
+func ValidateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q: only letters, digits, '_', '.' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// DefaultDir returns the directory holding the snapshot archives
+func DefaultDir() (string, error) {
+	if dir, ok := os.LookupEnv(EnvSnapshotsDir); ok && dir != "" {
+		return dir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "pack8s", "snapshots"), nil
+}
+
+// Path returns the path of the archive of the snapshot with the given name
+func Path(dir, name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+Extension), nil
+}
+
+// Writer writes a snapshot archive: a gzipped tarball holding the manifest first,
+// then the container filesystems, then the volume trees.
+type Writer struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewWriter starts a new snapshot archive, writing the given manifest.
+func NewWriter(w io.Writer, m Manifest) (*Writer, error) {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// node disks are big: favour speed over size
+	gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	sw := &Writer{
+		gz: gz,
+		tw: tar.NewWriter(gz),
+	}
+
+	err = sw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     manifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  m.Created,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sw.tw.Write(data); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// AddContainer stores the filesystem of the given container, read from the tarball at srcPath.
+func (sw *Writer) AddContainer(c Container, srcPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = sw.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     containerArchivePath(c.Name),
+		Mode:     0644,
+		Size:     st.Size(),
+		ModTime:  st.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(sw.tw, src)
+	return err
+}
+
+// AddVolume stores the content of the given volume, read from the directory root.
+// Ownership, permissions and symlinks are preserved.
+func (sw *Writer) AddVolume(v Volume, root string) error {
+	base := volumeArchivePath(v.Name)
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// sockets, devices and the like can't be meaningfully restored
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(base, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := sw.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(sw.tw, src)
+		return err
+	})
+}
+
+// Close completes the archive. It doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	if err := sw.tw.Close(); err != nil {
+		return err
+	}
+	return sw.gz.Close()
+}
+
+// Reader reads a snapshot archive written by Writer
+type Reader struct {
+	Manifest Manifest
+	gz       *gzip.Reader
+	tr       *tar.Reader
+}
+
+// NewReader opens a snapshot archive, reading its manifest.
+func NewReader(r io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("malformed snapshot archive: %v", err)
+	}
+	sr := &Reader{
+		gz: gz,
+		tr: tar.NewReader(gz),
+	}
+
+	hdr, err := sr.tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("malformed snapshot archive: %v", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("malformed snapshot archive: expected %s, found %s", manifestName, hdr.Name)
+	}
+	data, err := ioutil.ReadAll(sr.tr)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &sr.Manifest); err != nil {
+		return nil, fmt.Errorf("malformed snapshot manifest: %v", err)
+	}
+	if sr.Manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported snapshot manifest version %d (supported: %d)", sr.Manifest.Version, ManifestVersion)
+	}
+	return sr, nil
+}
+
+// ReadManifest returns the manifest of the snapshot archive at the given path
+func ReadManifest(archivePath string) (Manifest, error) {
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer src.Close()
+
+	sr, err := NewReader(src)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return sr.Manifest, nil
+}
+
+// Extract unpacks the archive. The filesystem of each container is written in the file
+// returned by containerPath, the content of each volume is unpacked in the directory returned
+// by volumePath. Both are called with the names found in the manifest. Containers for which
+// containerPath returns an empty path are skipped.
+func (sr *Reader) Extract(containerPath, volumePath func(name string) (string, error)) error {
+	for {
+		hdr, err := sr.tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		kind, name, rel, err := splitArchivePath(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case containersDir:
+			if !sr.hasContainer(name) {
+				return fmt.Errorf("container %s is not in the snapshot manifest", name)
+			}
+			dst, err := containerPath(name)
+			if err != nil {
+				return err
+			}
+			if dst == "" {
+				continue
+			}
+			if err := extractFile(dst, hdr, sr.tr); err != nil {
+				return err
+			}
+		case volumesDir:
+			if !sr.hasVolume(name) {
+				return fmt.Errorf("volume %s is not in the snapshot manifest", name)
+			}
+			root, err := volumePath(name)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(root, filepath.FromSlash(rel))
+			if err := checkVolumeEntry(root, dst); err != nil {
+				return fmt.Errorf("malformed snapshot archive: entry %s: %v", hdr.Name, err)
+			}
+			if err := extractEntry(dst, hdr, sr.tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (sr *Reader) hasContainer(name string) bool {
+	for _, c := range sr.Manifest.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (sr *Reader) hasVolume(name string) bool {
+	for _, v := range sr.Manifest.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containerArchivePath(name string) string {
+	return path.Join(containersDir, name+".tar")
+}
+
+func volumeArchivePath(name string) string {
+	return path.Join(volumesDir, name)
+}
+
+// splitArchivePath splits an archive entry name into the kind of the entry, the name
+// of the container or volume, and the path relative to the volume root, rejecting the
+// names which could escape the extraction directories.
+func splitArchivePath(name string) (string, string, string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", "", "", fmt.Errorf("malformed snapshot archive: unsafe entry %s", name)
+	}
+
+	items := strings.SplitN(clean, "/", 3)
+	switch {
+	case items[0] == containersDir && len(items) == 2 && strings.HasSuffix(items[1], ".tar"):
+		return containersDir, strings.TrimSuffix(items[1], ".tar"), "", nil
+	case items[0] == volumesDir && len(items) >= 2:
+		rel := "."
+		if len(items) == 3 {
+			rel = items[2]
+		}
+		return volumesDir, items[1], rel, nil
+	}
+	return "", "", "", fmt.Errorf("malformed snapshot archive: unexpected entry %s", name)
+}
+
+// checkVolumeEntry makes sure the entry to be extracted in dst can't be written outside
+// the volume root through the symlinks extracted before it.
+func checkVolumeEntry(root, dst string) error {
+	if dst == filepath.Clean(root) {
+		return nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realDir)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("escapes the volume through a symlink")
+	}
+	// replace, don't follow, the symlinks extracted before in the same place
+	if st, err := os.Lstat(dst); err == nil && st.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(dst)
+	}
+	return nil
+}
+
+func extractFile(dst string, hdr *tar.Header, r io.Reader) error {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func extractEntry(dst string, hdr *tar.Header, r io.Reader) error {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(dst, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+		// MkdirAll doesn't touch existing directories, like the volume root
+		if err := os.Chmod(dst, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := extractFile(dst, hdr, r); err != nil {
+			return err
+		}
+		// the permissions of new files are masked by umask
+		if err := os.Chmod(dst, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, dst); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	// ownership matters only for the containers, and it can be restored only by root
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(dst, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package snapshot_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/snapshot"
+)
+
+var _ = Describe("snapshot", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-snapshot")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("names", func() {
+		It("Should reject names unsafe for files", func() {
+			Expect(snapshot.ValidateName("k8s-1.17_warm")).To(BeNil())
+			for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+				Expect(snapshot.ValidateName(name)).ToNot(BeNil())
+			}
+		})
+
+		It("Should build the archive path", func() {
+			path, err := snapshot.Path("/snapshots", "warm")
+			Expect(err).To(BeNil())
+			Expect(path).To(Equal("/snapshots/warm.tar.gz"))
+		})
+	})
+
+	Context("archive", func() {
+		manifest := snapshot.Manifest{
+			Version: snapshot.ManifestVersion,
+			Name:    "warm",
+			Prefix:  "k8s",
+			Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Containers: []snapshot.Container{
+				{Name: "dnsmasq", Generation: "000", Image: "kubevirtci/k8s-1.17"},
+				{Name: "node01", Generation: "101", Network: "dnsmasq", Mounts: []snapshot.Mount{
+					{Type: "volume", Source: "node01", Destination: "/var/run/disk"},
+				}},
+			},
+			Volumes: []snapshot.Volume{{Name: "node01"}},
+		}
+
+		It("Should restore containers and volumes", func() {
+			contTar := filepath.Join(tmpDir, "node01.tar")
+			Expect(ioutil.WriteFile(contTar, []byte("container filesystem"), 0600)).To(BeNil())
+
+			volDir := filepath.Join(tmpDir, "volume")
+			Expect(os.MkdirAll(filepath.Join(volDir, "images"), 0755)).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(volDir, "images", "disk.qcow2"), []byte("disk"), 0640)).To(BeNil())
+			Expect(os.Symlink("images/disk.qcow2", filepath.Join(volDir, "disk"))).To(BeNil())
+
+			var buf bytes.Buffer
+			sw, err := snapshot.NewWriter(&buf, manifest)
+			Expect(err).To(BeNil())
+			Expect(sw.AddContainer(manifest.Containers[1], contTar)).To(BeNil())
+			Expect(sw.AddVolume(manifest.Volumes[0], volDir)).To(BeNil())
+			Expect(sw.Close()).To(BeNil())
+
+			sr, err := snapshot.NewReader(&buf)
+			Expect(err).To(BeNil())
+			Expect(sr.Manifest).To(Equal(manifest))
+
+			outDir := filepath.Join(tmpDir, "out")
+			Expect(os.MkdirAll(outDir, 0755)).To(BeNil())
+			err = sr.Extract(func(name string) (string, error) {
+				return filepath.Join(outDir, name+".tar"), nil
+			}, func(name string) (string, error) {
+				return filepath.Join(outDir, name), nil
+			})
+			Expect(err).To(BeNil())
+
+			data, err := ioutil.ReadFile(filepath.Join(outDir, "node01.tar"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("container filesystem"))
+
+			data, err = ioutil.ReadFile(filepath.Join(outDir, "node01", "disk"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("disk"))
+
+			st, err := os.Stat(filepath.Join(outDir, "node01", "images", "disk.qcow2"))
+			Expect(err).To(BeNil())
+			Expect(st.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		})
+
+		It("Should reject entries escaping the extraction directories", func() {
+			var buf bytes.Buffer
+			sw, err := snapshot.NewWriter(&buf, manifest)
+			Expect(err).To(BeNil())
+			Expect(sw.Close()).To(BeNil())
+
+			// append a malicious entry to the archive
+			gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+			Expect(err).To(BeNil())
+			var evil bytes.Buffer
+			gzw := gzip.NewWriter(&evil)
+			tw := tar.NewWriter(gzw)
+			tr := tar.NewReader(gz)
+			hdr, err := tr.Next()
+			Expect(err).To(BeNil())
+			Expect(tw.WriteHeader(hdr)).To(BeNil())
+			data, err := ioutil.ReadAll(tr)
+			Expect(err).To(BeNil())
+			_, err = tw.Write(data)
+			Expect(err).To(BeNil())
+			Expect(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "volumes/node01/../../../escape", Mode: 0644})).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gzw.Close()).To(BeNil())
+
+			sr, err := snapshot.NewReader(&evil)
+			Expect(err).To(BeNil())
+			err = sr.Extract(func(name string) (string, error) {
+				return filepath.Join(tmpDir, name+".tar"), nil
+			}, func(name string) (string, error) {
+				return filepath.Join(tmpDir, name), nil
+			})
+			Expect(err).ToNot(BeNil())
+			_, err = os.Stat(filepath.Join(tmpDir, "..", "escape"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("Should reject entries escaping the volumes through symlinks", func() {
+			var buf bytes.Buffer
+			sw, err := snapshot.NewWriter(&buf, manifest)
+			Expect(err).To(BeNil())
+			Expect(sw.Close()).To(BeNil())
+
+			outside := filepath.Join(tmpDir, "outside")
+			Expect(os.MkdirAll(outside, 0755)).To(BeNil())
+
+			// append a symlink to a directory outside the volume, and a file through it
+			gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+			Expect(err).To(BeNil())
+			var evil bytes.Buffer
+			gzw := gzip.NewWriter(&evil)
+			tw := tar.NewWriter(gzw)
+			tr := tar.NewReader(gz)
+			hdr, err := tr.Next()
+			Expect(err).To(BeNil())
+			Expect(tw.WriteHeader(hdr)).To(BeNil())
+			data, err := ioutil.ReadAll(tr)
+			Expect(err).To(BeNil())
+			_, err = tw.Write(data)
+			Expect(err).To(BeNil())
+			Expect(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "volumes/node01", Mode: 0755})).To(BeNil())
+			Expect(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "volumes/node01/x", Linkname: outside})).To(BeNil())
+			Expect(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "volumes/node01/x/passwd", Mode: 0644})).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gzw.Close()).To(BeNil())
+
+			sr, err := snapshot.NewReader(&evil)
+			Expect(err).To(BeNil())
+			err = sr.Extract(func(name string) (string, error) {
+				return filepath.Join(tmpDir, name+".tar"), nil
+			}, func(name string) (string, error) {
+				return filepath.Join(tmpDir, name), nil
+			})
+			Expect(err).ToNot(BeNil())
+			_, err = os.Stat(filepath.Join(outside, "passwd"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("Should reject unknown manifest versions", func() {
+			var buf bytes.Buffer
+			future := manifest
+			future.Version = snapshot.ManifestVersion + 1
+			sw, err := snapshot.NewWriter(&buf, future)
+			Expect(err).To(BeNil())
+			Expect(sw.Close()).To(BeNil())
+
+			_, err = snapshot.NewReader(&buf)
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
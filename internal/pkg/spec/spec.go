@@ -0,0 +1,490 @@
+package spec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/fromanirh/pack8s/internal/pkg/images"
+)
+
+const (
+	// APIVersion is the current version of the cluster spec format
+	APIVersion = "pack8s/v1alpha1"
+
+	// MaxPort is the highest valid TCP port number
+	MaxPort = 65535
+)
+
+const (
+	// AllOtherNodes stands for all the nodes not explicitely listed in the provisioning order
+	AllOtherNodes = "*"
+)
+
+const (
+	// RoleControlPlane is the role of the first node
+	RoleControlPlane = "control-plane"
+	// RoleWorker is the role of all the nodes but the first
+	RoleWorker = "worker"
+)
+
+var memoryRe = regexp.MustCompile(`^[0-9]+[KMG]?$`)
+
+// Cluster describes declaratively a cluster to be run.
+// JSON is a subset of YAML, so both formats are accepted.
+type Cluster struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Provider   string   `yaml:"provider,omitempty"`
+	Nodes      Nodes    `yaml:"nodes"`
+	Services   Services `yaml:"services"`
+	Ports      Ports    `yaml:"ports"`
+	// Pod makes all the cluster containers members of one pod, which publishes the cluster ports,
+	// instead of joining the network namespace of the dnsmasq container.
+	Pod bool `yaml:"pod,omitempty"`
+	// Images pins the auxiliary images of the cluster. The images not set here are resolved
+	// through the pack8s image configuration.
+	Images images.Set `yaml:"images,omitempty"`
+}
+
+// Nodes describes the resources of the cluster nodes
+type Nodes struct {
+	Count         uint   `yaml:"count"`
+	Memory        string `yaml:"memory"`
+	CPU           uint   `yaml:"cpu"`
+	SecondaryNics uint   `yaml:"secondaryNics"`
+	QemuArgs      string `yaml:"qemuArgs,omitempty"`
+	Reverse       bool   `yaml:"reverse,omitempty"`
+	// Overrides are applied in order, role overrides first, then index overrides
+	Overrides []NodeOverride `yaml:"overrides,omitempty"`
+	// ProvisionOrder lists the provisioning stages, run one after another. The nodes in the
+	// same stage are provisioned in parallel. "*" stands for all the nodes not explicitely listed.
+	// If empty, node01 is provisioned first, then all the others; the other way around if Reverse is set.
+	ProvisionOrder [][]string `yaml:"provisionOrder,omitempty"`
+}
+
+// NodeOverride customizes the resources of the nodes matching its selector, which is either
+// the node index (1-based, like in node01) or the node role.
+// Unset fields are not overridden. QemuArgs are appended to the common ones.
+type NodeOverride struct {
+	Index         uint   `yaml:"index,omitempty"`
+	Role          string `yaml:"role,omitempty"`
+	Memory        string `yaml:"memory,omitempty"`
+	CPU           uint   `yaml:"cpu,omitempty"`
+	SecondaryNics *uint  `yaml:"secondaryNics,omitempty"`
+	QemuArgs      string `yaml:"qemuArgs,omitempty"`
+}
+
+// NodeResources are the effective resources of a node, once all the overrides are applied
+type NodeResources struct {
+	Memory        string
+	CPU           uint
+	SecondaryNics uint
+	QemuArgs      string
+}
+
+// Services describes the auxiliary services of the cluster
+type Services struct {
+	Registry Registry `yaml:"registry"`
+	NFS      NFS      `yaml:"nfs"`
+	Ceph     Ceph     `yaml:"ceph"`
+	Fluentd  Fluentd  `yaml:"fluentd"`
+}
+
+// Registry describes the cluster docker registry. The registry is always enabled.
+type Registry struct {
+	Volume string `yaml:"volume,omitempty"`
+}
+
+// NFS describes the NFS service, enabled if Data is not empty
+type NFS struct {
+	Data string `yaml:"data,omitempty"`
+}
+
+// Ceph describes the Ceph service
+type Ceph struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Fluentd describes the log aggregation service, enabled if LogDir is not empty
+type Fluentd struct {
+	LogDir string `yaml:"logDir,omitempty"`
+}
+
+// Ports describes the mapping of the cluster ports on the host.
+// Zero means the port is not explicitely published.
+type Ports struct {
+	Random   bool `yaml:"random"`
+	SSH      uint `yaml:"ssh,omitempty"`
+	VNC      uint `yaml:"vnc,omitempty"`
+	Registry uint `yaml:"registry,omitempty"`
+	OCP      uint `yaml:"ocp,omitempty"`
+	K8s      uint `yaml:"k8s,omitempty"`
+	// SSHWorker is the ssh port of the worker node, used only by OKD clusters
+	SSHWorker uint `yaml:"sshWorker,omitempty"`
+	// OCPConsole is the port of the OCP console, used only by OKD clusters
+	OCPConsole uint `yaml:"ocpConsole,omitempty"`
+}
+
+// Default returns the spec of the default cluster.
+func Default() Cluster {
+	return Cluster{
+		APIVersion: APIVersion,
+		Nodes: Nodes{
+			Count:  1,
+			Memory: "3096M",
+			CPU:    2,
+		},
+		Ports: Ports{
+			Random: true,
+		},
+	}
+}
+
+// Load reads a spec from the given file. Any field not set in the file gets its default value.
+func Load(path string) (Cluster, error) {
+	return LoadWithDefaults(path, Default())
+}
+
+// LoadWithDefaults reads a spec from the given file. Any field not set in the file keeps its value in def.
+func LoadWithDefaults(path string, def Cluster) (Cluster, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return Cluster{}, err
+	}
+	defer src.Close()
+	return ReadWithDefaults(src, def)
+}
+
+// Read reads a spec from the given reader. Any field not set gets its default value.
+func Read(r io.Reader) (Cluster, error) {
+	return ReadWithDefaults(r, Default())
+}
+
+// ReadWithDefaults reads a spec from the given reader. Any field not set keeps its value in def.
+func ReadWithDefaults(r io.Reader, def Cluster) (Cluster, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Cluster{}, err
+	}
+	cs := def
+	if err := yaml.UnmarshalStrict(data, &cs); err != nil {
+		return Cluster{}, fmt.Errorf("malformed cluster spec: %v", err)
+	}
+	return cs, nil
+}
+
+// Write serializes the spec as YAML to the given writer.
+func (cs Cluster) Write(w io.Writer) error {
+	data, err := yaml.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (cs Cluster) WantsNFS() bool {
+	return cs.Services.NFS.Data != ""
+}
+
+func (cs Cluster) WantsCeph() bool {
+	return cs.Services.Ceph.Enabled
+}
+
+func (cs Cluster) WantsFluentd() bool {
+	return cs.Services.Fluentd.LogDir != ""
+}
+
+func (cs Cluster) AuxImages() images.Set {
+	return cs.Images
+}
+
+// NodeName returns the name of the node with the given (1-based) index
+func NodeName(index uint) string {
+	return fmt.Sprintf("node%02d", index)
+}
+
+// NodeRole returns the role of the node with the given (1-based) index
+func NodeRole(index uint) string {
+	if index == 1 {
+		return RoleControlPlane
+	}
+	return RoleWorker
+}
+
+// Resources returns the effective resources of the node with the given (1-based) index.
+func (n Nodes) Resources(index uint) NodeResources {
+	res := NodeResources{
+		Memory:        n.Memory,
+		CPU:           n.CPU,
+		SecondaryNics: n.SecondaryNics,
+		QemuArgs:      n.QemuArgs,
+	}
+	role := NodeRole(index)
+	for _, ov := range n.Overrides {
+		if ov.Index == 0 && ov.Role == role {
+			ov.applyTo(&res)
+		}
+	}
+	for _, ov := range n.Overrides {
+		if ov.Index == index {
+			ov.applyTo(&res)
+		}
+	}
+	return res
+}
+
+// MaxSecondaryNics returns the highest number of secondary nics among all the nodes
+func (n Nodes) MaxSecondaryNics() uint {
+	max := uint(0)
+	for idx := uint(1); idx <= n.Count; idx++ {
+		if nics := n.Resources(idx).SecondaryNics; nics > max {
+			max = nics
+		}
+	}
+	return max
+}
+
+// ProvisionStages returns the nodes to be provisioned, grouped in stages, with all the wildcards expanded.
+// Nodes not explicitely listed are provisioned in a final stage.
+func (n Nodes) ProvisionStages() [][]string {
+	order := n.ProvisionOrder
+	if len(order) == 0 {
+		order = [][]string{{NodeName(1)}, {AllOtherNodes}}
+		if n.Reverse {
+			order = [][]string{{AllOtherNodes}, {NodeName(1)}}
+		}
+	}
+
+	listed := make(map[string]bool)
+	hasWildcard := false
+	for _, stage := range order {
+		for _, name := range stage {
+			if name == AllOtherNodes {
+				hasWildcard = true
+			} else {
+				listed[name] = true
+			}
+		}
+	}
+	others := []string{}
+	for idx := uint(1); idx <= n.Count; idx++ {
+		if name := NodeName(idx); !listed[name] {
+			others = append(others, name)
+		}
+	}
+
+	stages := [][]string{}
+	for _, stage := range order {
+		nodes := []string{}
+		for _, name := range stage {
+			if name == AllOtherNodes {
+				nodes = append(nodes, others...)
+			} else {
+				nodes = append(nodes, name)
+			}
+		}
+		if len(nodes) > 0 {
+			stages = append(stages, nodes)
+		}
+	}
+	if !hasWildcard && len(others) > 0 {
+		stages = append(stages, others)
+	}
+	return stages
+}
+
+// ParseProvisionOrder parses a provisioning order in the form NODE[,NODE...][:NODE[,NODE...]...],
+// where each stage is separated by ':' and the nodes in each stage are separated by ','.
+// Example: "node01:*" provisions node01 first, then all the others in parallel.
+func ParseProvisionOrder(s string) [][]string {
+	order := [][]string{}
+	for _, stage := range strings.Split(s, ":") {
+		order = append(order, strings.Split(stage, ","))
+	}
+	return order
+}
+
+func (ov NodeOverride) applyTo(res *NodeResources) {
+	if ov.Memory != "" {
+		res.Memory = ov.Memory
+	}
+	if ov.CPU != 0 {
+		res.CPU = ov.CPU
+	}
+	if ov.SecondaryNics != nil {
+		res.SecondaryNics = *ov.SecondaryNics
+	}
+	if ov.QemuArgs != "" {
+		res.QemuArgs = strings.TrimSpace(res.QemuArgs + " " + ov.QemuArgs)
+	}
+}
+
+// ParseNodeOverride parses an override in the form SELECTOR:KEY=VALUE[,KEY=VALUE...].
+// SELECTOR is a node index (2), a node name (node02) or a role (control-plane, worker).
+// KEY is one of memory, cpu, secondary-nics, qemu-args. Since the qemu args usually contain
+// commas, qemu-args must be the last key and takes all the rest of the string.
+func ParseNodeOverride(s string) (NodeOverride, error) {
+	ov := NodeOverride{}
+	items := strings.SplitN(s, ":", 2)
+	if len(items) != 2 || items[0] == "" || items[1] == "" {
+		return ov, fmt.Errorf("malformed node override %q: expected SELECTOR:KEY=VALUE[,KEY=VALUE...]", s)
+	}
+
+	selector := items[0]
+	switch {
+	case selector == RoleControlPlane || selector == RoleWorker:
+		ov.Role = selector
+	default:
+		index, err := strconv.ParseUint(strings.TrimPrefix(selector, "node"), 10, 32)
+		if err != nil {
+			return ov, fmt.Errorf("malformed node override %q: unknown selector %q", s, selector)
+		}
+		ov.Index = uint(index)
+	}
+
+	rest := items[1]
+	for rest != "" {
+		var item string
+		if strings.HasPrefix(rest, "qemu-args=") {
+			item, rest = rest, ""
+		} else if pos := strings.Index(rest, ","); pos >= 0 {
+			item, rest = rest[:pos], rest[pos+1:]
+		} else {
+			item, rest = rest, ""
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return ov, fmt.Errorf("malformed node override %q: expected KEY=VALUE, got %q", s, item)
+		}
+		switch kv[0] {
+		case "memory":
+			ov.Memory = kv[1]
+		case "cpu":
+			cpu, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return ov, fmt.Errorf("malformed node override %q: bad cpu value %q", s, kv[1])
+			}
+			ov.CPU = uint(cpu)
+		case "secondary-nics":
+			nics, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return ov, fmt.Errorf("malformed node override %q: bad secondary-nics value %q", s, kv[1])
+			}
+			val := uint(nics)
+			ov.SecondaryNics = &val
+		case "qemu-args":
+			ov.QemuArgs = kv[1]
+		default:
+			return ov, fmt.Errorf("malformed node override %q: unknown key %q", s, kv[0])
+		}
+	}
+	return ov, nil
+}
+
+// Validate checks the spec is consistent, and reports all the errors found, if any.
+func (cs Cluster) Validate() error {
+	errs := []string{}
+	if cs.APIVersion != APIVersion {
+		errs = append(errs, fmt.Sprintf("unsupported apiVersion %q (expected %q)", cs.APIVersion, APIVersion))
+	}
+	if cs.Provider == "" {
+		errs = append(errs, "missing provider image")
+	}
+	if cs.Nodes.Count < 1 {
+		errs = append(errs, "nodes.count must be at least 1")
+	}
+	if cs.Nodes.CPU < 1 {
+		errs = append(errs, "nodes.cpu must be at least 1")
+	}
+	if !memoryRe.MatchString(cs.Nodes.Memory) {
+		errs = append(errs, fmt.Sprintf("nodes.memory %q is not a valid amount (e.g. 4096M, 4G)", cs.Nodes.Memory))
+	}
+	for idx, ov := range cs.Nodes.Overrides {
+		errs = append(errs, ov.validate(idx, cs.Nodes.Count)...)
+	}
+	errs = append(errs, cs.Nodes.validateProvisionOrder()...)
+
+	ports := []struct {
+		name  string
+		value uint
+	}{
+		{"ssh", cs.Ports.SSH},
+		{"vnc", cs.Ports.VNC},
+		{"registry", cs.Ports.Registry},
+		{"ocp", cs.Ports.OCP},
+		{"k8s", cs.Ports.K8s},
+		{"sshWorker", cs.Ports.SSHWorker},
+		{"ocpConsole", cs.Ports.OCPConsole},
+	}
+	used := make(map[uint]string)
+	for _, port := range ports {
+		if port.value == 0 {
+			continue
+		}
+		if port.value > MaxPort {
+			errs = append(errs, fmt.Sprintf("ports.%s %d is out of range", port.name, port.value))
+			continue
+		}
+		if other, ok := used[port.value]; ok {
+			errs = append(errs, fmt.Sprintf("ports.%s %d is already used by ports.%s", port.name, port.value, other))
+			continue
+		}
+		used[port.value] = port.name
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid cluster spec: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (ov NodeOverride) validate(idx int, count uint) []string {
+	errs := []string{}
+	name := fmt.Sprintf("nodes.overrides[%d]", idx)
+	if (ov.Index == 0) == (ov.Role == "") {
+		errs = append(errs, fmt.Sprintf("%s must select either an index or a role", name))
+	}
+	if ov.Index > count {
+		errs = append(errs, fmt.Sprintf("%s.index %d is beyond nodes.count %d", name, ov.Index, count))
+	}
+	if ov.Role != "" && ov.Role != RoleControlPlane && ov.Role != RoleWorker {
+		errs = append(errs, fmt.Sprintf("%s.role %q is unknown (expected %q or %q)", name, ov.Role, RoleControlPlane, RoleWorker))
+	}
+	if ov.Memory != "" && !memoryRe.MatchString(ov.Memory) {
+		errs = append(errs, fmt.Sprintf("%s.memory %q is not a valid amount (e.g. 4096M, 4G)", name, ov.Memory))
+	}
+	return errs
+}
+
+func (n Nodes) validateProvisionOrder() []string {
+	errs := []string{}
+	known := make(map[string]bool)
+	for idx := uint(1); idx <= n.Count; idx++ {
+		known[NodeName(idx)] = true
+	}
+	seen := make(map[string]bool)
+	for idx, stage := range n.ProvisionOrder {
+		if len(stage) == 0 {
+			errs = append(errs, fmt.Sprintf("nodes.provisionOrder[%d] is empty", idx))
+		}
+		for _, name := range stage {
+			if seen[name] {
+				errs = append(errs, fmt.Sprintf("nodes.provisionOrder[%d]: %q is listed more than once", idx, name))
+				continue
+			}
+			seen[name] = true
+			if name != AllOtherNodes && !known[name] {
+				errs = append(errs, fmt.Sprintf("nodes.provisionOrder[%d]: unknown node %q", idx, name))
+			}
+		}
+	}
+	return errs
+}
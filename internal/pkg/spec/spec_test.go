@@ -0,0 +1,182 @@
+package spec_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/spec"
+)
+
+var _ = Describe("spec", func() {
+	Context("read", func() {
+		It("Should fill the missing fields with defaults", func() {
+			cs, err := spec.Read(strings.NewReader(`
+apiVersion: pack8s/v1alpha1
+provider: kubevirtci/k8s-1.16.2
+nodes:
+  count: 3
+services:
+  ceph:
+    enabled: true
+`))
+			Expect(err).To(BeNil())
+			Expect(cs.Provider).To(Equal("kubevirtci/k8s-1.16.2"))
+			Expect(cs.Nodes.Count).To(Equal(uint(3)))
+			Expect(cs.Nodes.Memory).To(Equal(spec.Default().Nodes.Memory))
+			Expect(cs.Ports.Random).To(Equal(true))
+			Expect(cs.WantsCeph()).To(Equal(true))
+			Expect(cs.WantsNFS()).To(Equal(false))
+			Expect(cs.Validate()).To(BeNil())
+		})
+
+		It("Should accept JSON", func() {
+			cs, err := spec.Read(strings.NewReader(`{"apiVersion": "pack8s/v1alpha1", "provider": "k8s", "nodes": {"cpu": 4}}`))
+			Expect(err).To(BeNil())
+			Expect(cs.Nodes.CPU).To(Equal(uint(4)))
+		})
+
+		It("Should reject unknown fields", func() {
+			_, err := spec.Read(strings.NewReader("apiVersion: pack8s/v1alpha1\nnodez: 3\n"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("validate", func() {
+		It("Should report all the errors", func() {
+			cs := spec.Default()
+			cs.APIVersion = "pack8s/v0"
+			cs.Nodes.Count = 0
+			cs.Nodes.Memory = "lots"
+			cs.Ports.SSH = 2222
+			cs.Ports.K8s = 2222
+			cs.Ports.VNC = 70000
+
+			err := cs.Validate()
+			Expect(err).NotTo(BeNil())
+			for _, msg := range []string{"apiVersion", "provider", "nodes.count", "nodes.memory", "ports.k8s", "ports.vnc"} {
+				Expect(err.Error()).To(ContainSubstring(msg))
+			}
+		})
+	})
+
+	Context("node overrides", func() {
+		It("Should parse the override selectors", func() {
+			ov, err := spec.ParseNodeOverride("node02:memory=8G,cpu=4")
+			Expect(err).To(BeNil())
+			Expect(ov.Index).To(Equal(uint(2)))
+			Expect(ov.Memory).To(Equal("8G"))
+			Expect(ov.CPU).To(Equal(uint(4)))
+
+			ov, err = spec.ParseNodeOverride("3:secondary-nics=0")
+			Expect(err).To(BeNil())
+			Expect(ov.Index).To(Equal(uint(3)))
+			Expect(*ov.SecondaryNics).To(Equal(uint(0)))
+
+			ov, err = spec.ParseNodeOverride("worker:cpu=1,qemu-args=-device virtio-rng-pci,max-bytes=1024")
+			Expect(err).To(BeNil())
+			Expect(ov.Role).To(Equal(spec.RoleWorker))
+			Expect(ov.QemuArgs).To(Equal("-device virtio-rng-pci,max-bytes=1024"))
+		})
+
+		It("Should reject malformed overrides", func() {
+			for _, s := range []string{"", "node02", "master:cpu=2", "node02:cpu", "node02:cpu=lots", "node02:disk=10G"} {
+				_, err := spec.ParseNodeOverride(s)
+				Expect(err).NotTo(BeNil(), s)
+			}
+		})
+
+		It("Should apply role overrides before index overrides", func() {
+			nics := uint(2)
+			nodes := spec.Default().Nodes
+			nodes.Count = 3
+			nodes.QemuArgs = "-smp sockets=1"
+			nodes.Overrides = []spec.NodeOverride{
+				{Index: 2, Memory: "16G"},
+				{Role: spec.RoleWorker, Memory: "2G", SecondaryNics: &nics, QemuArgs: "-vga none"},
+				{Role: spec.RoleControlPlane, CPU: 8},
+			}
+
+			res := nodes.Resources(1)
+			Expect(res.Memory).To(Equal(nodes.Memory))
+			Expect(res.CPU).To(Equal(uint(8)))
+			Expect(res.SecondaryNics).To(Equal(uint(0)))
+
+			res = nodes.Resources(2)
+			Expect(res.Memory).To(Equal("16G"))
+			Expect(res.CPU).To(Equal(nodes.CPU))
+			Expect(res.SecondaryNics).To(Equal(uint(2)))
+			Expect(res.QemuArgs).To(Equal("-smp sockets=1 -vga none"))
+
+			res = nodes.Resources(3)
+			Expect(res.Memory).To(Equal("2G"))
+
+			Expect(nodes.MaxSecondaryNics()).To(Equal(uint(2)))
+		})
+
+		It("Should validate the overrides", func() {
+			cs := spec.Default()
+			cs.Provider = "k8s"
+			cs.Nodes.Overrides = []spec.NodeOverride{
+				{Index: 2},
+				{Role: "master"},
+				{Index: 1, Role: spec.RoleWorker},
+			}
+			err := cs.Validate()
+			Expect(err).NotTo(BeNil())
+			for _, msg := range []string{"overrides[0].index", "overrides[1].role", "overrides[2] must select"} {
+				Expect(err.Error()).To(ContainSubstring(msg))
+			}
+		})
+	})
+
+	Context("provisioning order", func() {
+		It("Should provision node01 first by default", func() {
+			nodes := spec.Default().Nodes
+			nodes.Count = 3
+			Expect(nodes.ProvisionStages()).To(Equal([][]string{{"node01"}, {"node02", "node03"}}))
+
+			nodes.Reverse = true
+			Expect(nodes.ProvisionStages()).To(Equal([][]string{{"node02", "node03"}, {"node01"}}))
+		})
+
+		It("Should expand the wildcard and provision the unlisted nodes last", func() {
+			nodes := spec.Default().Nodes
+			nodes.Count = 4
+			nodes.ProvisionOrder = spec.ParseProvisionOrder("node02:node01,*")
+			Expect(nodes.ProvisionStages()).To(Equal([][]string{{"node02"}, {"node01", "node03", "node04"}}))
+
+			nodes.ProvisionOrder = spec.ParseProvisionOrder("node03")
+			Expect(nodes.ProvisionStages()).To(Equal([][]string{{"node03"}, {"node01", "node02", "node04"}}))
+		})
+
+		It("Should validate the order", func() {
+			cs := spec.Default()
+			cs.Provider = "k8s"
+			cs.Nodes.Count = 2
+			cs.Nodes.ProvisionOrder = spec.ParseProvisionOrder("node01,node01:node07:*,*")
+			err := cs.Validate()
+			Expect(err).NotTo(BeNil())
+			for _, msg := range []string{`"node01" is listed more than once`, `unknown node "node07"`, `"*" is listed more than once`} {
+				Expect(err.Error()).To(ContainSubstring(msg))
+			}
+		})
+	})
+
+	Context("write", func() {
+		It("Should roundtrip", func() {
+			cs := spec.Default()
+			cs.Provider = "k8s-1.16.2"
+			cs.Services.NFS.Data = "/srv/nfs"
+
+			var buf bytes.Buffer
+			Expect(cs.Write(&buf)).To(BeNil())
+
+			cs2, err := spec.Read(&buf)
+			Expect(err).To(BeNil())
+			Expect(cs2).To(Equal(cs))
+		})
+	})
+})
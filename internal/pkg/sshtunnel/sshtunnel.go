@@ -0,0 +1,197 @@
+package sshtunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+)
+
+const (
+	// Scheme is the scheme of the sockets reached through SSH, e.g. ssh://user@host/run/podman/io.podman
+	Scheme = "ssh"
+
+	// DefaultTimeout is how long to wait for the tunnel to be established
+	DefaultTimeout = 30 * time.Second
+
+	localSocketName = "podman.sock"
+)
+
+// Command is the ssh client opening the tunnels. Tests replace it.
+var Command = "ssh"
+
+// Address is a unix socket on a remote host, reached through SSH
+type Address struct {
+	User string
+	Host string
+	Port string
+	Path string
+}
+
+// IsRemote tells if the given socket must be reached through SSH
+func IsRemote(socket string) bool {
+	return strings.HasPrefix(socket, Scheme+"://")
+}
+
+// Parse parses sockets in the form ssh://[user@]host[:port]/path/to/socket
+func Parse(socket string) (Address, error) {
+	u, err := url.Parse(socket)
+	if err != nil {
+		return Address{}, err
+	}
+	if u.Scheme != Scheme {
+		return Address{}, fmt.Errorf("not a %s socket: %s", Scheme, socket)
+	}
+	if u.Hostname() == "" {
+		return Address{}, fmt.Errorf("missing host in socket: %s", socket)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return Address{}, fmt.Errorf("missing path in socket: %s", socket)
+	}
+	addr := Address{
+		Host: u.Hostname(),
+		Port: u.Port(),
+		Path: u.Path,
+	}
+	if u.User != nil {
+		addr.User = u.User.Username()
+	}
+	return addr, nil
+}
+
+func (a Address) String() string {
+	host := a.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if a.Port != "" {
+		host += ":" + a.Port
+	}
+	if a.User != "" {
+		host = a.User + "@" + host
+	}
+	return fmt.Sprintf("%s://%s%s", Scheme, host, a.Path)
+}
+
+// destination returns the destination as the ssh client expects it
+func (a Address) destination() string {
+	if a.User != "" {
+		return a.User + "@" + a.Host
+	}
+	return a.Host
+}
+
+// Tunnel forwards a local unix socket to a unix socket on a remote host, running the ssh client.
+// The client uses the configuration of the user, so the hosts, the keys and the agent work as usual.
+type Tunnel struct {
+	Address Address
+
+	dir    string
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	done   chan struct{}
+	err    error
+	log    *logger.Logger
+}
+
+// Open establishes a tunnel to the given address, waiting at most timeout for it to be ready.
+func Open(addr Address, timeout time.Duration, log *logger.Logger) (*Tunnel, error) {
+	dir, err := ioutil.TempDir("", "pack8s-ssh")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{
+		Address: addr,
+		dir:     dir,
+		done:    make(chan struct{}),
+		log:     log,
+	}
+
+	args := []string{
+		"-nNT",
+		// nobody can answer prompts: the ssh client runs in the background
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "StreamLocalBindUnlink=yes",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", int(timeout.Seconds())),
+		"-L", fmt.Sprintf("%s:%s", t.path(), addr.Path),
+	}
+	if addr.Port != "" {
+		args = append(args, "-p", addr.Port)
+	}
+	args = append(args, addr.destination())
+
+	t.cmd = exec.Command(Command, args...)
+	t.cmd.Stderr = &t.stderr
+	t.cmd.SysProcAttr = sysProcAttr()
+	log.Debugf("opening the tunnel to %s: %s %s", addr, Command, strings.Join(args, " "))
+	if err := t.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("cannot run %s: %v", Command, err)
+	}
+	go func() {
+		t.err = t.cmd.Wait()
+		close(t.done)
+	}()
+
+	if err := t.waitReady(timeout); err != nil {
+		t.Close()
+		return nil, err
+	}
+	log.Infof("tunnel to %s open at %s", addr, t.path())
+	return t, nil
+}
+
+func (t *Tunnel) path() string {
+	return filepath.Join(t.dir, localSocketName)
+}
+
+// waitReady waits for the ssh client to listen on the local socket
+func (t *Tunnel) waitReady(timeout time.Duration) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		if _, err := os.Stat(t.path()); err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-t.done:
+			return fmt.Errorf("cannot open the tunnel to %s: %v: %s", t.Address, t.err, strings.TrimSpace(t.stderr.String()))
+		case <-deadline:
+			return fmt.Errorf("cannot open the tunnel to %s: timed out after %v", t.Address, timeout)
+		}
+	}
+}
+
+// Socket returns the local end of the tunnel, e.g. unix:/tmp/pack8s-ssh123/podman.sock
+func (t *Tunnel) Socket() string {
+	return "unix:" + t.path()
+}
+
+// Close stops the ssh client and removes the local socket
+func (t *Tunnel) Close() error {
+	select {
+	case <-t.done:
+	default:
+		t.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-t.done:
+		case <-time.After(5 * time.Second):
+			t.cmd.Process.Kill()
+			<-t.done
+		}
+	}
+	t.log.Debugf("tunnel to %s closed", t.Address)
+	return os.RemoveAll(t.dir)
+}
@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package sshtunnel
+
+import (
+	"syscall"
+)
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		// a Ctrl-C must not break the tunnel while pack8s cleans up...
+		Setpgid: true,
+		// ...but the tunnel must not outlive pack8s
+		Pdeathsig: syscall.SIGTERM,
+	}
+}
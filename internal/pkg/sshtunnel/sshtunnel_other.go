@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package sshtunnel
+
+import (
+	"syscall"
+)
+
+// sysProcAttr keeps the ssh client out of the reach of Ctrl-C, so the tunnel works while pack8s cleans up.
+// Without Pdeathsig the tunnel outlives pack8s unless closed: CloseTunnels must run on exit.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
@@ -0,0 +1,78 @@
+package sshtunnel_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	// fakeSSHEnv makes the test binary act as the ssh client: "forward" or "fail"
+	fakeSSHEnv = "PACK8S_FAKE_SSH"
+	// fakeSSHArgsEnv is the file the fake ssh client writes its arguments into
+	fakeSSHArgsEnv = "PACK8S_FAKE_SSH_ARGS"
+)
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeSSHEnv); mode != "" {
+		os.Exit(fakeSSH(mode, os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func TestSSHTunnel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SSH Tunnel Suite")
+}
+
+// fakeSSH forwards the -L local:remote sockets on the local host, like ssh would do on the remote one
+func fakeSSH(mode string, args []string) int {
+	if path := os.Getenv(fakeSSHArgsEnv); path != "" {
+		ioutil.WriteFile(path, []byte(strings.Join(args, " ")), 0644)
+	}
+	if mode == "fail" {
+		fmt.Fprintln(os.Stderr, "Permission denied (publickey).")
+		return 255
+	}
+
+	var forward string
+	for idx, arg := range args {
+		if arg == "-L" && idx+1 < len(args) {
+			forward = args[idx+1]
+		}
+	}
+	paths := strings.SplitN(forward, ":", 2)
+	if len(paths) != 2 {
+		fmt.Fprintf(os.Stderr, "bad forward: %q\n", forward)
+		return 255
+	}
+
+	ln, err := net.Listen("unix", paths[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 255
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return 0
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			remote, err := net.Dial("unix", paths[1])
+			if err != nil {
+				return
+			}
+			defer remote.Close()
+			go io.Copy(remote, conn)
+			io.Copy(conn, remote)
+		}(conn)
+	}
+}
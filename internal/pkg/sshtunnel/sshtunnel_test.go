@@ -0,0 +1,138 @@
+package sshtunnel_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	logger "github.com/apsdehal/go-logger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/fromanirh/pack8s/internal/pkg/sshtunnel"
+)
+
+var _ = Describe("address", func() {
+	It("Should tell the remote sockets", func() {
+		Expect(sshtunnel.IsRemote("ssh://core@example.com/run/podman/io.podman")).To(BeTrue())
+		Expect(sshtunnel.IsRemote("unix:/run/podman/io.podman")).To(BeFalse())
+		Expect(sshtunnel.IsRemote("/run/podman/podman.sock")).To(BeFalse())
+	})
+
+	It("Should parse the remote sockets", func() {
+		addr, err := sshtunnel.Parse("ssh://core@example.com:2222/run/podman/io.podman")
+		Expect(err).To(BeNil())
+		Expect(addr).To(Equal(sshtunnel.Address{
+			User: "core",
+			Host: "example.com",
+			Port: "2222",
+			Path: "/run/podman/io.podman",
+		}))
+		Expect(addr.String()).To(Equal("ssh://core@example.com:2222/run/podman/io.podman"))
+
+		addr, err = sshtunnel.Parse("ssh://[fd00::1]/run/podman/io.podman")
+		Expect(err).To(BeNil())
+		Expect(addr.Host).To(Equal("fd00::1"))
+		Expect(addr.User).To(BeEmpty())
+		Expect(addr.Port).To(BeEmpty())
+		Expect(addr.String()).To(Equal("ssh://[fd00::1]/run/podman/io.podman"))
+	})
+
+	It("Should reject the incomplete sockets", func() {
+		for _, socket := range []string{
+			"unix:/run/podman/io.podman",
+			"ssh:///run/podman/io.podman",
+			"ssh://core@example.com",
+			"ssh://core@example.com/",
+		} {
+			_, err := sshtunnel.Parse(socket)
+			Expect(err).NotTo(BeNil(), socket)
+		}
+	})
+})
+
+var _ = Describe("tunnel", func() {
+	var tmpDir string
+	var remote net.Listener
+	var log *logger.Logger
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "pack8s-sshtunnel")
+		Expect(err).To(BeNil())
+		log, err = logger.New("test", 0, logger.ErrorLevel, ioutil.Discard)
+		Expect(err).To(BeNil())
+
+		// the "remote" socket echoes back the lines it gets
+		remote, err = net.Listen("unix", filepath.Join(tmpDir, "io.podman"))
+		Expect(err).To(BeNil())
+		go func() {
+			for {
+				conn, err := remote.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					line, err := bufio.NewReader(conn).ReadString('\n')
+					if err == nil {
+						conn.Write([]byte(line))
+					}
+				}()
+			}
+		}()
+
+		sshtunnel.Command = os.Args[0]
+		os.Setenv(fakeSSHArgsEnv, filepath.Join(tmpDir, "args"))
+	})
+
+	AfterEach(func() {
+		sshtunnel.Command = "ssh"
+		os.Unsetenv(fakeSSHEnv)
+		os.Unsetenv(fakeSSHArgsEnv)
+		remote.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("Should forward the local socket to the remote one", func() {
+		os.Setenv(fakeSSHEnv, "forward")
+		addr := sshtunnel.Address{
+			User: "core",
+			Host: "example.com",
+			Port: "2222",
+			Path: filepath.Join(tmpDir, "io.podman"),
+		}
+		t, err := sshtunnel.Open(addr, 10*time.Second, log)
+		Expect(err).To(BeNil())
+
+		args, err := ioutil.ReadFile(filepath.Join(tmpDir, "args"))
+		Expect(err).To(BeNil())
+		Expect(string(args)).To(ContainSubstring("-p 2222 core@example.com"))
+		Expect(string(args)).To(ContainSubstring("BatchMode=yes"))
+
+		Expect(t.Socket()).To(HavePrefix("unix:"))
+		conn, err := net.Dial("unix", strings.TrimPrefix(t.Socket(), "unix:"))
+		Expect(err).To(BeNil())
+		_, err = conn.Write([]byte("ping\n"))
+		Expect(err).To(BeNil())
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		Expect(err).To(BeNil())
+		Expect(line).To(Equal("ping\n"))
+		conn.Close()
+
+		Expect(t.Close()).To(Succeed())
+		_, err = os.Stat(strings.TrimPrefix(t.Socket(), "unix:"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("Should report why the ssh client failed", func() {
+		os.Setenv(fakeSSHEnv, "fail")
+		_, err := sshtunnel.Open(sshtunnel.Address{Host: "example.com", Path: "/run/podman/io.podman"}, 10*time.Second, log)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Permission denied (publickey)"))
+	})
+})